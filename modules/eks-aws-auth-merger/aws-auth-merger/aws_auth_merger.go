@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gruntwork-io/gruntwork-cli/errors"
@@ -13,8 +18,15 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const (
@@ -29,40 +41,133 @@ const (
 	sourcesAnnotationKey         = "gruntwork.io/aws-auth-merger-sources"
 	autoCreateAnnotationKey      = "gruntwork.io/aws-auth-merger-created"
 	mergedTimestampAnnotationKey = "gruntwork.io/aws-auth-merger-timestamp"
+	contentHashAnnotationKey     = "gruntwork.io/aws-auth-merger-hash"
+	managedMappingsAnnotationKey = "gruntwork.io/aws-auth-merger-managed-mappings"
+
+	// This annotation can be set on the source ConfigMaps to control which mapping wins when there is a conflict and
+	// the merger is configured with the priority conflict strategy. Higher values win. Defaults to 0 if unset.
+	priorityAnnotationKey = "gruntwork.io/aws-auth-merger-priority"
+	// This annotation is set by the merger on source ConfigMaps that were quarantined, with the reason they were
+	// excluded from the merge.
+	rejectedAnnotationKey = "gruntwork.io/aws-auth-merger-rejected"
 
 	// aws-auth ConfigMap data keys
-	mapRolesKey = "mapRoles"
-	mapUsersKey = "mapUsers"
+	mapRolesKey    = "mapRoles"
+	mapUsersKey    = "mapUsers"
+	mapAccountsKey = "mapAccounts"
 
 	preExistingConfigMapCreateName = "preexisting-aws-auth"
 )
 
+// upsertAction describes what upsertConfigMap did to bring the ConfigMap up to date.
+type upsertAction string
+
+const (
+	upsertActionCreated   upsertAction = "created"
+	upsertActionUpdated   upsertAction = "updated"
+	upsertActionUnchanged upsertAction = "unchanged"
+)
+
+// upsertBackoff is the backoff used to retry the upsert of the main aws-auth ConfigMap when it is concurrently
+// modified by something else (e.g., EKS adding a Managed Node Group). With these settings, the upsert is attempted up
+// to 6 times over roughly 6 seconds.
+var upsertBackoff = wait.Backoff{
+	Steps:    6,
+	Duration: 200 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
 type AwsAuthMerger struct {
 	// Set from CLI
-	// Namespace to watch for ConfigMaps to merge.
+	// Namespace to watch for ConfigMaps to merge. This is also where the merger creates the ConfigMaps that it manages.
 	namespace string
+	// Additional Namespaces to watch for ConfigMaps to merge, and a label selector for Namespaces to watch. The
+	// ConfigMaps in these Namespaces are merged along with those in the watch Namespace.
+	sourceNamespaces        []string
+	sourceNamespaceSelector string
 	// Label Selector to use when looking up ConfigMaps to merge.
 	labelSelector string
+	// Whether to also merge the Secrets that match the label selector in the source Namespaces, along with the
+	// ConfigMaps.
+	watchSecrets bool
+	// Whether to also merge the IAMIdentityMapping custom resources that match the label selector in the source
+	// Namespaces.
+	watchIAMIdentityMappings bool
+	// Directory of files with mappings to merge along with the sources in the Kubernetes API. Disabled if empty.
+	sourceDirectory string
 	// Labels to apply to any ConfigMaps that are autocreated. For example, when there is a manually managed aws-auth
 	// ConfigMap that already exists, this tool will automatically migrate that to the merge Namespace so that the
 	// preexisting roles and users are included in the final map.
 	autoCreateLabels map[string]string
 	// How often to poll the Namespace for aws-auth ConfigMaps
 	refreshInterval time.Duration
+	// How to retry failed syncs. The delay between retries doubles from the base delay up to the max delay, and the
+	// merger gives up on the sync after the max number of retries until the next change or refresh interval.
+	syncRetryBaseDelay time.Duration
+	syncRetryMaxDelay  time.Duration
+	syncMaxRetries     int
+	// How to handle the same ARN showing up in multiple ConfigMaps.
+	conflictStrategy conflictStrategy
+	// Whether to exclude invalid ConfigMaps from the merge instead of failing.
+	quarantineInvalidSources bool
+	// What to do with entries in the main aws-auth ConfigMap that were added outside of the merger.
+	driftPolicy driftPolicy
+	// Whether to adopt the worker node entries that EKS adds to the main aws-auth ConfigMap, regardless of the drift
+	// policy.
+	adoptEksNodeMappings bool
+	// How long to keep mappings that were removed from all the sources in the main aws-auth ConfigMap. Disabled if 0.
+	removalGracePeriod time.Duration
+	// Safety checks to evaluate before writing the merged ConfigMap.
+	lockoutGuards lockoutGuards
+	// How to elect the replica that syncs the ConfigMaps when running multiple replicas.
+	leaderElection leaderElectionConfig
+	// Whether to verify the ConfigMaps read from the informer cache against a direct read from the Kubernetes API on
+	// every sync.
+	verifyInformerCache bool
+	// Port to serve the Prometheus metrics on. Disabled if 0.
+	metricsPort int
+	// Port to serve the liveness and readiness probes on. Disabled if 0.
+	healthPort int
+	// How many refresh intervals may pass without a successful sync before the liveness probe fails.
+	livenessMultiplier int
+	// How long to wait for an in-flight sync to complete after receiving a shutdown signal, before cancelling the
+	// requests to the Kubernetes API.
+	shutdownTimeout time.Duration
 
 	// K8s auth params
 	kubeconfig  string
 	kubecontext string
 
 	// Internally set
-	logger    *logrus.Logger
-	clientset *kubernetes.Clientset
-	ctx       context.Context
+	logger          *logrus.Logger
+	clientset       kubernetes.Interface
+	configMapLister corelisters.ConfigMapLister
+	secretLister    corelisters.SecretLister
+	namespaceLister corelisters.NamespaceLister
+	metrics         *mergerMetrics
+	health          *healthChecker
+	recorder        record.EventRecorder
+	ctx             context.Context
+
+	// The queue that the merge loop processes syncs from. Only set while the merge loop is running.
+	syncQueue workqueue.RateLimitingInterface
+
+	// The dynamic client and lister for the IAMIdentityMapping custom resources. Only set when watching them.
+	dynamicClient            dynamic.Interface
+	iamIdentityMappingLister cache.GenericLister
+
+	// The resource versions of the source ConfigMaps that the last Accepted Event was recorded for, keyed by source name.
+	acceptedVersions map[string]string
+
+	// The mappings that were adopted into the main aws-auth ConfigMap, but could not be copied into their source
+	// ConfigMaps yet. See persistAdoptions.
+	pendingAdoptions []mappingAdoption
 }
 
-// newK8sClientset returns a Kubernetes API client set that can be used to make API calls to the Kubernetes cluster.
-// Uses in-cluster mode if kubeconfig is not set.
-func (authMerger *AwsAuthMerger) newK8sClientset() (*kubernetes.Clientset, error) {
+// newK8sRestConfig returns the config of the Kubernetes API clients that can be used to make API calls to the
+// Kubernetes cluster. Uses in-cluster mode if kubeconfig is not set.
+func (authMerger *AwsAuthMerger) newK8sRestConfig() (*rest.Config, error) {
 	var config *rest.Config
 	if authMerger.kubeconfig != "" {
 		authMerger.logger.Infof("Kubeconfig is set so will source credentials from kubeconfig %s", authMerger.kubeconfig)
@@ -83,29 +188,76 @@ func (authMerger *AwsAuthMerger) newK8sClientset() (*kubernetes.Clientset, error
 		}
 		config = rawConfig
 	}
-
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, errors.WithStackTrace(err)
-	}
-	return clientset, nil
+	return config, nil
 }
 
-// eventLoop is the main event handler loop. This will start a routine that will:
-// - Check and migrate if a manually managed aws-auth ConfigMap exists, so that we don't overwrite it and lose the
-//   information.
-// - Merge and sync the initial set of aws-auth ConfigMaps in the Namespace.
-// - Watch for changes to the aws-auth ConfigMaps in the Namespace and sync everytime a change is detected.
-// - Start a polling routine that will sync the ConfigMap even if there was no change.
+// eventLoop is the main event handler loop. This will authenticate to Kubernetes, and then run the merge loop until the
+// process receives a shutdown signal. If leader election is enabled, the merge loop only runs while this replica holds
+// the leader Lease.
 func (authMerger *AwsAuthMerger) eventLoop() error {
 	authMerger.logger = getProjectLogger()
 	authMerger.logConfig()
 
+	ctx, stop := authMerger.handleShutdownSignals()
+	defer stop()
+
+	if authMerger.metricsPort != 0 {
+		authMerger.metrics = newMergerMetrics()
+		// This has to be set before the sync queue is created. The provider can only be set once per process.
+		workqueue.SetProvider(authMerger.metrics.workqueue)
+		if err := authMerger.startMetricsServer(ctx); err != nil {
+			authMerger.logger.Errorf("Error while starting the metrics server on port %d", authMerger.metricsPort)
+			return err
+		}
+	}
+
+	if authMerger.healthPort != 0 {
+		livenessThreshold := time.Duration(authMerger.livenessMultiplier) * authMerger.refreshInterval
+		authMerger.health = newHealthChecker(livenessThreshold)
+		if err := authMerger.startHealthServer(ctx); err != nil {
+			authMerger.logger.Errorf("Error while starting the health probe server on port %d", authMerger.healthPort)
+			return err
+		}
+	}
+
 	if err := authMerger.setK8sClientset(); err != nil {
 		return err
 	}
 	authMerger.logger.Info("Successfully authenticated to Kubernetes API")
 
+	recorder, stopRecording := authMerger.newEventRecorder()
+	defer stopRecording()
+	authMerger.recorder = recorder
+
+	var err error
+	if authMerger.leaderElection.enabled {
+		err = authMerger.runWithLeaderElection(ctx)
+	} else {
+		authMerger.metrics.setLeader(true)
+		err = authMerger.mergeLoop(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	authMerger.logger.Info("Shut down aws-auth-merger.")
+	return nil
+}
+
+// mergeLoop will start a routine that will:
+// - Check and migrate if a manually managed aws-auth ConfigMap exists, so that we don't overwrite it and lose the
+//   information.
+// - Merge and sync the initial set of aws-auth ConfigMaps in the source Namespaces.
+// - Watch for changes to the aws-auth ConfigMaps in the source Namespaces and sync everytime a change is detected.
+// - Start a polling routine that will sync the ConfigMap even if there was no change.
+// Errors while syncing are retried with exponential backoff, so this only returns an error if the merger can not be set
+// up. Otherwise, the loop runs until the given context is done, which happens when the process is shutting down or
+// leadership is lost. A sync that is in progress at that point is finished before returning, while syncs that are
+// queued, or still waiting for the debounce interval or a retry, are abandoned, since the next merger to start does an
+// initial sync anyway.
+func (authMerger *AwsAuthMerger) mergeLoop(ctx context.Context) error {
+	authMerger.health.startedMergeLoop()
+	defer authMerger.health.stoppedMergeLoop()
+
 	configmap, err := authMerger.migratePreExistingConfigMap()
 	if err != nil {
 		authMerger.logger.Errorf("Error while checking for and migrating a manually configured aws-auth ConfigMap: %s", err)
@@ -118,63 +270,100 @@ func (authMerger *AwsAuthMerger) eventLoop() error {
 		authMerger.logger.Infof("Migrated existing configuration to ConfigMap %s in Namespace %s.", configmap.Name, configmap.Namespace)
 	}
 
-	if err := authMerger.syncAwsAuthConfigMaps(); err != nil {
-		return err
-	}
+	// All syncs go through a rate limited workqueue, so that failed syncs are retried with exponential backoff instead
+	// of exiting. We enqueue the initial sync before starting the watcher so that it happens as soon as possible.
+	queue := newSyncQueue(authMerger.syncRetryBaseDelay, authMerger.syncRetryMaxDelay)
+	defer queue.ShutDown()
+	queue.Add(syncQueueKey)
+	authMerger.syncQueue = queue
 
 	// Start the controller in the background to stream watch events. We use an informer instead of a watcher here to
-	// ensure we can recover from API based recoverable errors.
-	stopChan := make(chan struct{})
-	defer close(stopChan)
-	notifyChan := make(chan struct{})
+	// ensure we can recover from API based recoverable errors. The informer is stopped when the loop exits.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	controller := NewConfigMapWatchController(
 		authMerger.logger,
 		authMerger.clientset,
-		authMerger.namespace,
+		authMerger.explicitSourceNamespaces(),
 		authMerger.labelSelector,
-		notifyChan,
+		authMerger.sourceNamespaceSelector,
+		authMerger.watchSecrets,
+		authMerger.dynamicClient,
+		queue,
 	)
-	if err := controller.Run(stopChan); err != nil {
-		authMerger.logger.Errorf("Error while setting up watcher for ConfigMaps in Namespace %s and label selector %s", authMerger.namespace, authMerger.labelSelector)
+	if err := controller.Run(ctx.Done()); err != nil {
+		if ctx.Err() != nil {
+			authMerger.logger.Info("Stopped before the watcher for ConfigMaps was set up.")
+			return nil
+		}
+		authMerger.logger.Errorf("Error while setting up watcher for ConfigMaps in %s and label selector %s", authMerger.describeSourceNamespaces(), authMerger.labelSelector)
 		return err
 	}
+	// The syncs read the ConfigMaps from the informer cache from now on. We only set this once the cache is synced, so
+	// that the first sync does not see a partial list.
+	authMerger.configMapLister = controller.Lister()
+	authMerger.secretLister = controller.SecretLister()
+	authMerger.iamIdentityMappingLister = controller.IAMIdentityMappingLister()
+	authMerger.namespaceLister = controller.NamespaceLister()
+	authMerger.health.setInformersSynced(controller.HasSynced)
+	authMerger.logger.Infof("Successfully set up watcher for ConfigMaps in %s and label selector %s", authMerger.describeSourceNamespaces(), authMerger.labelSelector)
+
+	if authMerger.sourceDirectory != "" {
+		sourceWatcher, err := NewSourceDirectoryWatcher(authMerger.logger, authMerger.sourceDirectory, queue)
+		if err != nil {
+			return err
+		}
+		go sourceWatcher.Run(ctx.Done())
+		authMerger.logger.Infof("Successfully set up watcher for source directory %s", authMerger.sourceDirectory)
+	}
 
-	// Setup a debouncer for syncing
-	authMerger.logger.Infof("Successfully set up watcher for ConfigMaps in Namespace %s and label selector %s", authMerger.namespace, authMerger.labelSelector)
-	debouncedSync, syncErrChan := debounceNoArgsFunc(authMerger.logger, 1*time.Second, authMerger.syncAwsAuthConfigMaps)
-
-	// Main handler. This watches for events on the watcher channel, scheduling a call to sync every time an event comes
-	// in. Note that we debounce the call so that if there are multiple events that happen concurrently, we don't spam
-	// the sync call for every event.
-	ticker := time.NewTicker(authMerger.refreshInterval)
-	for {
-		select {
-		// We ignore the items in the watcher channel because the sync call will list the latest data. All we care about here is
-		// the notification that something has changed.
-		case <-notifyChan:
-			authMerger.logger.Infof("Detected change in aws-auth ConfigMaps in Namespace %s and label selector %s", authMerger.namespace, authMerger.labelSelector)
-			debouncedSync()
-		case tick := <-ticker.C:
-			tickUTC := tick.UTC()
-			tickUTCStr := tickUTC.Format("2006-01-02T15:04:05Z")
-			authMerger.logger.Infof("Refresh interval reached (%s): performing forced sync.", tickUTCStr)
-			debouncedSync()
-		case err := <-syncErrChan:
-			if err != nil {
-				return err
+	// Start a polling routine in the background that enqueues a sync every refresh interval, and shuts down the queue
+	// when the context is done so that the worker loop below exits.
+	go func() {
+		ticker := time.NewTicker(authMerger.refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case tick := <-ticker.C:
+				tickUTC := tick.UTC()
+				tickUTCStr := tickUTC.Format("2006-01-02T15:04:05Z")
+				authMerger.logger.Infof("Refresh interval reached (%s): performing forced sync.", tickUTCStr)
+				queue.Add(syncQueueKey)
+			case <-ctx.Done():
+				authMerger.logger.Info("Stopping sync of aws-auth ConfigMaps.")
+				queue.ShutDown()
+				return
 			}
 		}
+	}()
+
+	// Main handler. This processes the syncs that are enqueued by the watcher and the polling routine one at a time,
+	// until the context is done or the queue is shut down.
+	for authMerger.processNextSync(ctx, queue) {
 	}
+	return nil
 }
 
-// setK8sClientset will set the Kubernetes clientset on the AwsAuthMerger object so that API calls can be made.
+// setK8sClientset will set the Kubernetes clientset on the AwsAuthMerger object so that API calls can be made. When
+// watching IAMIdentityMappings, this also sets the dynamic client that is used to read the custom resources.
 func (authMerger *AwsAuthMerger) setK8sClientset() error {
-	clientset, err := authMerger.newK8sClientset()
+	config, err := authMerger.newK8sRestConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return errors.WithStackTrace(err)
 	}
 	authMerger.clientset = clientset
-	authMerger.ctx = context.Background()
+
+	if authMerger.watchIAMIdentityMappings {
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return errors.WithStackTrace(err)
+		}
+		authMerger.dynamicClient = dynamicClient
+	}
 	return nil
 }
 
@@ -190,16 +379,85 @@ func (authMerger *AwsAuthMerger) getMainAwsAuthConfigMap() (*corev1.ConfigMap, e
 	return configmap, nil
 }
 
-// listAwsAuthConfigMaps will lookup the AWS Auth ConfigMaps that should be merged together.
+// listAwsAuthConfigMaps will lookup the AWS Auth ConfigMaps that should be merged together. Once the watcher is set up,
+// the ConfigMaps are read from the informer cache, which the watcher keeps up to date, instead of listing them from the
+// Kubernetes API on every sync. Optionally, the cache is verified against a direct read from the API. The files in the
+// source directory are read from disk on every sync, and returned after the sources from the Kubernetes API.
 func (authMerger *AwsAuthMerger) listAwsAuthConfigMaps() ([]corev1.ConfigMap, error) {
-	configmapList, err := authMerger.clientset.CoreV1().ConfigMaps(authMerger.namespace).List(authMerger.ctx, metav1.ListOptions{LabelSelector: authMerger.labelSelector})
+	var configmaps []corev1.ConfigMap
+	var err error
+	switch {
+	case authMerger.configMapLister == nil:
+		configmaps, err = authMerger.listAwsAuthConfigMapsFromAPI()
+	case !authMerger.verifyInformerCache:
+		configmaps, err = authMerger.listAwsAuthConfigMapsFromCache()
+	default:
+		var cached []corev1.ConfigMap
+		cached, err = authMerger.listAwsAuthConfigMapsFromCache()
+		if err == nil {
+			configmaps, err = authMerger.verifyCachedConfigMaps(cached)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if authMerger.sourceDirectory == "" {
+		return configmaps, nil
+	}
+	files, err := listSourceDirectory(authMerger.sourceDirectory)
+	if err != nil {
+		return nil, err
+	}
+	return append(configmaps, files...), nil
+}
+
+// listAwsAuthConfigMapsFromAPI will list the AWS Auth ConfigMaps that should be merged together from the Kubernetes
+// API, paginating through the results. When watching multiple Namespaces, the ConfigMaps are listed across the cluster
+// and filtered by the source Namespaces. When watching Secrets and IAMIdentityMappings, those are listed as well, and
+// returned after the ConfigMaps.
+func (authMerger *AwsAuthMerger) listAwsAuthConfigMapsFromAPI() ([]corev1.ConfigMap, error) {
+	var configmaps []corev1.ConfigMap
+	if !authMerger.watchesMultipleNamespaces() {
+		allConfigMaps, err := authMerger.listConfigMapsFromAPI(authMerger.namespace)
+		if err != nil {
+			return nil, err
+		}
+		configmaps = allConfigMaps
+	} else {
+		namespaces, err := authMerger.listSourceNamespaces()
+		if err != nil {
+			return nil, err
+		}
+		allConfigMaps, err := authMerger.listConfigMapsFromAPI(metav1.NamespaceAll)
+		if err != nil {
+			return nil, err
+		}
+		configmaps = filterConfigMapsByNamespace(allConfigMaps, namespaces)
+	}
+
+	secrets, err := authMerger.listAwsAuthSecretsFromAPI()
+	if err != nil {
+		return nil, err
+	}
+	mappings, err := authMerger.listAwsAuthIAMIdentityMappingsFromAPI()
+	if err != nil {
+		return nil, err
+	}
+	return append(append(configmaps, secrets...), mappings...), nil
+}
+
+// listConfigMapsFromAPI will list the ConfigMaps with the configured label selector in the given Namespace from the
+// Kubernetes API, paginating through the results. Pass metav1.NamespaceAll to list across all Namespaces.
+func (authMerger *AwsAuthMerger) listConfigMapsFromAPI(namespace string) ([]corev1.ConfigMap, error) {
+	configmapList, err := authMerger.clientset.CoreV1().ConfigMaps(namespace).List(authMerger.ctx, metav1.ListOptions{LabelSelector: authMerger.labelSelector})
 	if err != nil {
 		return nil, errors.WithStackTrace(err)
 	}
 
 	allConfigMaps := configmapList.Items
 	for configmapList.Continue != "" {
-		configmapList, err = authMerger.clientset.CoreV1().ConfigMaps(authMerger.namespace).List(
+		configmapList, err = authMerger.clientset.CoreV1().ConfigMaps(namespace).List(
 			authMerger.ctx,
 			metav1.ListOptions{LabelSelector: authMerger.labelSelector, Continue: configmapList.Continue},
 		)
@@ -250,90 +508,215 @@ func (authMerger *AwsAuthMerger) migratePreExistingConfigMap() (*corev1.ConfigMa
 // syncAwsAuthConfigMaps will lookup all the aws-auth ConfigMaps that should be merged in the configured Namespace,
 // merge them, and upsert the main aws-auth ConfigMap in kube-system Namespace.
 //
-// Note that this currently ignores manual changes made to the central ConfigMap outside of the merger. We intentionally
-// do NOT handle this situation to keep the code simple. We can enhance the functionality of the merger in the future if
-// this becomes a problem. However, we expect that this is unlikely:
+// Changes made to the central ConfigMap outside of the merger (drift) are detected when upserting, and handled according
+// to the configured drift policy. The merger tracks the mappings it manages in an annotation on the central ConfigMap,
+// so that entries added by something else can be told apart from entries that were removed from the sources. There are
+// two common sources of drift:
 //
-// - For manual updates by humans, the work will be lost. This can be a frustrating experience for the user. However,
-//   the hope is that users will be encouraged and educated to manage the ConfigMap by code, thereby reducing the risk
-//   of out of band updates. This action (making updates manually to the central ConfigMap) will be similar to out of
-//   band AWS updates in the console in a terraform managed world, and should be known to cause issues in an IaC centric
-//   operation. The hope is that users who are using Gruntwork will already be in an IaC mindset such that it should be
-//   rare for the user to manually edit this ConfigMap.
-// - For automated updates by EKS, there are several ways this can fail, most notably when adding a Managed Node Group
-//   or Fargate profile for the first time after the aws-auth-merger is deployed. EKS will make changes to the central
-//   ConfigMap in these scenarios, and those will be lost when the merger syncs the distributed ConfigMaps. To handle
-//   this, we recommend users include the Fargate profile execution IAM role and Managed Node Group worker IAM roles in
-//   one of the distributed ConfigMaps so that they are merged in and not lost. See
+// - Manual updates by humans. The hope is that users will be encouraged and educated to manage the ConfigMap by code,
+//   but this can still happen, similar to out of band AWS updates in the console in a terraform managed world.
+// - Automated updates by EKS, most notably when adding a Managed Node Group or Fargate profile for the first time after
+//   the aws-auth-merger is deployed. If these updates are reverted, the new workers are locked out of the cluster. To
+//   handle this, the worker node entries added by EKS are adopted into a source ConfigMap regardless of the drift
+//   policy, unless disabled. See
 //   core-concepts.md#how-do-i-handle-conflicts-with-automatic-updates-by-eks for more info on this topic.
+//
+// Mappings that were removed from all the sources are kept for the configured removal grace period, so that a source
+// ConfigMap that is being replaced does not revoke access in the meantime. Before the merged ConfigMap is written, it
+// is checked against the configured lockout guards, and the write is refused
+// if it could lock the workers or the administrators out of the cluster. Once it is written, the source ConfigMap of
+// each merged mapping is recorded in the provenance ConfigMap, and the status of the merge on each source ConfigMap.
 func (authMerger *AwsAuthMerger) syncAwsAuthConfigMaps() error {
 	configmaps, err := authMerger.listAwsAuthConfigMaps()
 	if err != nil {
-		authMerger.logger.Errorf("Error while looking up aws-auth ConfigMaps in namespace %s with label selector %s", authMerger.namespace, authMerger.labelSelector)
+		authMerger.logger.Errorf("Error while looking up aws-auth ConfigMaps in %s with label selector %s", authMerger.describeSourceNamespaces(), authMerger.labelSelector)
 		return err
 	}
-	authMerger.logger.Infof("Found %d ConfigMaps in namespace %s with label selector %s", len(configmaps), authMerger.namespace, authMerger.labelSelector)
+	authMerger.logger.Infof("Found %d ConfigMaps in %s with label selector %s", len(configmaps), authMerger.describeSourceNamespaces(), authMerger.labelSelector)
 
-	merged, err := mergeAwsAuthConfigMaps(configmaps)
+	result, err := mergeAwsAuthConfigMaps(configmaps, authMerger.mergeOptions())
 	if err != nil {
-		authMerger.logger.Errorf("Error while merging %d aws-auth ConfigMaps in namespace %s with label selector %s", len(configmaps), authMerger.namespace, authMerger.labelSelector)
+		if conflict, isConflict := errors.Unwrap(err).(MappingConflictErr); isConflict {
+			authMerger.metrics.observeConflict(conflict.strategy)
+		}
+		authMerger.recordMergeErrEvents(configmaps, err)
+		authMerger.logger.Errorf("Error while merging %d aws-auth ConfigMaps in %s with label selector %s", len(configmaps), authMerger.describeSourceNamespaces(), authMerger.labelSelector)
 		return err
 	}
-	authMerger.logger.Infof("Successfully merged %d ConfigMaps in namespace %s with label selector %s", len(configmaps), authMerger.namespace, authMerger.labelSelector)
+	for _, conflict := range result.resolvedConflicts {
+		authMerger.logger.Warnf("Resolved mapping conflict using strategy %s: %s", conflict.strategy, conflict)
+		authMerger.metrics.observeConflict(conflict.strategy)
+	}
+	authMerger.metrics.observeSources(len(configmaps), len(result.rejected))
+	authMerger.recordMergeResultEvents(configmaps, result)
+	for name, reason := range result.rejected {
+		authMerger.logger.Errorf("Quarantined invalid ConfigMap %s: %s", name, reason)
+	}
+	authMerger.logger.Infof("Successfully merged %d ConfigMaps in %s with label selector %s", len(configmaps)-len(result.rejected), authMerger.describeSourceNamespaces(), authMerger.labelSelector)
 
-	created, err := authMerger.upsertConfigMap(merged)
+	existing, err := authMerger.getMainAwsAuthConfigMap()
+	if err != nil {
+		authMerger.logger.Error("Error while looking up existing aws-auth ConfigMap in kube-system Namespace.")
+		return err
+	}
+	merged, err := authMerger.applyRemovalGracePeriod(existing, result.merged)
 	if err != nil {
+		authMerger.logger.Error("Error while applying the removal grace period to the merged aws-auth ConfigMap.")
+		return err
+	}
+
+	written, action, err := authMerger.upsertConfigMap(merged)
+	if _, isGuardErr := errors.Unwrap(err).(LockoutGuardErr); isGuardErr {
+		authMerger.logger.Error("Merged aws-auth ConfigMap failed the lockout guards. The existing aws-auth ConfigMap in kube-system Namespace is left as is.")
+		authMerger.recordMainConfigMapEvent(existing, corev1.EventTypeWarning, eventReasonLockoutGuardTripped, err.Error())
+		return err
+	} else if err != nil {
 		authMerger.logger.Error("Error while upserting merged aws-auth ConfigMap in kube-system Namespace.")
 		return err
 	}
-	if created {
-		authMerger.logger.Infof("Created new aws-auth ConfigMaps using those in Namespace %s", authMerger.namespace)
-	} else {
-		authMerger.logger.Infof("Replaced existing aws-auth ConfigMaps using those in Namespace %s", authMerger.namespace)
+	authMerger.metrics.observeUpsert(action, written)
+	if action != upsertActionUnchanged {
+		authMerger.recordMainConfigMapEvent(existing, corev1.EventTypeNormal, eventReasonUpdated, fmt.Sprintf("ConfigMap was %s by merging %d ConfigMaps in %s.", action, len(configmaps)-len(result.rejected), authMerger.describeSourceNamespaces()))
+	}
+	authMerger.recordAcceptedEvents(configmaps, result)
+	switch action {
+	case upsertActionCreated:
+		authMerger.logger.Infof("Created new aws-auth ConfigMaps using those in %s", authMerger.describeSourceNamespaces())
+	case upsertActionUpdated:
+		authMerger.logger.Infof("Replaced existing aws-auth ConfigMaps using those in %s", authMerger.describeSourceNamespaces())
+	case upsertActionUnchanged:
+		authMerger.logger.Infof("Existing aws-auth ConfigMap is already up to date with those in %s", authMerger.describeSourceNamespaces())
+	}
+
+	// Failing to record the provenance and status does not affect the merged ConfigMap, so we only log the errors here
+	// instead of failing the sync. They will be retried on the next sync.
+	if err := authMerger.upsertProvenanceConfigMap(authMerger.withUnmergedProvenance(result.provenance, written)); err != nil {
+		authMerger.logger.Warnf("Error while recording the provenance of the merged mappings in ConfigMap %s in Namespace %s: %s", provenanceConfigMapName, mainAwsAuthConfigMapNamespace, err)
+	}
+	if err := authMerger.updateSourceStatusAnnotations(configmaps, result); err != nil {
+		authMerger.logger.Warnf("Error while recording the merge status on the source ConfigMaps: %s", err)
 	}
 	return nil
 }
 
+// mergeOptions returns the options to use when merging the aws-auth ConfigMaps, based on the configured settings.
+func (authMerger *AwsAuthMerger) mergeOptions() mergeOptions {
+	return mergeOptions{
+		conflictStrategy:   authMerger.conflictStrategy,
+		quarantineInvalid:  authMerger.quarantineInvalidSources,
+		qualifySourceNames: authMerger.watchesMultipleNamespaces(),
+	}
+}
+
 // upsertConfigMap will perform an upsert of the given ConfigMap. If the ConfigMap with the name and namespace exists,
-// this will update the existing one, while creating if it does not. Returns true if a new one was created.
+// this will update the existing one, while creating if it does not. The update is skipped if the existing ConfigMap
+// already has the same content, as determined by the content hash. Returns the ConfigMap as it was written, which
+// includes the changes made by the drift policy, and the action that was taken.
 //
-// Note that this upsert is NOT atomic and that is ok. Kubernetes doesn't provide a way to lock objects in the API, nor
-// does it provide an atomic upsert API, so this naively does a get call to check for existence, before doing create or
-// update. This means that if the ConfigMap is automatically or manually created between the time this routine does a
-// get and create, it will fail with an error. This is ok, as the command will ultimately exit in this scenario and
-// Kubernetes will restart the Pod, causing it to run the routine from the beginning, in which case it will retry the
-// upsert here and correctly update the existing ConfigMap.
-func (authMerger *AwsAuthMerger) upsertConfigMap(configmap corev1.ConfigMap) (bool, error) {
-	var existing *corev1.ConfigMap
-	result, err := authMerger.clientset.CoreV1().ConfigMaps(configmap.Namespace).Get(authMerger.ctx, configmap.Name, metav1.GetOptions{})
-	if err != nil && !k8serrors.IsNotFound(err) {
-		return false, errors.WithStackTrace(err)
-	} else if err == nil {
-		existing = result
-	} else {
-		// This case is when k8serrors.IsNotFound returns true.
-		existing = nil
+// Kubernetes doesn't provide a way to lock objects in the API, nor does it provide an atomic upsert API, so this does a
+// get call to check for existence before doing create or update. To make this safe, the update is done against the
+// resourceVersion that was read, so that the API rejects it with a Conflict error if the ConfigMap was modified in
+// between. Similarly, the create is rejected with an AlreadyExists error if the ConfigMap was created in between. In
+// both cases, we back off and retry the whole routine so that the latest version of the ConfigMap is read and
+// reconciled.
+//
+// The mappings adopted by the drift policy are only copied into their source ConfigMaps once the upsert succeeds, so
+// that nothing is adopted when the lockout guards refuse the write, or more than once when the upsert is retried.
+func (authMerger *AwsAuthMerger) upsertConfigMap(configmap corev1.ConfigMap) (corev1.ConfigMap, upsertAction, error) {
+	var written corev1.ConfigMap
+	var action upsertAction
+	var adoptions []mappingAdoption
+	err := retry.OnError(upsertBackoff, isRetriableUpsertErr, func() error {
+		var err error
+		written, action, adoptions, err = authMerger.tryUpsertConfigMap(configmap)
+		if isRetriableUpsertErr(err) {
+			authMerger.logger.Warnf("ConfigMap %s in Namespace %s was modified concurrently. Retrying upsert: %s", configmap.Name, configmap.Namespace, err)
+		}
+		return err
+	})
+	if err != nil {
+		return corev1.ConfigMap{}, "", errors.WithStackTrace(err)
+	}
+	if err := authMerger.persistAdoptions(adoptions); err != nil {
+		return corev1.ConfigMap{}, "", err
 	}
+	return written, action, nil
+}
 
-	if existing == nil {
+// tryUpsertConfigMap makes a single attempt at upserting the given ConfigMap. See upsertConfigMap for more info.
+// Note that this returns the raw API errors so that the caller can determine if the upsert should be retried. Also
+// returns the mappings to adopt once the upsert succeeds.
+func (authMerger *AwsAuthMerger) tryUpsertConfigMap(configmap corev1.ConfigMap) (corev1.ConfigMap, upsertAction, []mappingAdoption, error) {
+	existing, err := authMerger.clientset.CoreV1().ConfigMaps(configmap.Namespace).Get(authMerger.ctx, configmap.Name, metav1.GetOptions{})
+	if err != nil && k8serrors.IsNotFound(err) {
+		configmap.ResourceVersion = ""
 		if _, err := authMerger.clientset.CoreV1().ConfigMaps(configmap.Namespace).Create(authMerger.ctx, &configmap, metav1.CreateOptions{}); err != nil {
-			return false, errors.WithStackTrace(err)
+			return configmap, "", nil, err
 		}
-		return true, nil
+		return configmap, upsertActionCreated, nil, nil
+	} else if err != nil {
+		return configmap, "", nil, err
+	}
+
+	configmap, adoptions, err := authMerger.reconcileDrift(*existing, configmap)
+	if err != nil {
+		return configmap, "", nil, err
+	}
+	if isConfigMapUpToDate(*existing, configmap) {
+		return configmap, upsertActionUnchanged, adoptions, nil
+	}
+	// The guards are evaluated against the ConfigMap that is actually written, once the EKS worker node entries are
+	// adopted and the drift policy is applied, and against the version of the existing ConfigMap that it replaces.
+	if err := authMerger.checkLockoutGuards(existing, configmap); err != nil {
+		return configmap, "", nil, err
 	}
 
+	configmap.ResourceVersion = existing.ResourceVersion
 	if _, err := authMerger.clientset.CoreV1().ConfigMaps(configmap.Namespace).Update(authMerger.ctx, &configmap, metav1.UpdateOptions{}); err != nil {
-		return false, errors.WithStackTrace(err)
+		return configmap, "", nil, err
 	}
-	return false, nil
+	return configmap, upsertActionUpdated, adoptions, nil
+}
+
+// isRetriableUpsertErr returns true if the given error from the upsert indicates that the ConfigMap was concurrently
+// modified, in which case the upsert should be retried against the latest version.
+func isRetriableUpsertErr(err error) bool {
+	return k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err)
 }
 
 // logConfig will log out settings passed in.
 func (authMerger *AwsAuthMerger) logConfig() {
 	authMerger.logger.Info("Configured Settings:")
 	authMerger.logger.Infof("\tNamespace: %s", authMerger.namespace)
+	authMerger.logger.Infof("\tSource Namespaces: %v", authMerger.sourceNamespaces)
+	authMerger.logger.Infof("\tSource Namespace Selector: '%s'", authMerger.sourceNamespaceSelector)
 	authMerger.logger.Infof("\tLabel Selector: '%s'", authMerger.labelSelector)
+	authMerger.logger.Infof("\tWatch Secrets: %t", authMerger.watchSecrets)
+	authMerger.logger.Infof("\tWatch IAMIdentityMappings: %t", authMerger.watchIAMIdentityMappings)
+	authMerger.logger.Infof("\tSource Directory: '%s'", authMerger.sourceDirectory)
 	authMerger.logger.Infof("\tRefresh Interval: %s", authMerger.refreshInterval)
+	authMerger.logger.Infof("\tSync Retry Delay: %s - %s", authMerger.syncRetryBaseDelay, authMerger.syncRetryMaxDelay)
+	authMerger.logger.Infof("\tSync Max Retries: %d", authMerger.syncMaxRetries)
+	authMerger.logger.Infof("\tConflict Strategy: %s", authMerger.conflictStrategy)
+	authMerger.logger.Infof("\tQuarantine Invalid Sources: %t", authMerger.quarantineInvalidSources)
+	authMerger.logger.Infof("\tDrift Policy: %s", authMerger.driftPolicy)
+	authMerger.logger.Infof("\tAdopt EKS Node Mappings: %t", authMerger.adoptEksNodeMappings)
+	authMerger.logger.Infof("\tRemoval Grace Period: %s", authMerger.removalGracePeriod)
+	authMerger.logger.Infof("\tMin Node Role Mappings: %d", authMerger.lockoutGuards.minNodeRoleMappings)
+	authMerger.logger.Infof("\tMust Keep ARNs: %v", authMerger.lockoutGuards.mustKeepArns)
+	authMerger.logger.Infof("\tMax Removal Fraction: %g", authMerger.lockoutGuards.maxRemovalFraction)
+	authMerger.logger.Infof("\tLeader Election: %t", authMerger.leaderElection.enabled)
+	if authMerger.leaderElection.enabled {
+		authMerger.logger.Infof("\t\tLease: %s/%s", authMerger.leaderElection.leaseNamespace, authMerger.leaderElection.leaseName)
+		authMerger.logger.Infof("\t\tLease Duration: %s", authMerger.leaderElection.leaseDuration)
+		authMerger.logger.Infof("\t\tRenew Deadline: %s", authMerger.leaderElection.renewDeadline)
+		authMerger.logger.Infof("\t\tRetry Period: %s", authMerger.leaderElection.retryPeriod)
+	}
+	authMerger.logger.Infof("\tVerify Informer Cache: %t", authMerger.verifyInformerCache)
+	authMerger.logger.Infof("\tMetrics Port: %d", authMerger.metricsPort)
+	authMerger.logger.Infof("\tHealth Port: %d", authMerger.healthPort)
+	authMerger.logger.Infof("\tLiveness Interval Multiplier: %d", authMerger.livenessMultiplier)
+	authMerger.logger.Infof("\tShutdown Timeout: %s", authMerger.shutdownTimeout)
 	authMerger.logger.Info("\tAutoCreateLabels:")
 	for key, val := range authMerger.autoCreateLabels {
 		authMerger.logger.Infof("\t\t%s=%s", key, val)
@@ -342,52 +725,216 @@ func (authMerger *AwsAuthMerger) logConfig() {
 	authMerger.logger.Info("")
 }
 
-// mergeAwsAuthConfigMaps will take a list of aws-auth ConfigMaps and merge them together into one. This will return an
-// error if there are any conflicts in the roles or users.
-func mergeAwsAuthConfigMaps(configmaps []corev1.ConfigMap) (corev1.ConfigMap, error) {
-	merged := corev1.ConfigMap{}
+// mergeOptions configures how mergeAwsAuthConfigMaps handles problems with the source ConfigMaps.
+type mergeOptions struct {
+	// How to handle the same ARN showing up in multiple ConfigMaps.
+	conflictStrategy conflictStrategy
+	// When true, ConfigMaps that can not be parsed are excluded from the merge and reported in the result, instead of
+	// failing the merge.
+	quarantineInvalid bool
+	// When true, the source ConfigMaps are identified by their Namespace and name, instead of only their name. This is
+	// needed when merging ConfigMaps from multiple Namespaces, since names are only unique within a Namespace.
+	qualifySourceNames bool
+}
+
+// sourceName returns the name that identifies the given source ConfigMap in the merge result, the annotations on the
+// main aws-auth ConfigMap, and errors. Sources that were read from other kinds of objects are prefixed with their
+// lowercase kind (e.g. secret/), so that they don't clash with ConfigMaps of the same name. Files in the source
+// directory are identified by their absolute path, which can not clash with the names of Kubernetes objects.
+func (options mergeOptions) sourceName(configmap corev1.ConfigMap) string {
+	if isFileSource(configmap) {
+		return configmap.Name
+	}
+	name := configmap.Name
+	if kind := sourceKind(configmap); kind != configMapSourceKind {
+		name = strings.ToLower(kind) + "/" + name
+	}
+	if options.qualifySourceNames {
+		return configmap.Namespace + "/" + name
+	}
+	return name
+}
+
+// mergeResult is the outcome of merging a list of aws-auth ConfigMaps.
+type mergeResult struct {
+	// The merged aws-auth ConfigMap, ready to be written to the kube-system Namespace.
+	merged corev1.ConfigMap
+	// Conflicts that were resolved by the conflict strategy, instead of failing the merge.
+	resolvedConflicts []MappingConflictErr
+	// ConfigMaps that were excluded from the merge because they are invalid, keyed by name. Only set when invalid
+	// ConfigMaps are quarantined.
+	rejected map[string]error
+	// The number of mappings from each ConfigMap that made it into the merged ConfigMap, keyed by name. Mappings that
+	// were dropped by the conflict strategy are not counted.
+	accepted map[string]acceptedMappingCounts
+	// The source ConfigMaps that each of the merged mappings came from. This has more than one source only when
+	// mappings are combined by the union-groups strategy.
+	provenance map[mappingKey][]mappingSource
+}
+
+// acceptedMappingCounts is the number of role, user, and account mappings from a source ConfigMap that made it into the
+// merged ConfigMap.
+type acceptedMappingCounts struct {
+	roles    int
+	users    int
+	accounts int
+}
+
+// parsedAwsAuthConfigMap holds the mappings parsed out of a single aws-auth ConfigMap, along with the merge priority.
+type parsedAwsAuthConfigMap struct {
+	name        string
+	source      mappingSource
+	priority    int
+	mapRoles    []RoleMapping
+	mapUsers    []UserMapping
+	mapAccounts []AccountMapping
+}
+
+// isEmpty returns true if there are no mappings in the parsed ConfigMap.
+func (parsed parsedAwsAuthConfigMap) isEmpty() bool {
+	return len(parsed.mapRoles) == 0 && len(parsed.mapUsers) == 0 && len(parsed.mapAccounts) == 0
+}
+
+// mergeAwsAuthConfigMaps will take a list of aws-auth ConfigMaps and merge them together into one. Conflicts in the
+// roles, users, or accounts are handled according to the configured strategy, and this will return an error if there
+// are any conflicts that can not be resolved by the strategy. Invalid ConfigMaps will either fail the merge, or be
+// excluded from it if they are configured to be quarantined.
+func mergeAwsAuthConfigMaps(configmaps []corev1.ConfigMap, options mergeOptions) (mergeResult, error) {
+	result := mergeResult{
+		resolvedConflicts: []MappingConflictErr{},
+		rejected:          map[string]error{},
+		accepted:          map[string]acceptedMappingCounts{},
+		provenance:        map[mappingKey][]mappingSource{},
+	}
+	strategy := options.conflictStrategy
+
+	// Merge the ConfigMaps in order of name so that the output is deterministic regardless of the order the ConfigMaps
+	// were looked up in. This ensures that the content hash only changes when the mappings actually change.
+	configmaps = append([]corev1.ConfigMap{}, configmaps...)
+	sort.SliceStable(configmaps, func(i, j int) bool {
+		return options.sourceName(configmaps[i]) < options.sourceName(configmaps[j])
+	})
+
+	parsedConfigMaps := []parsedAwsAuthConfigMap{}
+	for _, configmap := range configmaps {
+		name := options.sourceName(configmap)
+		parsed, err := parseAwsAuthConfigMap(configmap, strategy)
+		if err != nil {
+			err = setInvalidSourceName(err, name)
+		}
+		if err != nil && options.quarantineInvalid {
+			result.rejected[name] = err
+			continue
+		} else if err != nil {
+			return result, err
+		}
+		parsed.name = name
+		parsedConfigMaps = append(parsedConfigMaps, parsed)
+	}
+
+	// With the priority strategy, we merge the ConfigMaps in order of priority so that the mappings from the higher
+	// priority ConfigMaps are seen first, and then drop the lower priority ones like skip-later. Conflicts between
+	// ConfigMaps of the same priority can not be resolved, so we track the ConfigMap where each mapping was first seen,
+	// which is also used to report where the conflicting entries came from.
+	listStrategy := strategy
+	if strategy == conflictStrategyPriority {
+		sort.SliceStable(parsedConfigMaps, func(i, j int) bool {
+			return parsedConfigMaps[i].priority > parsedConfigMaps[j].priority
+		})
+		listStrategy = conflictStrategySkipLater
+	}
+	origins := map[mappingKey]parsedAwsAuthConfigMap{}
+
 	sources := []string{}
 	mapRolesMerged := []RoleMapping{}
 	mapUsersMerged := []UserMapping{}
-	for _, configmap := range configmaps {
-		sources = append(sources, configmap.Name)
+	mapAccountsMerged := []AccountMapping{}
+	for _, parsed := range parsedConfigMaps {
+		sources = append(sources, parsed.name)
 
-		currentMapRoles, err := getRoleMappingFromConfigMap(configmap)
+		var err error
+		var roleConflicts []MappingConflictErr
+		mapRolesMerged, roleConflicts, err = mergeRoleMappingLists(mapRolesMerged, parsed.mapRoles, listStrategy)
 		if err != nil {
-			return merged, err
+			return result, setConflictSource(err, parsed, strategy, origins)
 		}
-		mapRolesMerged, err = mergeRoleMappingLists(mapRolesMerged, currentMapRoles)
-		if err != nil {
-			return merged, err
+		for _, roleMapping := range parsed.mapRoles {
+			recordMappingOrigin(origins, mappingKey{roleMappingType, roleMapping.RoleArn}, parsed)
+			recordMappingProvenance(result.provenance, mappingKey{roleMappingType, roleMapping.RoleArn}, parsed, listStrategy)
 		}
 
-		currentMapUsers, err := getUserMappingFromConfigMap(configmap)
+		var userConflicts []MappingConflictErr
+		mapUsersMerged, userConflicts, err = mergeUserMappingLists(mapUsersMerged, parsed.mapUsers, listStrategy)
 		if err != nil {
-			return merged, err
+			return result, setConflictSource(err, parsed, strategy, origins)
+		}
+		for _, userMapping := range parsed.mapUsers {
+			recordMappingOrigin(origins, mappingKey{userMappingType, userMapping.UserArn}, parsed)
+			recordMappingProvenance(result.provenance, mappingKey{userMappingType, userMapping.UserArn}, parsed, listStrategy)
 		}
-		mapUsersMerged, err = mergeUserMappingLists(mapUsersMerged, currentMapUsers)
+
+		var accountConflicts []MappingConflictErr
+		mapAccountsMerged, accountConflicts, err = mergeAccountMappingLists(mapAccountsMerged, parsed.mapAccounts, listStrategy)
 		if err != nil {
-			return merged, err
+			return result, setConflictSource(err, parsed, strategy, origins)
+		}
+		for _, accountMapping := range parsed.mapAccounts {
+			recordMappingOrigin(origins, mappingKey{accountMappingType, string(accountMapping)}, parsed)
+			recordMappingProvenance(result.provenance, mappingKey{accountMappingType, string(accountMapping)}, parsed, listStrategy)
+		}
+
+		// Mappings with a resolved conflict are dropped in favor of the existing entry, except with the union-groups
+		// strategy, where they are combined with it.
+		accepted := acceptedMappingCounts{roles: len(parsed.mapRoles), users: len(parsed.mapUsers), accounts: len(parsed.mapAccounts)}
+		conflicts := append(append(roleConflicts, userConflicts...), accountConflicts...)
+		for _, conflict := range conflicts {
+			origin := origins[mappingKey{conflict.mappingType, conflict.arn}]
+			conflict.configMapName = parsed.name
+			conflict.existingConfigMapName = origin.name
+			conflict.strategy = strategy
+			if strategy == conflictStrategyPriority && origin.priority == parsed.priority {
+				return result, errors.WithStackTrace(conflict)
+			}
+			result.resolvedConflicts = append(result.resolvedConflicts, conflict)
+
+			if strategy == conflictStrategyUnionGroups {
+				continue
+			}
+			switch conflict.mappingType {
+			case roleMappingType:
+				accepted.roles--
+			case userMappingType:
+				accepted.users--
+			case accountMappingType:
+				accepted.accounts--
+			}
 		}
+		result.accepted[parsed.name] = accepted
 	}
 
 	// Encode the combined data so that it can be injected into the ConfigMap
 	sourcesJson, err := json.Marshal(sources)
 	if err != nil {
-		return merged, errors.WithStackTrace(err)
+		return result, errors.WithStackTrace(err)
+	}
+	mergedMappings := parsedAwsAuthConfigMap{
+		name:        mainAwsAuthConfigMapName,
+		mapRoles:    mapRolesMerged,
+		mapUsers:    mapUsersMerged,
+		mapAccounts: mapAccountsMerged,
 	}
-	mapRolesYaml, err := yaml.Marshal(mapRolesMerged)
+	data, err := encodeAwsAuthMappings(mergedMappings)
 	if err != nil {
-		return merged, errors.WithStackTrace(err)
+		return result, err
 	}
-	mapUsersYaml, err := yaml.Marshal(mapUsersMerged)
+	managedJson, err := encodeManagedMappings(mergedMappings)
 	if err != nil {
-		return merged, errors.WithStackTrace(err)
+		return result, err
 	}
 
 	currentTime := time.Now().UTC()
 	currentTimeStr := currentTime.Format("2006-01-02T15:04:05Z")
-	merged.ObjectMeta = metav1.ObjectMeta{
+	result.merged.ObjectMeta = metav1.ObjectMeta{
 		Name:      mainAwsAuthConfigMapName,
 		Namespace: mainAwsAuthConfigMapNamespace,
 		Labels: map[string]string{
@@ -396,13 +943,175 @@ func mergeAwsAuthConfigMaps(configmaps []corev1.ConfigMap) (corev1.ConfigMap, er
 		Annotations: map[string]string{
 			sourcesAnnotationKey:         string(sourcesJson),
 			mergedTimestampAnnotationKey: currentTimeStr,
+			contentHashAnnotationKey:     hashConfigMapData(data),
+			managedMappingsAnnotationKey: managedJson,
+		},
+	}
+	result.merged.Data = data
+	return result, nil
+}
+
+// hashConfigMapData returns a hex encoded sha256 hash of the given ConfigMap data. The keys are hashed in sorted order
+// so that the hash is deterministic.
+func hashConfigMapData(data map[string]string) string {
+	keys := []string{}
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hasher := sha256.New()
+	for _, key := range keys {
+		// We separate the entries with a NUL byte, which can not show up in the keys, so that different splits of the
+		// same bytes across keys and values produce different hashes.
+		hasher.Write([]byte(key))
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(data[key]))
+		hasher.Write([]byte{0})
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// isConfigMapUpToDate returns true if the existing ConfigMap already has the content of the desired merged ConfigMap,
+// such that there is no need to update it. We check the hash of the actual data in addition to the hash annotation so
+// that manual edits to the data are still reverted, and check the sources, managed mappings, and pending removals so that
+// the annotations stay accurate.
+func isConfigMapUpToDate(existing corev1.ConfigMap, desired corev1.ConfigMap) bool {
+	desiredHash := desired.Annotations[contentHashAnnotationKey]
+	return isManagedByMerger(&existing) &&
+		existing.Annotations[contentHashAnnotationKey] == desiredHash &&
+		hashConfigMapData(existing.Data) == desiredHash &&
+		existing.Annotations[sourcesAnnotationKey] == desired.Annotations[sourcesAnnotationKey] &&
+		existing.Annotations[managedMappingsAnnotationKey] == desired.Annotations[managedMappingsAnnotationKey] &&
+		existing.Annotations[pendingRemovalAnnotationKey] == desired.Annotations[pendingRemovalAnnotationKey]
+}
+
+// parseAwsAuthConfigMap parses the mappings out of the given aws-auth ConfigMap. The priority annotation is only parsed
+// when using the priority conflict strategy, as it is ignored otherwise. This will return an error if the ConfigMap is
+// invalid.
+func parseAwsAuthConfigMap(configmap corev1.ConfigMap, strategy conflictStrategy) (parsedAwsAuthConfigMap, error) {
+	parsed := parsedAwsAuthConfigMap{
+		name: configmap.Name,
+		source: mappingSource{
+			Kind:            sourceKind(configmap),
+			Namespace:       configmap.Namespace,
+			Name:            configmap.Name,
+			ResourceVersion: configmap.ResourceVersion,
 		},
 	}
-	merged.Data = map[string]string{
-		mapRolesKey: string(mapRolesYaml),
-		mapUsersKey: string(mapUsersYaml),
+
+	if err := getSourceFileErr(configmap); err != nil {
+		return parsed, err
+	}
+
+	if strategy == conflictStrategyPriority {
+		priority, err := getConfigMapPriority(configmap)
+		if err != nil {
+			return parsed, err
+		}
+		parsed.priority = priority
+	}
+
+	mapRoles, err := getRoleMappingFromConfigMap(configmap)
+	if err != nil {
+		return parsed, err
+	}
+	parsed.mapRoles = mapRoles
+
+	mapUsers, err := getUserMappingFromConfigMap(configmap)
+	if err != nil {
+		return parsed, err
+	}
+	parsed.mapUsers = mapUsers
+
+	mapAccounts, err := getAccountMappingFromConfigMap(configmap)
+	if err != nil {
+		return parsed, err
+	}
+	parsed.mapAccounts = mapAccounts
+	return parsed, nil
+}
+
+// getConfigMapPriority returns the merge priority of the given ConfigMap, as set by the priority annotation. This
+// returns 0 if the annotation is not set.
+func getConfigMapPriority(configmap corev1.ConfigMap) (int, error) {
+	priorityRaw, hasPriority := configmap.Annotations[priorityAnnotationKey]
+	if !hasPriority {
+		return 0, nil
+	}
+	priority, err := strconv.Atoi(priorityRaw)
+	if err != nil {
+		return 0, errors.WithStackTrace(InvalidPriorityErr{configmap.Name, priorityRaw})
+	}
+	return priority, nil
+}
+
+// mappingKey uniquely identifies a mapping across all the mapping lists in the aws-auth ConfigMap.
+type mappingKey struct {
+	mappingType mappingType
+	// The ARN for roles and users, and the account ID for accounts.
+	key string
+}
+
+// recordMappingOrigin records the given ConfigMap as the origin of the mapping, unless the mapping was already seen in
+// an earlier ConfigMap.
+func recordMappingOrigin(origins map[mappingKey]parsedAwsAuthConfigMap, key mappingKey, parsed parsedAwsAuthConfigMap) {
+	if _, hasSeen := origins[key]; !hasSeen {
+		origins[key] = parsed
+	}
+}
+
+// setConflictSource annotates the given error with the source ConfigMaps of the conflicting entries and the configured
+// strategy if it is a mapping conflict, so that the user knows where to look to resolve it. If the existing entry has
+// not been recorded yet, then both entries came from the ConfigMap currently being merged. Other errors are returned
+// as is.
+func setConflictSource(err error, parsed parsedAwsAuthConfigMap, strategy conflictStrategy, origins map[mappingKey]parsedAwsAuthConfigMap) error {
+	conflict, isConflict := errors.Unwrap(err).(MappingConflictErr)
+	if !isConflict {
+		return err
+	}
+	conflict.configMapName = parsed.name
+	conflict.existingConfigMapName = parsed.name
+	if origin, hasOrigin := origins[mappingKey{conflict.mappingType, conflict.arn}]; hasOrigin {
+		conflict.existingConfigMapName = origin.name
+	}
+	conflict.strategy = strategy
+	return errors.WithStackTrace(conflict)
+}
+
+// setInvalidSourceName sets the name of the source ConfigMap on the given error if it is about an invalid ConfigMap,
+// so that the error identifies the ConfigMap the same way as the merge result. Other errors are returned as is.
+func setInvalidSourceName(err error, name string) error {
+	switch invalidErr := errors.Unwrap(err).(type) {
+	case InvalidMappingListErr:
+		invalidErr.configMapName = name
+		return errors.WithStackTrace(invalidErr)
+	case InvalidPriorityErr:
+		invalidErr.configMapName = name
+		return errors.WithStackTrace(invalidErr)
+	}
+	return err
+}
+
+// encodeAwsAuthMappings encodes the given mappings into the data format of the aws-auth ConfigMap.
+func encodeAwsAuthMappings(mappings parsedAwsAuthConfigMap) (map[string]string, error) {
+	mapRolesYaml, err := yaml.Marshal(mappings.mapRoles)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	mapUsersYaml, err := yaml.Marshal(mappings.mapUsers)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	mapAccountsYaml, err := yaml.Marshal(mappings.mapAccounts)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
 	}
-	return merged, nil
+	return map[string]string{
+		mapRolesKey:    string(mapRolesYaml),
+		mapUsersKey:    string(mapUsersYaml),
+		mapAccountsKey: string(mapAccountsYaml),
+	}, nil
 }
 
 // getRoleMappingFromConfigMap will return the role mapping list from the given ConfigMap. This will return an error if
@@ -435,6 +1144,21 @@ func getUserMappingFromConfigMap(configmap corev1.ConfigMap) ([]UserMapping, err
 	return currentUserMapping, nil
 }
 
+// getAccountMappingFromConfigMap will return the account mapping list from the given ConfigMap. This will return an
+// error if the mapAccounts key does not contain a valid account mapping list schema.
+func getAccountMappingFromConfigMap(configmap corev1.ConfigMap) ([]AccountMapping, error) {
+	mapAccountsRaw, hasMapAccounts := configmap.Data[mapAccountsKey]
+	if !hasMapAccounts {
+		return []AccountMapping{}, nil
+	}
+
+	var currentAccountMapping []AccountMapping
+	if err := yaml.Unmarshal([]byte(mapAccountsRaw), &currentAccountMapping); err != nil {
+		return nil, errors.WithStackTrace(InvalidMappingListErr{accountMappingType, configmap.Name, err})
+	}
+	return currentAccountMapping, nil
+}
+
 // isManagedByMerger returns true if the given ConfigMap is merged by the aws-auth merger, which is determined by
 // checking for the managed-by label.
 func isManagedByMerger(configmap *corev1.ConfigMap) bool {
@@ -457,7 +1181,18 @@ func (err InvalidMappingListErr) Error() string {
 		return fmt.Sprintf("Error parsing mapRoles on ConfigMap %s : %s", err.configMapName, err.underlyingErr)
 	case userMappingType:
 		return fmt.Sprintf("Error parsing mapUsers on ConfigMap %s : %s", err.configMapName, err.underlyingErr)
+	case accountMappingType:
+		return fmt.Sprintf("Error parsing mapAccounts on ConfigMap %s : %s", err.configMapName, err.underlyingErr)
 	default:
 		return fmt.Sprintf("Unknown mapping type: %s", err.mappingType)
 	}
 }
+
+type InvalidPriorityErr struct {
+	configMapName string
+	priority      string
+}
+
+func (err InvalidPriorityErr) Error() string {
+	return fmt.Sprintf("Invalid value for annotation %s on ConfigMap %s: %s is not an integer.", priorityAnnotationKey, err.configMapName, err.priority)
+}
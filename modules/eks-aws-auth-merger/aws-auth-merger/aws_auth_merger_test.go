@@ -15,8 +15,13 @@ import (
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v2"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestGetMainAwsAuthConfigMapNoExist(t *testing.T) {
@@ -193,8 +198,9 @@ func TestMergeAwsAuthConfigMaps(t *testing.T) {
 	}
 	sort.Strings(expectedSources)
 
-	merged, err := mergeAwsAuthConfigMaps(configmaps)
+	result, err := mergeAwsAuthConfigMaps(configmaps, mergeOptions{conflictStrategy: conflictStrategyFail})
 	require.NoError(t, err)
+	merged := result.merged
 
 	assert.Equal(t, "aws-auth", merged.Name)
 	assert.Equal(t, "kube-system", merged.Namespace)
@@ -213,6 +219,242 @@ func TestMergeAwsAuthConfigMaps(t *testing.T) {
 	assert.Equal(t, expectedMapUsers, convertUserMappingListToMap(actualUserMapping))
 }
 
+// Test that mergeAwsAuthConfigMaps resolves conflicts across ConfigMaps according to the priority annotation when using
+// the priority conflict strategy.
+func TestMergeAwsAuthConfigMapsPriorityStrategy(t *testing.T) {
+	t.Parallel()
+
+	lowPriority := newAwsAuthConfigMap(t, "low", "", []RoleMapping{{RoleArn: "asdf", Username: "low"}}, []UserMapping{})
+	highPriority := newAwsAuthConfigMap(t, "high", "10", []RoleMapping{{RoleArn: "asdf", Username: "high"}}, []UserMapping{})
+	samePriority := newAwsAuthConfigMap(t, "same", "10", []RoleMapping{{RoleArn: "asdf", Username: "same"}}, []UserMapping{})
+	invalidPriority := newAwsAuthConfigMap(t, "invalid", "high", []RoleMapping{}, []UserMapping{})
+
+	result, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{lowPriority, highPriority}, mergeOptions{conflictStrategy: conflictStrategyPriority})
+	require.NoError(t, err)
+	var actualRoleMapping []RoleMapping
+	require.NoError(t, yaml.Unmarshal([]byte(result.merged.Data[mapRolesKey]), &actualRoleMapping))
+	require.Len(t, actualRoleMapping, 1)
+	assert.Equal(t, "high", actualRoleMapping[0].Username)
+	require.Len(t, result.resolvedConflicts, 1)
+	assert.Equal(t, "low", result.resolvedConflicts[0].configMapName)
+
+	_, err = mergeAwsAuthConfigMaps([]corev1.ConfigMap{lowPriority, highPriority, samePriority}, mergeOptions{conflictStrategy: conflictStrategyPriority})
+	assert.Error(t, err)
+
+	_, err = mergeAwsAuthConfigMaps([]corev1.ConfigMap{lowPriority, invalidPriority}, mergeOptions{conflictStrategy: conflictStrategyPriority})
+	assert.Error(t, err)
+
+	_, err = mergeAwsAuthConfigMaps([]corev1.ConfigMap{lowPriority, highPriority}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	assert.Error(t, err)
+}
+
+// Test that mergeAwsAuthConfigMaps excludes invalid ConfigMaps from the merge when quarantine is enabled, and fails the
+// merge otherwise.
+func TestMergeAwsAuthConfigMapsQuarantineInvalid(t *testing.T) {
+	t.Parallel()
+
+	valid := newAwsAuthConfigMap(t, "valid", "", []RoleMapping{{RoleArn: "asdf", Username: "Asdf", Groups: []string{}}}, []UserMapping{})
+	invalid := newAwsAuthConfigMap(t, "invalid", "", []RoleMapping{}, []UserMapping{})
+	invalid.Data[mapRolesKey] = "- rolearn: [not, a, string"
+
+	_, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{valid, invalid}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	assert.Error(t, err)
+
+	result, err := mergeAwsAuthConfigMaps(
+		[]corev1.ConfigMap{valid, invalid},
+		mergeOptions{conflictStrategy: conflictStrategyFail, quarantineInvalid: true},
+	)
+	require.NoError(t, err)
+	require.Contains(t, result.rejected, "invalid")
+	assert.NotContains(t, result.rejected, "valid")
+
+	var sources []string
+	require.NoError(t, json.Unmarshal([]byte(result.merged.Annotations[sourcesAnnotationKey]), &sources))
+	assert.Equal(t, []string{"valid"}, sources)
+
+	var actualRoleMapping []RoleMapping
+	require.NoError(t, yaml.Unmarshal([]byte(result.merged.Data[mapRolesKey]), &actualRoleMapping))
+	assert.Equal(t, []RoleMapping{{RoleArn: "asdf", Username: "Asdf", Groups: []string{}}}, actualRoleMapping)
+}
+
+// Test that mergeAwsAuthConfigMaps carries over the mapAccounts entries from the source ConfigMaps, including those that
+// are written as unquoted numbers.
+func TestMergeAwsAuthConfigMapsAccounts(t *testing.T) {
+	t.Parallel()
+
+	withAccounts := newAwsAuthConfigMap(t, "accounts", "", []RoleMapping{}, []UserMapping{})
+	withAccounts.Data[mapAccountsKey] = "- 111122223333\n- \"555555555555\"\n"
+	withoutAccounts := newAwsAuthConfigMap(t, "no-accounts", "", []RoleMapping{}, []UserMapping{})
+	invalidAccounts := newAwsAuthConfigMap(t, "invalid-accounts", "", []RoleMapping{}, []UserMapping{})
+	invalidAccounts.Data[mapAccountsKey] = "accounts: 111122223333"
+
+	result, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{withAccounts, withoutAccounts}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	require.NoError(t, err)
+	var actualAccountMapping []AccountMapping
+	require.NoError(t, yaml.Unmarshal([]byte(result.merged.Data[mapAccountsKey]), &actualAccountMapping))
+	assert.Equal(t, []AccountMapping{"111122223333", "555555555555"}, actualAccountMapping)
+
+	_, err = mergeAwsAuthConfigMaps([]corev1.ConfigMap{withAccounts, withAccounts}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	assert.Error(t, err)
+
+	_, err = mergeAwsAuthConfigMaps([]corev1.ConfigMap{withAccounts, invalidAccounts}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	assert.Error(t, err)
+}
+
+// Test that mergeAwsAuthConfigMaps produces the same data and content hash regardless of the order of the input
+// ConfigMaps, so that syncs that don't change any mappings don't update the main aws-auth ConfigMap.
+func TestMergeAwsAuthConfigMapsDeterministic(t *testing.T) {
+	t.Parallel()
+
+	first := newAwsAuthConfigMap(t, "a-first", "", []RoleMapping{{RoleArn: "asdf", Username: "Asdf"}}, []UserMapping{})
+	second := newAwsAuthConfigMap(t, "b-second", "", []RoleMapping{{RoleArn: "hjkl", Username: "Hjkl"}}, []UserMapping{{UserArn: "1234", Username: "1234"}})
+
+	resultA, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{first, second}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	require.NoError(t, err)
+	resultB, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{second, first}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	require.NoError(t, err)
+
+	assert.Equal(t, resultA.merged.Data, resultB.merged.Data)
+	assert.Equal(t, resultA.merged.Annotations[sourcesAnnotationKey], resultB.merged.Annotations[sourcesAnnotationKey])
+	assert.Equal(t, `["a-first","b-second"]`, resultA.merged.Annotations[sourcesAnnotationKey])
+	assert.Equal(t, hashConfigMapData(resultA.merged.Data), resultA.merged.Annotations[contentHashAnnotationKey])
+	assert.Equal(t, resultA.merged.Annotations[contentHashAnnotationKey], resultB.merged.Annotations[contentHashAnnotationKey])
+}
+
+func TestIsConfigMapUpToDate(t *testing.T) {
+	t.Parallel()
+
+	configmap := newAwsAuthConfigMap(t, "source", "", []RoleMapping{{RoleArn: "asdf", Username: "Asdf"}}, []UserMapping{})
+	result, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{configmap}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	require.NoError(t, err)
+	desired := result.merged
+
+	upToDate := *desired.DeepCopy()
+	assert.True(t, isConfigMapUpToDate(upToDate, desired))
+
+	manuallyEdited := *desired.DeepCopy()
+	manuallyEdited.Data[mapRolesKey] = sampleMapRolesYaml
+	assert.False(t, isConfigMapUpToDate(manuallyEdited, desired))
+
+	unmanaged := *desired.DeepCopy()
+	unmanaged.Labels = map[string]string{}
+	assert.False(t, isConfigMapUpToDate(unmanaged, desired))
+
+	differentSources := *desired.DeepCopy()
+	differentSources.Annotations[sourcesAnnotationKey] = `["other"]`
+	assert.False(t, isConfigMapUpToDate(differentSources, desired))
+}
+
+// Test that mergeAwsAuthConfigMaps detects duplicate entries within a single ConfigMap, reports both entries and the
+// ConfigMap in the error, and handles them using the conflict strategy.
+func TestMergeAwsAuthConfigMapsDuplicatesWithinConfigMap(t *testing.T) {
+	t.Parallel()
+
+	duplicates := newAwsAuthConfigMap(
+		t,
+		"duplicates",
+		"",
+		[]RoleMapping{
+			{RoleArn: "asdf", Username: "Asdf", Groups: []string{"system:masters"}},
+			{RoleArn: "asdf", Username: "Other", Groups: []string{"autodeploy"}},
+		},
+		[]UserMapping{},
+	)
+
+	_, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{duplicates}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate entries in ConfigMap duplicates")
+	assert.Contains(t, err.Error(), "username: Asdf")
+	assert.Contains(t, err.Error(), "username: Other")
+
+	// Duplicates within a ConfigMap always have the same priority, so they can't be resolved by the priority strategy.
+	_, err = mergeAwsAuthConfigMaps([]corev1.ConfigMap{duplicates}, mergeOptions{conflictStrategy: conflictStrategyPriority})
+	assert.Error(t, err)
+
+	result, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{duplicates}, mergeOptions{conflictStrategy: conflictStrategySkipLater})
+	require.NoError(t, err)
+	var actualRoleMapping []RoleMapping
+	require.NoError(t, yaml.Unmarshal([]byte(result.merged.Data[mapRolesKey]), &actualRoleMapping))
+	assert.Equal(t, []RoleMapping{{RoleArn: "asdf", Username: "Asdf", Groups: []string{"system:masters"}}}, actualRoleMapping)
+	require.Len(t, result.resolvedConflicts, 1)
+	assert.Equal(t, "duplicates", result.resolvedConflicts[0].existingConfigMapName)
+	assert.Equal(t, "duplicates", result.resolvedConflicts[0].configMapName)
+}
+
+// Test that upsertConfigMap retries the update against the latest version of the main aws-auth ConfigMap when it is
+// concurrently modified, instead of failing. This uses a fake clientset so that the conflict can be injected.
+func TestUpsertConfigMapRetriesOnConflict(t *testing.T) {
+	t.Parallel()
+
+	existing := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", Namespace: "kube-system", ResourceVersion: "1"},
+		Data:       map[string]string{mapRolesKey: sampleMapRolesYaml},
+	}
+	clientset := fake.NewSimpleClientset(&existing)
+	updateAttempts := 0
+	clientset.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updateAttempts++
+		if updateAttempts == 1 {
+			return true, nil, k8serrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, "aws-auth", fmt.Errorf("object was modified"))
+		}
+		return false, nil, nil
+	})
+
+	// The lockout guards are disabled, as these tests remove mappings from the existing ConfigMap.
+	authMerger := AwsAuthMerger{lockoutGuards: lockoutGuards{maxRemovalFraction: 1}, clientset: clientset, ctx: context.Background(), logger: logrus.New()}
+	source := newAwsAuthConfigMap(t, "source", "", []RoleMapping{{RoleArn: "asdf", Username: "Asdf"}}, []UserMapping{})
+	result, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{source}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	require.NoError(t, err)
+
+	_, action, err := authMerger.upsertConfigMap(result.merged)
+	require.NoError(t, err)
+	assert.Equal(t, upsertActionUpdated, action)
+	assert.Equal(t, 2, updateAttempts)
+
+	updated, err := clientset.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "aws-auth", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, result.merged.Data, updated.Data)
+
+	// Now that the ConfigMap is up to date, the upsert should not make any further updates.
+	_, action, err = authMerger.upsertConfigMap(result.merged)
+	require.NoError(t, err)
+	assert.Equal(t, upsertActionUnchanged, action)
+	assert.Equal(t, 2, updateAttempts)
+}
+
+// Test that upsertConfigMap falls back to updating the main aws-auth ConfigMap if it is created by something else
+// between the get and the create.
+func TestUpsertConfigMapRetriesOnAlreadyExists(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	createAttempts := 0
+	clientset.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		createAttempts++
+		if createAttempts == 1 {
+			// Simulate EKS creating the ConfigMap concurrently.
+			concurrent := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "aws-auth", Namespace: "kube-system"},
+				Data:       map[string]string{mapRolesKey: sampleMapRolesYaml},
+			}
+			require.NoError(t, clientset.Tracker().Add(concurrent))
+			return true, nil, k8serrors.NewAlreadyExists(schema.GroupResource{Resource: "configmaps"}, "aws-auth")
+		}
+		return false, nil, nil
+	})
+
+	// The lockout guards are disabled, as these tests remove mappings from the existing ConfigMap.
+	authMerger := AwsAuthMerger{lockoutGuards: lockoutGuards{maxRemovalFraction: 1}, clientset: clientset, ctx: context.Background(), logger: logrus.New()}
+	source := newAwsAuthConfigMap(t, "source", "", []RoleMapping{{RoleArn: "asdf", Username: "Asdf"}}, []UserMapping{})
+	result, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{source}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	require.NoError(t, err)
+
+	_, action, err := authMerger.upsertConfigMap(result.merged)
+	require.NoError(t, err)
+	assert.Equal(t, upsertActionUpdated, action)
+	assert.Equal(t, 1, createAttempts)
+}
+
 func convertRoleMappingListToMap(roleMapping []RoleMapping) map[string]RoleMapping {
 	out := map[string]RoleMapping{}
 	for _, rm := range roleMapping {
@@ -315,6 +557,30 @@ func createRandomAwsAuthConfigMap(
 	return created
 }
 
+// newAwsAuthConfigMap returns an in-memory aws-auth ConfigMap with the given mappings, for tests that don't need to
+// create the ConfigMap in Kubernetes. The priority annotation is only set if priority is not blank.
+func newAwsAuthConfigMap(t *testing.T, name string, priority string, roleMapping []RoleMapping, userMapping []UserMapping) corev1.ConfigMap {
+	mapRolesYaml, err := yaml.Marshal(roleMapping)
+	require.NoError(t, err)
+	mapUsersYaml, err := yaml.Marshal(userMapping)
+	require.NoError(t, err)
+
+	configmap := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{},
+		},
+		Data: map[string]string{
+			mapRolesKey: string(mapRolesYaml),
+			mapUsersKey: string(mapUsersYaml),
+		},
+	}
+	if priority != "" {
+		configmap.Annotations[priorityAnnotationKey] = priority
+	}
+	return configmap
+}
+
 func createFakeAwsAuthConfigMap(t *testing.T, clientset *kubernetes.Clientset) *corev1.ConfigMap {
 	configmap := corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
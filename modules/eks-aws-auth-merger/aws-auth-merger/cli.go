@@ -35,6 +35,26 @@ var (
 		Name:  "watch-label-selector",
 		Usage: "Labels to use when collecting aws-auth ConfigMaps to merge. If blank, will use all ConfigMaps in the Namespace.",
 	}
+	sourceNamespacesFlag = cli.StringSliceFlag{
+		Name:  "source-namespace",
+		Usage: "Additional Namespace to watch for aws-auth ConfigMaps to merge, along with those in the watch Namespace. Pass multiple times to watch more than one Namespace.",
+	}
+	sourceNamespaceSelectorFlag = cli.StringFlag{
+		Name:  "source-namespace-selector",
+		Usage: "Label selector for additional Namespaces to watch for aws-auth ConfigMaps to merge, along with those in the watch Namespace (e.g. aws-auth-merger.gruntwork.io/source=true). If blank, no Namespaces are selected by label.",
+	}
+	watchSecretsFlag = cli.BoolFlag{
+		Name:  "watch-secrets",
+		Usage: "When set, Secrets that match the label selector in the watch Namespace and the Namespaces passed with --source-namespace are merged along with the aws-auth ConfigMaps. The mapRoles, mapUsers, and mapAccounts keys are read from the Secret data. Can not be combined with --source-namespace-selector.",
+	}
+	watchIAMIdentityMappingsFlag = cli.BoolFlag{
+		Name:  "watch-iam-identity-mappings",
+		Usage: "When set, IAMIdentityMapping custom resources that match the label selector in the source Namespaces are merged along with the aws-auth ConfigMaps, and their status reports whether the mapping is in the main aws-auth ConfigMap. The IAMIdentityMapping CustomResourceDefinition must be installed in the cluster.",
+	}
+	sourceDirectoryFlag = cli.StringFlag{
+		Name:  "source-directory",
+		Usage: "Directory with files of aws-auth mappings to merge along with the aws-auth ConfigMaps, such as a mounted ConfigMap volume or a directory kept up to date by git-sync. Each .yaml, .yml, or .json file in the directory is a source, with mapRoles, mapUsers, and mapAccounts lists. Changes to the files are detected with file system notifications. If blank, no files are merged.",
+	}
 	autoCreateLabelsFlag = cli.StringSliceFlag{
 		Name:  "autocreate-labels",
 		Usage: "Labels to attach to autocreated ConfigMaps in the watch namespace as a key=value pairs. Pass multiple times to assign more than one label. If no value is provided (e.g. --autocreate-labels key), then the label will use empty string for the value.",
@@ -44,6 +64,114 @@ var (
 		Value: 5 * time.Minute,
 		Usage: "Interval to poll the Namespace for aws-auth ConfigMaps to merge as a duration string (e.g. 5m10s for 5 minutes 10 seconds).",
 	}
+	syncRetryBaseDelayFlag = cli.DurationFlag{
+		Name:  "sync-retry-base-delay",
+		Value: 1 * time.Second,
+		Usage: "How long to wait before retrying a failed sync of the aws-auth ConfigMaps. The delay doubles with each retry, up to the max delay.",
+	}
+	syncRetryMaxDelayFlag = cli.DurationFlag{
+		Name:  "sync-retry-max-delay",
+		Value: 5 * time.Minute,
+		Usage: "The maximum delay between retries of a failed sync of the aws-auth ConfigMaps.",
+	}
+	syncMaxRetriesFlag = cli.IntFlag{
+		Name:  "sync-max-retries",
+		Value: 10,
+		Usage: "How many times to retry a failed sync of the aws-auth ConfigMaps before giving up until the next change or refresh interval.",
+	}
+	verifyInformerCacheFlag = cli.BoolFlag{
+		Name:  "verify-informer-cache",
+		Usage: "When set, the ConfigMaps read from the informer cache are verified against a direct List call to the Kubernetes API on every sync, and the API result is used if the cache is out of date.",
+	}
+	conflictStrategyFlag = cli.StringFlag{
+		Name:  "conflict-strategy",
+		Value: string(conflictStrategyFail),
+		Usage: "How to handle the same ARN showing up in multiple aws-auth ConfigMaps. Must be one of: fail (abort the merge), skip-later (keep the first mapping that was merged), priority (keep the mapping from the ConfigMap with the highest gruntwork.io/aws-auth-merger-priority annotation), union-groups (combine the groups if the usernames match).",
+	}
+	quarantineInvalidSourcesFlag = cli.BoolFlag{
+		Name:  "quarantine-invalid-sources",
+		Usage: "When set, aws-auth ConfigMaps that can not be parsed are excluded from the merge instead of aborting it. The reason is recorded on the excluded ConfigMap in the gruntwork.io/aws-auth-merger-rejected annotation.",
+	}
+	adoptEksNodeMappingsFlag = cli.BoolTFlag{
+		Name:  "adopt-eks-node-mappings",
+		Usage: "When set, the worker node role mappings that EKS adds to the main aws-auth ConfigMap for Managed Node Groups and Fargate Profiles are copied into the " + eksNodeConfigMapName + " ConfigMap in the watch Namespace, regardless of the drift policy. Defaults to true. Pass --adopt-eks-node-mappings=false to disable.",
+	}
+	driftPolicyFlag = cli.StringFlag{
+		Name:  "drift-policy",
+		Value: string(driftPolicyRevert),
+		Usage: "What to do with entries in the main aws-auth ConfigMap that did not come from any of the aws-auth ConfigMaps. Must be one of: revert (remove the entries), adopt (copy the entries into the " + adoptedConfigMapName + " ConfigMap in the watch Namespace), alert-only (log the entries and keep them).",
+	}
+	removalGracePeriodFlag = cli.DurationFlag{
+		Name:  "removal-grace-period",
+		Usage: "How long to keep mappings that were removed from all the aws-auth ConfigMaps in the main aws-auth ConfigMap, as a duration string (e.g. 10m for 10 minutes). Mappings that show up again within this period are kept as if they were never removed. Set to 0 to remove mappings immediately.",
+	}
+	minNodeRoleMappingsFlag = cli.IntFlag{
+		Name:  "min-node-role-mappings",
+		Usage: "Refuse to write a merged aws-auth ConfigMap with fewer than this many worker node role mappings (role mappings with the system:nodes group). Set to 0 to disable.",
+	}
+	mustKeepArnsFlag = cli.StringSliceFlag{
+		Name:  "must-keep-arns",
+		Usage: "Refuse to write a merged aws-auth ConfigMap that does not include a mapping for this IAM role ARN, IAM user ARN, or AWS account ID. Pass multiple times to require more than one entry.",
+	}
+	maxRemovalFractionFlag = cli.Float64Flag{
+		Name:  "max-removal-fraction",
+		Value: 1,
+		Usage: "Refuse to write a merged aws-auth ConfigMap that removes more than this fraction (between 0 and 1) of the mappings managed by the merger in a single sync. Set to 1 to disable.",
+	}
+
+	// leader election params
+	leaderElectFlag = cli.BoolFlag{
+		Name:  "leader-elect",
+		Usage: "When set, use a Lease to elect a leader among the replicas, so that only one replica syncs the aws-auth ConfigMaps at a time. Required when running more than one replica.",
+	}
+	leaderElectionLeaseNameFlag = cli.StringFlag{
+		Name:  "leader-election-lease-name",
+		Value: "aws-auth-merger",
+		Usage: "Name of the Lease to use for leader election.",
+	}
+	leaderElectionNamespaceFlag = cli.StringFlag{
+		Name:  "leader-election-namespace",
+		Usage: "Namespace of the Lease to use for leader election. Defaults to the watch Namespace.",
+	}
+	leaderElectionLeaseDurationFlag = cli.DurationFlag{
+		Name:  "leader-election-lease-duration",
+		Value: 15 * time.Second,
+		Usage: "How long standby replicas wait before taking over a Lease that has not been renewed by the leader.",
+	}
+	leaderElectionRenewDeadlineFlag = cli.DurationFlag{
+		Name:  "leader-election-renew-deadline",
+		Value: 10 * time.Second,
+		Usage: "How long the leader keeps trying to renew the Lease before giving up leadership. Must be less than the lease duration.",
+	}
+	leaderElectionRetryPeriodFlag = cli.DurationFlag{
+		Name:  "leader-election-retry-period",
+		Value: 2 * time.Second,
+		Usage: "How long to wait between attempts to acquire or renew the Lease.",
+	}
+	shutdownTimeoutFlag = cli.DurationFlag{
+		Name:  "shutdown-timeout",
+		Value: 20 * time.Second,
+		Usage: "How long to wait for an in-flight sync to complete after receiving SIGTERM or SIGINT, before cancelling the requests to the Kubernetes API. Should be less than the termination grace period of the Pod.",
+	}
+
+	// metrics params
+	metricsPortFlag = cli.IntFlag{
+		Name:  "metrics-port",
+		Value: 8080,
+		Usage: "Port to serve Prometheus metrics on, at the /metrics path. Set to 0 to disable.",
+	}
+
+	// health probe params
+	healthPortFlag = cli.IntFlag{
+		Name:  "health-port",
+		Value: 8081,
+		Usage: "Port to serve the liveness (/healthz) and readiness (/readyz) probes on. Set to 0 to disable.",
+	}
+	livenessIntervalMultiplierFlag = cli.IntFlag{
+		Name:  "liveness-interval-multiplier",
+		Value: 3,
+		Usage: "The liveness probe fails when no sync has succeeded for this many refresh intervals. Must be at least 1.",
+	}
 
 	// k8s auth params
 	kubeconfigPathFlag = cli.StringFlag{
@@ -54,6 +182,17 @@ var (
 		Name:  "context",
 		Usage: "The name of the kubeconfig context to use. Defaults to the default context set in the kubeconfig.",
 	}
+
+	// merge subcommand params
+	mergeInputFlag = cli.StringSliceFlag{
+		Name:  "input",
+		Usage: "File or directory of aws-auth ConfigMap manifests to merge. For directories, all .yaml, .yml, and .json files directly in the directory are read. Pass multiple times to merge more than one file or directory.",
+	}
+	mergeOutputFlag = cli.StringFlag{
+		Name:  "output",
+		Value: stdoutOutputPath,
+		Usage: "Path to write the merged aws-auth ConfigMap manifest to. If - or blank, the manifest is written to stdout.",
+	}
 )
 
 // initCli initializes the CLI app before any command is actually executed. This function will handle all the setup
@@ -79,33 +218,150 @@ func newApp() *cli.App {
 	app := entrypoint.NewApp()
 	app.Name = commandName
 	app.Author = "Gruntwork <www.gruntwork.io>"
-	cli.AppHelpTemplate = entrypoint.CLI_COMMAND_HELP_TEMPLATE // There are no subcommands, so directly use the command template in the app help
-	app.Description = `A Kubernetes app that watches for aws-auth ConfigMaps in a Namespace and merges them into the main aws-auth ConfigMap in the kube-system Namespace.
+	app.Description = `A Kubernetes app that watches for aws-auth ConfigMaps in one or more Namespaces and merges them into the main aws-auth ConfigMap in the kube-system Namespace.
+
+This will setup a watcher to listen for new and updated aws-auth ConfigMaps and will refresh the ConfigMap as changes are detected. For redundancy and fault tolerance of the event system, this will also periodically refresh the ConfigMap even if no changes are detected.
 
-This will setup a watcher to listen for new and updated aws-auth ConfigMaps and will refresh the ConfigMap as changes are detected. For redundancy and fault tolerance of the event system, this will also periodically refresh the ConfigMap even if no changes are detected.`
+Use the merge command to render the main aws-auth ConfigMap from local manifests without a cluster.`
 	app.Before = initCli
 	app.Flags = []cli.Flag{
 		logLevelFlag,
 		namespaceFlag,
 		labelSelectorFlag,
+		sourceNamespacesFlag,
+		sourceNamespaceSelectorFlag,
+		watchSecretsFlag,
+		watchIAMIdentityMappingsFlag,
+		sourceDirectoryFlag,
 		autoCreateLabelsFlag,
 		refreshIntervalFlag,
+		syncRetryBaseDelayFlag,
+		syncRetryMaxDelayFlag,
+		syncMaxRetriesFlag,
+		verifyInformerCacheFlag,
+		conflictStrategyFlag,
+		quarantineInvalidSourcesFlag,
+		driftPolicyFlag,
+		adoptEksNodeMappingsFlag,
+		removalGracePeriodFlag,
+		minNodeRoleMappingsFlag,
+		mustKeepArnsFlag,
+		maxRemovalFractionFlag,
+		leaderElectFlag,
+		leaderElectionLeaseNameFlag,
+		leaderElectionNamespaceFlag,
+		leaderElectionLeaseDurationFlag,
+		leaderElectionRenewDeadlineFlag,
+		leaderElectionRetryPeriodFlag,
+		shutdownTimeoutFlag,
+		metricsPortFlag,
+		healthPortFlag,
+		livenessIntervalMultiplierFlag,
 		kubeconfigPathFlag,
 		kubeContextFlag,
 	}
+	app.Commands = []cli.Command{
+		{
+			Name:  "merge",
+			Usage: "Merge local aws-auth ConfigMap manifests into the main aws-auth ConfigMap manifest, without a cluster.",
+			Description: `Runs the same merge as the aws-auth-merger in the cluster on local aws-auth ConfigMap manifests, and writes the resulting aws-auth ConfigMap manifest. Use this in CI to review the merged aws-auth ConfigMap before the changes reach the cluster.
+
+Exits with code 2 if any of the manifests are invalid, and with code 3 if the manifests have mapping conflicts that can not be resolved by the conflict strategy.`,
+			Flags: []cli.Flag{
+				mergeInputFlag,
+				mergeOutputFlag,
+				conflictStrategyFlag,
+			},
+			Action: errors.WithPanicHandling(offlineMerge),
+		},
+	}
 	app.Action = errors.WithPanicHandling(awsAuthMerger)
 	return app
 }
 
+func offlineMerge(cliContext *cli.Context) error {
+	inputs := cliContext.StringSlice(mergeInputFlag.Name)
+	if len(inputs) == 0 {
+		return entrypoint.NewRequiredArgsError("The --input flag is required.")
+	}
+	conflictStrategy, err := parseConflictStrategy(cliContext.String(conflictStrategyFlag.Name))
+	if err != nil {
+		return err
+	}
+	output := cliContext.String(mergeOutputFlag.Name)
+
+	logger := getProjectLogger()
+	merged, err := renderAwsAuthConfigMap(logger, inputs, mergeOptions{conflictStrategy: conflictStrategy})
+	if err != nil {
+		return err
+	}
+	if err := writeConfigMapManifest(merged, output); err != nil {
+		return err
+	}
+	logger.Infof("Successfully merged the aws-auth ConfigMap manifests into %s", output)
+	return nil
+}
+
 func awsAuthMerger(cliContext *cli.Context) error {
 	namespace, err := entrypoint.StringFlagRequiredE(cliContext, namespaceFlag.Name)
 	if err != nil {
 		return err
 	}
 	labelSelector := cliContext.String(labelSelectorFlag.Name)
+	sourceNamespaceSelector := cliContext.String(sourceNamespaceSelectorFlag.Name)
+	if err := validateSourceNamespaceSelector(sourceNamespaceSelector); err != nil {
+		return err
+	}
+	sourceDirectory := cliContext.String(sourceDirectoryFlag.Name)
+	if err := validateSourceDirectory(sourceDirectory); err != nil {
+		return err
+	}
+	watchSecrets := cliContext.Bool(watchSecretsFlag.Name)
+	if err := validateWatchSecrets(watchSecrets, sourceNamespaceSelector); err != nil {
+		return err
+	}
 	refreshInterval := cliContext.Duration(refreshIntervalFlag.Name)
+	syncRetryBaseDelay := cliContext.Duration(syncRetryBaseDelayFlag.Name)
+	syncRetryMaxDelay := cliContext.Duration(syncRetryMaxDelayFlag.Name)
+	syncMaxRetries := cliContext.Int(syncMaxRetriesFlag.Name)
+	if err := validateSyncRetry(syncRetryBaseDelay, syncRetryMaxDelay, syncMaxRetries); err != nil {
+		return err
+	}
 	autoCreateLabelsRaw := cliContext.StringSlice(autoCreateLabelsFlag.Name)
 	autoCreateLabels := parseLabelsKeyValuePairs(autoCreateLabelsRaw)
+	conflictStrategy, err := parseConflictStrategy(cliContext.String(conflictStrategyFlag.Name))
+	if err != nil {
+		return err
+	}
+	driftPolicy, err := parseDriftPolicy(cliContext.String(driftPolicyFlag.Name))
+	if err != nil {
+		return err
+	}
+	maxRemovalFraction := cliContext.Float64(maxRemovalFractionFlag.Name)
+	if err := validateMaxRemovalFraction(maxRemovalFraction); err != nil {
+		return err
+	}
+	guards := lockoutGuards{
+		minNodeRoleMappings: cliContext.Int(minNodeRoleMappingsFlag.Name),
+		mustKeepArns:        cliContext.StringSlice(mustKeepArnsFlag.Name),
+		maxRemovalFraction:  maxRemovalFraction,
+	}
+	livenessMultiplier := cliContext.Int(livenessIntervalMultiplierFlag.Name)
+	if err := validateLivenessIntervalMultiplier(livenessMultiplier); err != nil {
+		return err
+	}
+	leaderElectionNamespace := cliContext.String(leaderElectionNamespaceFlag.Name)
+	if leaderElectionNamespace == "" {
+		leaderElectionNamespace = namespace
+	}
+	leaderElection := leaderElectionConfig{
+		enabled:        cliContext.Bool(leaderElectFlag.Name),
+		leaseName:      cliContext.String(leaderElectionLeaseNameFlag.Name),
+		leaseNamespace: leaderElectionNamespace,
+		leaseDuration:  cliContext.Duration(leaderElectionLeaseDurationFlag.Name),
+		renewDeadline:  cliContext.Duration(leaderElectionRenewDeadlineFlag.Name),
+		retryPeriod:    cliContext.Duration(leaderElectionRetryPeriodFlag.Name),
+	}
 
 	kubeconfigPath := cliContext.String(kubeconfigPathFlag.Name)
 	if kubeconfigPath != "" {
@@ -117,12 +373,32 @@ func awsAuthMerger(cliContext *cli.Context) error {
 	kubeContext := cliContext.String(kubeContextFlag.Name)
 
 	authMerger := AwsAuthMerger{
-		namespace:        namespace,
-		labelSelector:    labelSelector,
-		autoCreateLabels: autoCreateLabels,
-		refreshInterval:  refreshInterval,
-		kubeconfig:       kubeconfigPath,
-		kubecontext:      kubeContext,
+		namespace:                namespace,
+		labelSelector:            labelSelector,
+		sourceNamespaces:         cliContext.StringSlice(sourceNamespacesFlag.Name),
+		sourceNamespaceSelector:  sourceNamespaceSelector,
+		watchSecrets:             watchSecrets,
+		watchIAMIdentityMappings: cliContext.Bool(watchIAMIdentityMappingsFlag.Name),
+		sourceDirectory:          sourceDirectory,
+		autoCreateLabels:         autoCreateLabels,
+		refreshInterval:          refreshInterval,
+		syncRetryBaseDelay:       syncRetryBaseDelay,
+		syncRetryMaxDelay:        syncRetryMaxDelay,
+		syncMaxRetries:           syncMaxRetries,
+		conflictStrategy:         conflictStrategy,
+		quarantineInvalidSources: cliContext.Bool(quarantineInvalidSourcesFlag.Name),
+		driftPolicy:              driftPolicy,
+		adoptEksNodeMappings:     cliContext.BoolT(adoptEksNodeMappingsFlag.Name),
+		removalGracePeriod:       cliContext.Duration(removalGracePeriodFlag.Name),
+		lockoutGuards:            guards,
+		leaderElection:           leaderElection,
+		verifyInformerCache:      cliContext.Bool(verifyInformerCacheFlag.Name),
+		shutdownTimeout:          cliContext.Duration(shutdownTimeoutFlag.Name),
+		metricsPort:              cliContext.Int(metricsPortFlag.Name),
+		healthPort:               cliContext.Int(healthPortFlag.Name),
+		livenessMultiplier:       livenessMultiplier,
+		kubeconfig:               kubeconfigPath,
+		kubecontext:              kubeContext,
 	}
 	return authMerger.eventLoop()
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// listAwsAuthConfigMapsFromCache will list the AWS Auth ConfigMaps that should be merged together from the informer
+// cache of the watcher. The informer is already filtered by the label selector, so this returns everything in the
+// watched Namespace. When watching multiple Namespaces, the informer watches the whole cluster, so the ConfigMaps are
+// filtered by the source Namespaces. The returned ConfigMaps are copies, since the objects in the cache are shared with
+// the informer and must not be modified. When watching Secrets and IAMIdentityMappings, those are listed from their
+// informer caches as well, and returned after the ConfigMaps.
+func (authMerger *AwsAuthMerger) listAwsAuthConfigMapsFromCache() ([]corev1.ConfigMap, error) {
+	var cached []*corev1.ConfigMap
+	var err error
+	if authMerger.watchesMultipleNamespaces() {
+		cached, err = authMerger.configMapLister.List(labels.Everything())
+	} else {
+		cached, err = authMerger.configMapLister.ConfigMaps(authMerger.namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	configmaps := make([]corev1.ConfigMap, 0, len(cached))
+	for _, configmap := range cached {
+		configmaps = append(configmaps, *configmap.DeepCopy())
+	}
+	if authMerger.watchesMultipleNamespaces() {
+		namespaces, err := authMerger.listSourceNamespaces()
+		if err != nil {
+			return nil, err
+		}
+		configmaps = filterConfigMapsByNamespace(configmaps, namespaces)
+	}
+	// The cache is not ordered, so sort by Namespace and name to match the order of the API.
+	sort.Slice(configmaps, func(i, j int) bool {
+		if configmaps[i].Namespace != configmaps[j].Namespace {
+			return configmaps[i].Namespace < configmaps[j].Namespace
+		}
+		return configmaps[i].Name < configmaps[j].Name
+	})
+
+	secrets, err := authMerger.listAwsAuthSecretsFromCache()
+	if err != nil {
+		return nil, err
+	}
+	mappings, err := authMerger.listAwsAuthIAMIdentityMappingsFromCache()
+	if err != nil {
+		return nil, err
+	}
+	return append(append(configmaps, secrets...), mappings...), nil
+}
+
+// verifyCachedConfigMaps lists the AWS Auth ConfigMaps from the Kubernetes API, and compares them with the given
+// ConfigMaps that were read from the informer cache. If the cache is out of date, a warning is logged and the ConfigMaps
+// from the API are returned so that the sync uses the latest version. Otherwise, the cached ConfigMaps are returned.
+func (authMerger *AwsAuthMerger) verifyCachedConfigMaps(cached []corev1.ConfigMap) ([]corev1.ConfigMap, error) {
+	fromAPI, err := authMerger.listAwsAuthConfigMapsFromAPI()
+	if err != nil {
+		return nil, err
+	}
+	if !isSameConfigMapVersions(cached, fromAPI) {
+		authMerger.logger.Warnf(
+			"The informer cache of ConfigMaps in %s with label selector %s is out of date (%d cached, %d in the API). Using the ConfigMaps from the API.",
+			authMerger.describeSourceNamespaces(),
+			authMerger.labelSelector,
+			len(cached),
+			len(fromAPI),
+		)
+		return fromAPI, nil
+	}
+	return cached, nil
+}
+
+// isSameConfigMapVersions returns true if the two lists contain the same ConfigMaps at the same resource versions,
+// regardless of order.
+func isSameConfigMapVersions(configmaps []corev1.ConfigMap, others []corev1.ConfigMap) bool {
+	if len(configmaps) != len(others) {
+		return false
+	}
+	// Secrets and ConfigMaps may have the same name, so the sources are keyed by kind as well.
+	versions := map[string]string{}
+	for _, configmap := range configmaps {
+		versions[sourceKind(configmap)+"/"+configmap.Namespace+"/"+configmap.Name] = configmap.ResourceVersion
+	}
+	for _, other := range others {
+		version, hasConfigMap := versions[sourceKind(other)+"/"+other.Namespace+"/"+other.Name]
+		if !hasConfigMap || version != other.ResourceVersion {
+			return false
+		}
+	}
+	return true
+}
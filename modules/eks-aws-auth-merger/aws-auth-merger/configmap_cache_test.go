@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Test that listAwsAuthConfigMaps reads the ConfigMaps from the informer cache without calling the API, and only
+// calls the API when verifying the cache is enabled.
+func TestListAwsAuthConfigMapsFromCache(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name                string
+		verifyInformerCache bool
+		staleCache          bool
+		expectedListCalls   int
+		expectedVersion     string
+	}{
+		{"cacheOnly", false, false, 0, "1"},
+		{"cacheOnlyStale", false, true, 0, "1"},
+		{"verifiedUpToDate", true, false, 1, "1"},
+		{"verifiedStale", true, true, 1, "2"},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cached := newAwsAuthConfigMap(t, "source", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+			cached.Namespace = "aws-auth-merger"
+			cached.ResourceVersion = "1"
+			other := newAwsAuthConfigMap(t, "other", "", []RoleMapping{}, []UserMapping{})
+			other.Namespace = "other-namespace"
+			inAPI := cached.DeepCopy()
+			if tc.staleCache {
+				inAPI.ResourceVersion = "2"
+			}
+
+			clientset := fake.NewSimpleClientset(inAPI)
+			listCalls := 0
+			clientset.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				listCalls++
+				return false, nil, nil
+			})
+			authMerger := AwsAuthMerger{
+				namespace:           "aws-auth-merger",
+				verifyInformerCache: tc.verifyInformerCache,
+				clientset:           clientset,
+				configMapLister:     newTestConfigMapLister(t, cached, other),
+				ctx:                 context.Background(),
+				logger:              logrus.New(),
+			}
+
+			configmaps, err := authMerger.listAwsAuthConfigMaps()
+			require.NoError(t, err)
+			require.Len(t, configmaps, 1)
+			assert.Equal(t, "source", configmaps[0].Name)
+			assert.Equal(t, tc.expectedVersion, configmaps[0].ResourceVersion)
+			assert.Equal(t, tc.expectedListCalls, listCalls)
+		})
+	}
+}
+
+// Test that the ConfigMaps returned from the cache are copies, so that modifying them does not corrupt the cache.
+func TestListAwsAuthConfigMapsFromCacheReturnsCopies(t *testing.T) {
+	t.Parallel()
+
+	cached := newAwsAuthConfigMap(t, "source", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	cached.Namespace = "aws-auth-merger"
+	lister := newTestConfigMapLister(t, cached)
+	authMerger := AwsAuthMerger{namespace: "aws-auth-merger", configMapLister: lister, logger: logrus.New()}
+
+	configmaps, err := authMerger.listAwsAuthConfigMaps()
+	require.NoError(t, err)
+	require.Len(t, configmaps, 1)
+	configmaps[0].Data[mapRolesKey] = ""
+
+	stored, err := lister.ConfigMaps("aws-auth-merger").Get("source")
+	require.NoError(t, err)
+	assert.Equal(t, cached.Data[mapRolesKey], stored.Data[mapRolesKey])
+}
+
+func TestIsSameConfigMapVersions(t *testing.T) {
+	t.Parallel()
+
+	first := corev1.ConfigMap{}
+	first.Namespace = "aws-auth-merger"
+	first.Name = "first"
+	first.ResourceVersion = "1"
+	second := *first.DeepCopy()
+	second.Name = "second"
+	updatedSecond := *second.DeepCopy()
+	updatedSecond.ResourceVersion = "2"
+
+	assert.True(t, isSameConfigMapVersions([]corev1.ConfigMap{}, []corev1.ConfigMap{}))
+	assert.True(t, isSameConfigMapVersions([]corev1.ConfigMap{first, second}, []corev1.ConfigMap{second, first}))
+	assert.False(t, isSameConfigMapVersions([]corev1.ConfigMap{first, second}, []corev1.ConfigMap{first}))
+	assert.False(t, isSameConfigMapVersions([]corev1.ConfigMap{first, second}, []corev1.ConfigMap{first, updatedSecond}))
+}
+
+// newTestConfigMapLister returns a ConfigMap lister backed by an in memory cache that holds the given ConfigMaps, in
+// the same way as the informer cache of the watcher.
+func newTestConfigMapLister(t *testing.T, configmaps ...corev1.ConfigMap) corelisters.ConfigMapLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for i := range configmaps {
+		require.NoError(t, indexer.Add(&configmaps[i]))
+	}
+	return corelisters.NewConfigMapLister(indexer)
+}
@@ -8,64 +8,274 @@ import (
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const (
 	resyncTime = time.Hour * 24
+
+	// Every sync merges all the ConfigMaps, so the sync queue only ever holds this single key. This way, the workqueue
+	// collapses the events that come in while a sync is pending into a single sync.
+	syncQueueKey = "aws-auth"
+	// How long to wait after a change is detected before syncing, so that multiple events that happen concurrently
+	// don't trigger a sync for every event.
+	syncDebounceInterval = 1 * time.Second
 )
 
-// ConfigMapWatchController will notify the given channel when ConfigMaps in the provided namespace with the given label
-// selector has changed.
+// ConfigMapWatchController will enqueue a sync on the given workqueue when ConfigMaps in the provided namespaces with
+// the given label selector has changed, along with the Secrets and IAMIdentityMappings with that label selector when
+// watching them. When there is more than one source Namespace, or Namespaces are selected by label, the ConfigMaps are
+// watched across the cluster and the events from other Namespaces are ignored. Secrets are always watched in each source
+// Namespace separately, so that the merger does not need access to the Secrets in other Namespaces. The Namespaces that match the Namespace
+// selector are watched as well, so that a sync is enqueued when a Namespace starts or stops matching the selector. It
+// also watches the main aws-auth ConfigMap, and enqueues a sync right away when it is deleted or modified outside of the
+// merger, so that node joins are not broken until the next refresh interval. The event handlers never block, so that
+// the informers keep delivering events while a sync is running or backing off.
 type ConfigMapWatchController struct {
-	informerFactory   informers.SharedInformerFactory
-	configMapInformer coreinformers.ConfigMapInformer
-	notifyChan        chan struct{}
-	logger            *logrus.Logger
+	informerFactory       informers.SharedInformerFactory
+	configMapInformer     coreinformers.ConfigMapInformer
+	mainInformerFactory   informers.SharedInformerFactory
+	mainConfigMapInformer coreinformers.ConfigMapInformer
+	queue                 workqueue.RateLimitingInterface
+	logger                *logrus.Logger
+
+	// The Namespaces that are watched by name, and the informer for the Namespaces that are selected by label. The
+	// informer is nil if there is no Namespace selector.
+	sourceNamespaces         map[string]bool
+	namespaceInformerFactory informers.SharedInformerFactory
+	namespaceInformer        coreinformers.NamespaceInformer
+
+	// The informers for the source Secrets in each source Namespace, which are empty if Secrets are not watched.
+	secretInformerFactories []informers.SharedInformerFactory
+	secretInformers         map[string]coreinformers.SecretInformer
+
+	// The informer for the source IAMIdentityMappings, which is nil if IAMIdentityMappings are not watched.
+	dynamicInformerFactory     dynamicinformer.DynamicSharedInformerFactory
+	iamIdentityMappingInformer informers.GenericInformer
 }
 
-// Run starts shared informers and waits for the shared informer cache to synchronize.
-func (controller *ConfigMapWatchController) Run(stopChan chan struct{}) error {
-	// Starts all the shared informers that have been created by the factory so far.
+// Run starts shared informers and waits for the shared informer caches to synchronize.
+func (controller *ConfigMapWatchController) Run(stopChan <-chan struct{}) error {
+	// Starts all the shared informers that have been created by the factories so far.
 	controller.informerFactory.Start(stopChan)
-	// Wait for the initial synchronization of the local cache.
-	if !cache.WaitForCacheSync(stopChan, controller.configMapInformer.Informer().HasSynced) {
+	controller.mainInformerFactory.Start(stopChan)
+	cacheSyncs := []cache.InformerSynced{
+		controller.configMapInformer.Informer().HasSynced,
+		controller.mainConfigMapInformer.Informer().HasSynced,
+	}
+	for _, secretInformerFactory := range controller.secretInformerFactories {
+		secretInformerFactory.Start(stopChan)
+	}
+	for _, secretInformer := range controller.secretInformers {
+		cacheSyncs = append(cacheSyncs, secretInformer.Informer().HasSynced)
+	}
+	if controller.iamIdentityMappingInformer != nil {
+		controller.dynamicInformerFactory.Start(stopChan)
+		cacheSyncs = append(cacheSyncs, controller.iamIdentityMappingInformer.Informer().HasSynced)
+	}
+	if controller.namespaceInformer != nil {
+		controller.namespaceInformerFactory.Start(stopChan)
+		cacheSyncs = append(cacheSyncs, controller.namespaceInformer.Informer().HasSynced)
+	}
+	// Wait for the initial synchronization of the local caches.
+	if !cache.WaitForCacheSync(stopChan, cacheSyncs...) {
 		return errors.WithStackTrace(fmt.Errorf("Failed to sync"))
 	}
 	return nil
 }
 
+// HasSynced returns true if the shared informer caches are synced.
+func (controller *ConfigMapWatchController) HasSynced() bool {
+	if controller.namespaceInformer != nil && !controller.namespaceInformer.Informer().HasSynced() {
+		return false
+	}
+	for _, secretInformer := range controller.secretInformers {
+		if !secretInformer.Informer().HasSynced() {
+			return false
+		}
+	}
+	if controller.iamIdentityMappingInformer != nil && !controller.iamIdentityMappingInformer.Informer().HasSynced() {
+		return false
+	}
+	return controller.configMapInformer.Informer().HasSynced() && controller.mainConfigMapInformer.Informer().HasSynced()
+}
+
+// Lister returns a lister that reads the watched ConfigMaps from the shared informer cache. The cache is only complete
+// once Run has returned without an error.
+func (controller *ConfigMapWatchController) Lister() corelisters.ConfigMapLister {
+	return controller.configMapInformer.Lister()
+}
+
+// SecretLister returns a lister that reads the watched Secrets in all the source Namespaces from the shared informer
+// caches. Returns nil if Secrets are not watched.
+func (controller *ConfigMapWatchController) SecretLister() corelisters.SecretLister {
+	if len(controller.secretInformers) == 0 {
+		return nil
+	}
+	lister := namespacedSecretLister{}
+	for namespace, secretInformer := range controller.secretInformers {
+		lister[namespace] = secretInformer.Lister()
+	}
+	return lister
+}
+
+// IAMIdentityMappingLister returns a lister that reads the watched IAMIdentityMappings from the shared informer cache,
+// as unstructured objects. Returns nil if IAMIdentityMappings are not watched.
+func (controller *ConfigMapWatchController) IAMIdentityMappingLister() cache.GenericLister {
+	if controller.iamIdentityMappingInformer == nil {
+		return nil
+	}
+	return controller.iamIdentityMappingInformer.Lister()
+}
+
+// NamespaceLister returns a lister that reads the Namespaces that match the Namespace selector from the shared informer
+// cache. Returns nil if there is no Namespace selector.
+func (controller *ConfigMapWatchController) NamespaceLister() corelisters.NamespaceLister {
+	if controller.namespaceInformer == nil {
+		return nil
+	}
+	return controller.namespaceInformer.Lister()
+}
+
+// isInSourceNamespace returns true if the given ConfigMap, or the tombstone of a deleted ConfigMap, is in one of the
+// source Namespaces.
+func (controller *ConfigMapWatchController) isInSourceNamespace(obj interface{}) bool {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return false
+	}
+	namespace, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return false
+	}
+	if controller.sourceNamespaces[namespace] {
+		return true
+	}
+	if controller.namespaceInformer == nil {
+		return false
+	}
+	_, err = controller.namespaceInformer.Lister().Get(namespace)
+	return err == nil
+}
+
+func (controller *ConfigMapWatchController) namespaceChanged(obj interface{}) {
+	controller.logger.Debugf("Detected change to the Namespaces that match the source Namespace selector: %v", obj)
+	controller.queue.AddAfter(syncQueueKey, syncDebounceInterval)
+}
+
 func (controller *ConfigMapWatchController) configMapAdded(obj interface{}) {
 	controller.logger.Debugf("Detected ConfigMap add: %v", obj.(*corev1.ConfigMap))
-	controller.notifyChan <- struct{}{}
+	controller.queue.AddAfter(syncQueueKey, syncDebounceInterval)
 }
 
 func (controller *ConfigMapWatchController) configMapUpdated(obj, updated interface{}) {
 	controller.logger.Debug("Detected ConfigMap update:")
 	controller.logger.Debugf("\tOld: %v", obj.(*corev1.ConfigMap))
 	controller.logger.Debugf("\tNew: %v", updated.(*corev1.ConfigMap))
-	controller.notifyChan <- struct{}{}
+	controller.queue.AddAfter(syncQueueKey, syncDebounceInterval)
 }
 
 func (controller *ConfigMapWatchController) configMapDeleted(obj interface{}) {
 	controller.logger.Debugf("Detected ConfigMap delete: %v", obj.(*corev1.ConfigMap))
-	controller.notifyChan <- struct{}{}
+	controller.queue.AddAfter(syncQueueKey, syncDebounceInterval)
+}
+
+// secretChanged enqueues a sync when a source Secret is added, updated, or deleted. Unlike for ConfigMaps, the Secret
+// itself is never logged, so that its contents don't end up in the logs.
+func (controller *ConfigMapWatchController) secretChanged(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		controller.logger.Debugf("Detected Secret change: %s", err)
+	} else {
+		controller.logger.Debugf("Detected Secret change: %s", key)
+	}
+	controller.queue.AddAfter(syncQueueKey, syncDebounceInterval)
+}
+
+// iamIdentityMappingChanged enqueues a sync when a source IAMIdentityMapping is added, updated, or deleted. This includes
+// the updates to the status by the merger itself, which result in a sync without changes.
+func (controller *ConfigMapWatchController) iamIdentityMappingChanged(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		controller.logger.Debugf("Detected IAMIdentityMapping change: %s", err)
+	} else {
+		controller.logger.Debugf("Detected IAMIdentityMapping change: %s", key)
+	}
+	controller.queue.AddAfter(syncQueueKey, syncDebounceInterval)
+}
+
+func (controller *ConfigMapWatchController) mainConfigMapAdded(obj interface{}) {
+	configmap := obj.(*corev1.ConfigMap)
+	if isModifiedOutsideMerger(configmap) {
+		controller.logger.Warnf("Detected ConfigMap %s in Namespace %s that was created outside of the aws-auth-merger. Syncing immediately.", configmap.Name, configmap.Namespace)
+		controller.queue.Add(syncQueueKey)
+	}
+}
+
+func (controller *ConfigMapWatchController) mainConfigMapUpdated(obj, updated interface{}) {
+	old := obj.(*corev1.ConfigMap)
+	configmap := updated.(*corev1.ConfigMap)
+	// Periodic resyncs deliver the same version of the ConfigMap, which does not need a sync.
+	if old.ResourceVersion == configmap.ResourceVersion {
+		return
+	}
+	if isModifiedOutsideMerger(configmap) {
+		controller.logger.Warnf("Detected changes to ConfigMap %s in Namespace %s made outside of the aws-auth-merger. Syncing immediately.", configmap.Name, configmap.Namespace)
+		controller.queue.Add(syncQueueKey)
+		return
+	}
+	// Allowing a write that was refused by the lockout guards only changes an annotation, so it has to be checked
+	// separately for the write to go through without waiting for the next refresh interval.
+	if allowedHash, hasAllowed := configmap.Annotations[allowUnsafeWriteAnnotationKey]; hasAllowed && allowedHash != old.Annotations[allowUnsafeWriteAnnotationKey] {
+		controller.logger.Warnf("Detected the %s annotation on ConfigMap %s in Namespace %s. Syncing immediately.", allowUnsafeWriteAnnotationKey, configmap.Name, configmap.Namespace)
+		controller.queue.Add(syncQueueKey)
+	}
+}
+
+func (controller *ConfigMapWatchController) mainConfigMapDeleted(obj interface{}) {
+	// The deleted object may be a tombstone if the delete event was missed, so we don't rely on its contents.
+	controller.logger.Warnf("Detected delete of ConfigMap %s in Namespace %s. Syncing immediately to recreate it.", mainAwsAuthConfigMapName, mainAwsAuthConfigMapNamespace)
+	controller.queue.Add(syncQueueKey)
+}
+
+// isModifiedOutsideMerger returns true if the given main aws-auth ConfigMap was not last written by the merger. The
+// merger records the hash of the data it writes, so any change to the data by something else makes the hash stale.
+func isModifiedOutsideMerger(configmap *corev1.ConfigMap) bool {
+	return !isManagedByMerger(configmap) || configmap.Annotations[contentHashAnnotationKey] != hashConfigMapData(configmap.Data)
 }
 
 func NewConfigMapWatchController(
 	logger *logrus.Logger,
-	clientset *kubernetes.Clientset,
-	namespace string,
+	clientset kubernetes.Interface,
+	namespaces []string,
 	labelSelector string,
-	notifyChan chan struct{},
+	namespaceSelector string,
+	watchSecrets bool,
+	dynamicClient dynamic.Interface,
+	queue workqueue.RateLimitingInterface,
 ) *ConfigMapWatchController {
+	sourceNamespaces := map[string]bool{}
+	for _, namespace := range namespaces {
+		sourceNamespaces[namespace] = true
+	}
+	// Informers can only watch a single Namespace or the whole cluster, so we watch the whole cluster when there is more
+	// than one source Namespace.
+	watchNamespace := metav1.NamespaceAll
+	if len(namespaces) == 1 && namespaceSelector == "" {
+		watchNamespace = namespaces[0]
+	}
 	informerFactory := informers.NewSharedInformerFactoryWithOptions(
 		clientset,
 		resyncTime,
-		informers.WithNamespace(namespace),
+		informers.WithNamespace(watchNamespace),
 		informers.WithTweakListOptions(
 			func(orig *metav1.ListOptions) {
 				orig.LabelSelector = labelSelector
@@ -76,19 +286,120 @@ func NewConfigMapWatchController(
 	controller := &ConfigMapWatchController{
 		informerFactory:   informerFactory,
 		configMapInformer: configMapInformer,
-		notifyChan:        notifyChan,
+		queue:             queue,
 		logger:            logger,
+		sourceNamespaces:  sourceNamespaces,
 	}
 
 	configMapInformer.Informer().AddEventHandler(
-		// Your custom resource event handlers.
+		// Ignore the ConfigMaps outside of the source Namespaces when watching the whole cluster.
+		cache.FilteringResourceEventHandler{
+			FilterFunc: controller.isInSourceNamespace,
+			// Your custom resource event handlers.
+			Handler: cache.ResourceEventHandlerFuncs{
+				// Called on creation
+				AddFunc: controller.configMapAdded,
+				// Called on resource update and every resyncPeriod on existing resources.
+				UpdateFunc: controller.configMapUpdated,
+				// Called on resource deletion.
+				DeleteFunc: controller.configMapDeleted,
+			},
+		},
+	)
+
+	// The Secrets are watched with the same label selector as the ConfigMaps, but with an informer for each of the
+	// Namespaces that are watched by name, instead of across the cluster. This way, the merger only needs access to the
+	// Secrets in the source Namespaces. Secrets can not be merged from the Namespaces that are selected by label.
+	controller.secretInformers = map[string]coreinformers.SecretInformer{}
+	if watchSecrets {
+		for _, namespace := range namespaces {
+			secretInformerFactory := informers.NewSharedInformerFactoryWithOptions(
+				clientset,
+				resyncTime,
+				informers.WithNamespace(namespace),
+				informers.WithTweakListOptions(
+					func(orig *metav1.ListOptions) {
+						orig.LabelSelector = labelSelector
+					},
+				),
+			)
+			secretInformer := secretInformerFactory.Core().V1().Secrets()
+			secretInformer.Informer().AddEventHandler(
+				cache.ResourceEventHandlerFuncs{
+					AddFunc:    controller.secretChanged,
+					UpdateFunc: func(obj, updated interface{}) { controller.secretChanged(updated) },
+					DeleteFunc: controller.secretChanged,
+				},
+			)
+			controller.secretInformerFactories = append(controller.secretInformerFactories, secretInformerFactory)
+			controller.secretInformers[namespace] = secretInformer
+		}
+	}
+
+	// The IAMIdentityMappings are custom resources, so they are watched with a dynamic informer, with the same Namespaces
+	// and label selector as the ConfigMaps.
+	if dynamicClient != nil {
+		controller.dynamicInformerFactory = dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+			dynamicClient,
+			resyncTime,
+			watchNamespace,
+			func(orig *metav1.ListOptions) {
+				orig.LabelSelector = labelSelector
+			},
+		)
+		controller.iamIdentityMappingInformer = controller.dynamicInformerFactory.ForResource(iamIdentityMappingResource)
+		controller.iamIdentityMappingInformer.Informer().AddEventHandler(
+			cache.FilteringResourceEventHandler{
+				FilterFunc: controller.isInSourceNamespace,
+				Handler: cache.ResourceEventHandlerFuncs{
+					AddFunc:    controller.iamIdentityMappingChanged,
+					UpdateFunc: func(obj, updated interface{}) { controller.iamIdentityMappingChanged(updated) },
+					DeleteFunc: controller.iamIdentityMappingChanged,
+				},
+			},
+		)
+	}
+
+	// The Namespaces that match the selector are watched so that the ConfigMaps in a Namespace are merged or dropped
+	// as soon as its labels change. We only need to know which Namespaces match, so the informer is filtered by the
+	// selector, and a Namespace that stops matching is seen as deleted.
+	if namespaceSelector != "" {
+		controller.namespaceInformerFactory = informers.NewSharedInformerFactoryWithOptions(
+			clientset,
+			resyncTime,
+			informers.WithTweakListOptions(
+				func(orig *metav1.ListOptions) {
+					orig.LabelSelector = namespaceSelector
+				},
+			),
+		)
+		controller.namespaceInformer = controller.namespaceInformerFactory.Core().V1().Namespaces()
+		controller.namespaceInformer.Informer().AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				AddFunc:    controller.namespaceChanged,
+				DeleteFunc: controller.namespaceChanged,
+			},
+		)
+	}
+
+	// The main aws-auth ConfigMap lives in kube-system, which is usually not the watched namespace, so it is watched with
+	// a separate informer that only sees that single ConfigMap.
+	controller.mainInformerFactory = informers.NewSharedInformerFactoryWithOptions(
+		clientset,
+		resyncTime,
+		informers.WithNamespace(mainAwsAuthConfigMapNamespace),
+		informers.WithTweakListOptions(
+			func(orig *metav1.ListOptions) {
+				orig.FieldSelector = fields.OneTermEqualSelector("metadata.name", mainAwsAuthConfigMapName).String()
+			},
+		),
+	)
+	controller.mainConfigMapInformer = controller.mainInformerFactory.Core().V1().ConfigMaps()
+	controller.mainConfigMapInformer.Informer().AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
-			// Called on creation
-			AddFunc: controller.configMapAdded,
-			// Called on resource update and every resyncPeriod on existing resources.
-			UpdateFunc: controller.configMapUpdated,
-			// Called on resource deletion.
-			DeleteFunc: controller.configMapDeleted,
+			AddFunc:    controller.mainConfigMapAdded,
+			UpdateFunc: controller.mainConfigMapUpdated,
+			DeleteFunc: controller.mainConfigMapDeleted,
 		},
 	)
 	return controller
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test that the watcher enqueues a sync right away when the main aws-auth ConfigMap is deleted or modified outside of
+// the merger, or when a write refused by the lockout guards is allowed, and ignores the writes of the merger itself.
+func TestConfigMapWatchControllerMainConfigMap(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		delete           bool
+		updateHash       bool
+		allowUnsafeWrite bool
+		expectedSync     bool
+	}{
+		{"mergerUpdate", false, true, false, false},
+		{"foreignUpdate", false, false, false, true},
+		{"delete", true, false, false, true},
+		{"allowUnsafeWrite", false, false, true, true},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			main := newAwsAuthConfigMap(t, mainAwsAuthConfigMapName, "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+			main.Namespace = mainAwsAuthConfigMapNamespace
+			main.Labels = map[string]string{managedByLabelKey: managedByLabelValue}
+			main.Annotations[contentHashAnnotationKey] = hashConfigMapData(main.Data)
+			clientset := fake.NewSimpleClientset(&main)
+
+			queue := newSyncQueue(time.Millisecond, 10*time.Millisecond)
+			defer queue.ShutDown()
+			stopChan := make(chan struct{})
+			defer close(stopChan)
+			controller := NewConfigMapWatchController(logrus.New(), clientset, []string{"aws-auth-merger"}, "", "", false, nil, queue)
+			require.NoError(t, controller.Run(stopChan))
+
+			// The main aws-auth ConfigMap was written by the merger, so it does not trigger a sync on startup.
+			time.Sleep(100 * time.Millisecond)
+			require.Equal(t, 0, queue.Len())
+
+			configmaps := clientset.CoreV1().ConfigMaps(mainAwsAuthConfigMapNamespace)
+			if tc.delete {
+				require.NoError(t, configmaps.Delete(context.Background(), mainAwsAuthConfigMapName, metav1.DeleteOptions{}))
+			} else {
+				updated := main.DeepCopy()
+				switch {
+				case tc.allowUnsafeWrite:
+					updated.Annotations[allowUnsafeWriteAnnotationKey] = "1234"
+				case tc.updateHash:
+					updated.Data[mapRolesKey] = "[]\n"
+					updated.Annotations[contentHashAnnotationKey] = hashConfigMapData(updated.Data)
+				default:
+					updated.Data[mapRolesKey] = "[]\n"
+				}
+				updated.ResourceVersion = "2"
+				_, err := configmaps.Update(context.Background(), updated, metav1.UpdateOptions{})
+				require.NoError(t, err)
+			}
+
+			if tc.expectedSync {
+				assert.Eventually(t, func() bool { return queue.Len() == 1 }, 5*time.Second, 10*time.Millisecond)
+			} else {
+				time.Sleep(100 * time.Millisecond)
+				assert.Equal(t, 0, queue.Len())
+			}
+		})
+	}
+}
+
+func TestIsModifiedOutsideMerger(t *testing.T) {
+	t.Parallel()
+
+	configmap := corev1.ConfigMap{Data: map[string]string{mapRolesKey: "[]\n"}}
+	assert.True(t, isModifiedOutsideMerger(&configmap))
+
+	configmap.Labels = map[string]string{managedByLabelKey: managedByLabelValue}
+	configmap.Annotations = map[string]string{contentHashAnnotationKey: hashConfigMapData(configmap.Data)}
+	assert.False(t, isModifiedOutsideMerger(&configmap))
+
+	configmap.Data[mapUsersKey] = "[]\n"
+	assert.True(t, isModifiedOutsideMerger(&configmap))
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// Name of the ConfigMap in the watch Namespace that the adopt drift policy copies out of band entries into.
+	adoptedConfigMapName = "adopted-aws-auth"
+)
+
+// driftPolicy determines what the merger does with entries in the main aws-auth ConfigMap that were added outside of
+// the merger, and thus don't come from any of the source ConfigMaps.
+type driftPolicy string
+
+const (
+	// Log the drift, and overwrite the main aws-auth ConfigMap with the merged mappings. This is the default.
+	driftPolicyRevert driftPolicy = "revert"
+	// Log the drift, and copy the out of band entries into a source ConfigMap in the watch Namespace so that they are
+	// merged in from then on.
+	driftPolicyAdopt driftPolicy = "adopt"
+	// Log the drift, and keep the out of band entries in the main aws-auth ConfigMap without managing them.
+	driftPolicyAlertOnly driftPolicy = "alert-only"
+)
+
+// validDriftPolicies lists all the supported drift policies, in the order they should be displayed to the user.
+var validDriftPolicies = []driftPolicy{
+	driftPolicyRevert,
+	driftPolicyAdopt,
+	driftPolicyAlertOnly,
+}
+
+// parseDriftPolicy converts the given string to a driftPolicy, returning an error if it is not one of the supported
+// policies.
+func parseDriftPolicy(policyRaw string) (driftPolicy, error) {
+	for _, policy := range validDriftPolicies {
+		if string(policy) == policyRaw {
+			return policy, nil
+		}
+	}
+	return "", errors.WithStackTrace(InvalidDriftPolicyErr(policyRaw))
+}
+
+// awsAuthDrift describes the changes that were made to the main aws-auth ConfigMap outside of the merger.
+type awsAuthDrift struct {
+	// Entries in the main aws-auth ConfigMap that are not managed by the merger, and are not in the merged mappings.
+	foreign parsedAwsAuthConfigMap
+	// Whether the data was modified since the merger last wrote it. Note that this includes the out of band changes to
+	// the entries managed by the merger, which are always reverted since the sources are the source of truth for them.
+	modified bool
+	// Whether the main aws-auth ConfigMap was not written by the merger. In this case, every entry that is not in the
+	// merged mappings is considered foreign.
+	unmanaged bool
+}
+
+// mappingAdoption describes mappings that the drift reconciliation copies into a source ConfigMap in the watch
+// Namespace, so that they are merged in from then on.
+type mappingAdoption struct {
+	// Name of the ConfigMap in the watch Namespace to copy the mappings into.
+	configMapName string
+	mappings      parsedAwsAuthConfigMap
+	// What the mappings are, for the logs.
+	description string
+}
+
+// reconcileDrift detects any out of band changes to the existing main aws-auth ConfigMap, and returns the ConfigMap
+// that should be written in its place according to the configured drift policy, along with the mappings that should be
+// adopted into source ConfigMaps. Mappings for EKS worker nodes are adopted regardless of the drift policy if
+// adoptEksNodeMappings is set, since reverting them locks the workers out of the cluster.
+//
+// This does not make any API calls: the adoptions are only persisted by persistAdoptions once the returned ConfigMap
+// passes the lockout guards and is written. Any adoptions that could not be persisted after the last write are added
+// back here, since the main aws-auth ConfigMap already manages them.
+func (authMerger *AwsAuthMerger) reconcileDrift(existing corev1.ConfigMap, desired corev1.ConfigMap) (corev1.ConfigMap, []mappingAdoption, error) {
+	adoptions := []mappingAdoption{}
+	for _, pending := range authMerger.pendingAdoptions {
+		var err error
+		desired, err = addMappingsToConfigMap(desired, pending.mappings, true)
+		if err != nil {
+			return desired, nil, err
+		}
+		adoptions = append(adoptions, pending)
+	}
+
+	drift, err := detectDrift(existing, desired)
+	if err != nil {
+		// The drift can't be detected if the existing ConfigMap can not be parsed, but that also means that EKS can't
+		// read it, so we revert it to restore access.
+		authMerger.logger.Warnf("Could not detect drift in ConfigMap %s in Namespace %s, so it will be reverted: %s", existing.Name, existing.Namespace, err)
+		return desired, adoptions, nil
+	}
+
+	foreign := drift.foreign
+	if authMerger.adoptEksNodeMappings {
+		var eksNodeMappings parsedAwsAuthConfigMap
+		eksNodeMappings, foreign = splitEksNodeMappings(drift.foreign)
+		if !eksNodeMappings.isEmpty() {
+			for _, description := range describeMappings(eksNodeMappings) {
+				authMerger.logger.Infof("Detected EKS worker node entry in ConfigMap %s in Namespace %s that did not come from any source ConfigMap: %s", existing.Name, existing.Namespace, description)
+			}
+			adoptions = append(adoptions, mappingAdoption{configMapName: eksNodeConfigMapName, mappings: eksNodeMappings, description: "EKS worker node entries"})
+			desired, err = addMappingsToConfigMap(desired, eksNodeMappings, true)
+			if err != nil {
+				return desired, nil, err
+			}
+		}
+	}
+
+	// If the ConfigMap is not managed by the merger, then it was either migrated at startup, or recreated outside of
+	// the merger. There are no managed mappings to compare against, so it is replaced like before.
+	if drift.unmanaged {
+		authMerger.logger.Infof("ConfigMap %s in Namespace %s is not managed by the aws-auth-merger. Replacing it with the merged ConfigMap.", existing.Name, existing.Namespace)
+		return desired, adoptions, nil
+	}
+	if drift.modified {
+		authMerger.logger.Warnf("Detected changes to ConfigMap %s in Namespace %s made outside of the aws-auth-merger. Entries managed by the aws-auth-merger will be reverted.", existing.Name, existing.Namespace)
+	}
+	if foreign.isEmpty() {
+		return desired, adoptions, nil
+	}
+	for _, description := range describeMappings(foreign) {
+		authMerger.logger.Warnf("Detected entry in ConfigMap %s in Namespace %s that did not come from any source ConfigMap (drift policy %s): %s", existing.Name, existing.Namespace, authMerger.driftPolicy, description)
+	}
+
+	switch authMerger.driftPolicy {
+	case driftPolicyAdopt:
+		adoptions = append(adoptions, mappingAdoption{configMapName: adoptedConfigMapName, mappings: foreign, description: "out of band entries"})
+		// Once the entries are in a source ConfigMap, they are managed by the merger.
+		desired, err = addMappingsToConfigMap(desired, foreign, true)
+		return desired, adoptions, err
+	case driftPolicyAlertOnly:
+		desired, err = addMappingsToConfigMap(desired, foreign, false)
+		return desired, adoptions, err
+	default:
+		authMerger.logger.Warnf("Reverting out of band entries in ConfigMap %s in Namespace %s.", existing.Name, existing.Namespace)
+		return desired, adoptions, nil
+	}
+}
+
+// persistAdoptions copies the mappings returned by reconcileDrift into their source ConfigMaps. This must only be
+// called once the main aws-auth ConfigMap that manages them is written. If an adoption fails, it and the remaining
+// adoptions are kept and retried on the next sync, so that the mappings are not removed from the main aws-auth
+// ConfigMap before they make it into a source ConfigMap.
+func (authMerger *AwsAuthMerger) persistAdoptions(adoptions []mappingAdoption) error {
+	authMerger.pendingAdoptions = nil
+	for i, adoption := range adoptions {
+		if err := authMerger.adoptMappings(adoption.configMapName, adoption.mappings); err != nil {
+			authMerger.pendingAdoptions = adoptions[i:]
+			return err
+		}
+		authMerger.logger.Infof("Adopted %s into ConfigMap %s in Namespace %s.", adoption.description, adoption.configMapName, authMerger.namespace)
+	}
+	return nil
+}
+
+// detectDrift compares the existing main aws-auth ConfigMap with the desired merged ConfigMap, and returns the changes
+// that were made outside of the merger. Entries are considered foreign if they are not in the managed mappings that
+// were recorded when the merger last wrote the ConfigMap, and if none of the sources provide them. This way, entries
+// that were removed from the sources are not mistaken for drift. If the ConfigMap was not written by the merger, all
+// the entries that none of the sources provide are considered foreign.
+func detectDrift(existing corev1.ConfigMap, desired corev1.ConfigMap) (awsAuthDrift, error) {
+	drift := awsAuthDrift{
+		foreign: parsedAwsAuthConfigMap{
+			name:        existing.Name,
+			mapRoles:    []RoleMapping{},
+			mapUsers:    []UserMapping{},
+			mapAccounts: []AccountMapping{},
+		},
+		modified:  existing.Annotations[contentHashAnnotationKey] != hashConfigMapData(existing.Data),
+		unmanaged: !isManagedByMerger(&existing),
+	}
+
+	managed := map[mappingKey]bool{}
+	if !drift.unmanaged {
+		// The managed mappings are not available if the ConfigMap was last written by an older version of the merger.
+		// In this case, all the entries came from the merger.
+		managedRaw, hasManaged := existing.Annotations[managedMappingsAnnotationKey]
+		if !hasManaged {
+			return drift, nil
+		}
+		var err error
+		managed, err = decodeManagedMappings(managedRaw)
+		if err != nil {
+			return drift, err
+		}
+	}
+	existingMappings, err := parseAwsAuthConfigMap(existing, conflictStrategyFail)
+	if err != nil {
+		return drift, err
+	}
+	desiredMappings, err := parseAwsAuthConfigMap(desired, conflictStrategyFail)
+	if err != nil {
+		return drift, err
+	}
+	desiredKeys := mappingKeySet(desiredMappings)
+
+	isForeign := func(key mappingKey) bool {
+		return !managed[key] && !desiredKeys[key]
+	}
+	for _, roleMapping := range existingMappings.mapRoles {
+		if isForeign(mappingKey{roleMappingType, roleMapping.RoleArn}) {
+			drift.foreign.mapRoles = append(drift.foreign.mapRoles, roleMapping)
+		}
+	}
+	for _, userMapping := range existingMappings.mapUsers {
+		if isForeign(mappingKey{userMappingType, userMapping.UserArn}) {
+			drift.foreign.mapUsers = append(drift.foreign.mapUsers, userMapping)
+		}
+	}
+	for _, accountMapping := range existingMappings.mapAccounts {
+		if isForeign(mappingKey{accountMappingType, string(accountMapping)}) {
+			drift.foreign.mapAccounts = append(drift.foreign.mapAccounts, accountMapping)
+		}
+	}
+	return drift, nil
+}
+
+// adoptMappings copies the given mappings into the ConfigMap with the given name in the watch Namespace, creating it
+// with the autocreate labels if it doesn't exist. Mappings that are already in the ConfigMap are left as is.
+func (authMerger *AwsAuthMerger) adoptMappings(configMapName string, mappings parsedAwsAuthConfigMap) error {
+	configmaps := authMerger.clientset.CoreV1().ConfigMaps(authMerger.namespace)
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		existing, err := configmaps.Get(authMerger.ctx, configMapName, metav1.GetOptions{})
+		if err != nil && k8serrors.IsNotFound(err) {
+			data, err := encodeAwsAuthMappings(mappings)
+			if err != nil {
+				return err
+			}
+			newConfigMap := corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      configMapName,
+					Namespace: authMerger.namespace,
+					Labels:    authMerger.autoCreateLabels,
+					Annotations: map[string]string{
+						autoCreateAnnotationKey: "true",
+					},
+				},
+				Data: data,
+			}
+			_, err = configmaps.Create(authMerger.ctx, &newConfigMap, metav1.CreateOptions{})
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		updated, err := addMappingsToConfigMap(*existing, mappings, false)
+		if err != nil {
+			return err
+		}
+		_, err = configmaps.Update(authMerger.ctx, &updated, metav1.UpdateOptions{})
+		return err
+	})
+	return errors.WithStackTrace(err)
+}
+
+// addMappingsToConfigMap returns a copy of the given aws-auth ConfigMap with the given mappings added, skipping any
+// mappings that are already in the ConfigMap. When the ConfigMap is a merged ConfigMap, the content hash annotation is
+// updated to match, and the new mappings are recorded in the managed mappings annotation if managed is true.
+func addMappingsToConfigMap(configmap corev1.ConfigMap, mappings parsedAwsAuthConfigMap, managed bool) (corev1.ConfigMap, error) {
+	updated := *configmap.DeepCopy()
+	current, err := parseAwsAuthConfigMap(updated, conflictStrategyFail)
+	if err != nil {
+		return updated, err
+	}
+	// The skip-later strategy never returns an error.
+	current.mapRoles, _, _ = mergeRoleMappingLists(current.mapRoles, mappings.mapRoles, conflictStrategySkipLater)
+	current.mapUsers, _, _ = mergeUserMappingLists(current.mapUsers, mappings.mapUsers, conflictStrategySkipLater)
+	current.mapAccounts, _, _ = mergeAccountMappingLists(current.mapAccounts, mappings.mapAccounts, conflictStrategySkipLater)
+
+	data, err := encodeAwsAuthMappings(current)
+	if err != nil {
+		return updated, err
+	}
+	for key, val := range data {
+		if updated.Data == nil {
+			updated.Data = map[string]string{}
+		}
+		updated.Data[key] = val
+	}
+
+	if _, isMerged := updated.Annotations[contentHashAnnotationKey]; !isMerged {
+		return updated, nil
+	}
+	updated.Annotations[contentHashAnnotationKey] = hashConfigMapData(updated.Data)
+	if managed {
+		managedJson, err := encodeManagedMappings(current)
+		if err != nil {
+			return updated, err
+		}
+		updated.Annotations[managedMappingsAnnotationKey] = managedJson
+	}
+	return updated, nil
+}
+
+// encodeManagedMappings encodes the keys of the given mappings as JSON, keyed by the aws-auth data key, so that they
+// can be recorded in the managed mappings annotation.
+func encodeManagedMappings(mappings parsedAwsAuthConfigMap) (string, error) {
+	managed := map[string][]string{
+		mapRolesKey:    {},
+		mapUsersKey:    {},
+		mapAccountsKey: {},
+	}
+	for _, roleMapping := range mappings.mapRoles {
+		managed[mapRolesKey] = append(managed[mapRolesKey], roleMapping.RoleArn)
+	}
+	for _, userMapping := range mappings.mapUsers {
+		managed[mapUsersKey] = append(managed[mapUsersKey], userMapping.UserArn)
+	}
+	for _, accountMapping := range mappings.mapAccounts {
+		managed[mapAccountsKey] = append(managed[mapAccountsKey], string(accountMapping))
+	}
+	managedJson, err := json.Marshal(managed)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	return string(managedJson), nil
+}
+
+// decodeManagedMappings decodes the managed mappings annotation into a set of mapping keys.
+func decodeManagedMappings(managedRaw string) (map[mappingKey]bool, error) {
+	var managed map[string][]string
+	if err := json.Unmarshal([]byte(managedRaw), &managed); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	keys := map[mappingKey]bool{}
+	for _, arn := range managed[mapRolesKey] {
+		keys[mappingKey{roleMappingType, arn}] = true
+	}
+	for _, arn := range managed[mapUsersKey] {
+		keys[mappingKey{userMappingType, arn}] = true
+	}
+	for _, accountID := range managed[mapAccountsKey] {
+		keys[mappingKey{accountMappingType, accountID}] = true
+	}
+	return keys, nil
+}
+
+// mappingKeySet returns the set of keys of the given mappings.
+func mappingKeySet(mappings parsedAwsAuthConfigMap) map[mappingKey]bool {
+	keys := map[mappingKey]bool{}
+	for _, roleMapping := range mappings.mapRoles {
+		keys[mappingKey{roleMappingType, roleMapping.RoleArn}] = true
+	}
+	for _, userMapping := range mappings.mapUsers {
+		keys[mappingKey{userMappingType, userMapping.UserArn}] = true
+	}
+	for _, accountMapping := range mappings.mapAccounts {
+		keys[mappingKey{accountMappingType, string(accountMapping)}] = true
+	}
+	return keys
+}
+
+// describeMappings returns a human readable description of each of the given mappings, for use in log messages.
+func describeMappings(mappings parsedAwsAuthConfigMap) []string {
+	descriptions := []string{}
+	for _, roleMapping := range mappings.mapRoles {
+		descriptions = append(descriptions, fmt.Sprintf("%s mapping %s", roleMappingType, roleMapping))
+	}
+	for _, userMapping := range mappings.mapUsers {
+		descriptions = append(descriptions, fmt.Sprintf("%s mapping %s", userMappingType, userMapping))
+	}
+	for _, accountMapping := range mappings.mapAccounts {
+		descriptions = append(descriptions, fmt.Sprintf("%s mapping %s", accountMappingType, accountMapping))
+	}
+	return descriptions
+}
+
+// Custom errors
+
+type InvalidDriftPolicyErr string
+
+func (err InvalidDriftPolicyErr) Error() string {
+	validPolicies := []string{}
+	for _, policy := range validDriftPolicies {
+		validPolicies = append(validPolicies, string(policy))
+	}
+	return fmt.Sprintf("Invalid drift policy %s. Must be one of: %s.", string(err), strings.Join(validPolicies, ", "))
+}
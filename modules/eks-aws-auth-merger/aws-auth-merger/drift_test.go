@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var (
+	managedRoleMapping = RoleMapping{RoleArn: "arn:aws:iam::123456789012:role/managed", Username: "managed", Groups: []string{"system:masters"}}
+	foreignRoleMapping = RoleMapping{RoleArn: "arn:aws:iam::123456789012:role/manual", Username: "manual", Groups: []string{"system:masters"}}
+)
+
+func TestDetectDrift(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		existing        func(t *testing.T, merged corev1.ConfigMap) corev1.ConfigMap
+		desiredRoles    []RoleMapping
+		expectedForeign []RoleMapping
+		expectModified  bool
+	}{
+		{
+			"noDrift",
+			func(t *testing.T, merged corev1.ConfigMap) corev1.ConfigMap { return merged },
+			[]RoleMapping{managedRoleMapping},
+			[]RoleMapping{},
+			false,
+		},
+		{
+			"foreignEntry",
+			func(t *testing.T, merged corev1.ConfigMap) corev1.ConfigMap {
+				return setRoleMappingsOutOfBand(t, merged, []RoleMapping{managedRoleMapping, foreignRoleMapping})
+			},
+			[]RoleMapping{managedRoleMapping},
+			[]RoleMapping{foreignRoleMapping},
+			true,
+		},
+		{
+			"foreignEntryNowInSources",
+			func(t *testing.T, merged corev1.ConfigMap) corev1.ConfigMap {
+				return setRoleMappingsOutOfBand(t, merged, []RoleMapping{managedRoleMapping, foreignRoleMapping})
+			},
+			[]RoleMapping{managedRoleMapping, foreignRoleMapping},
+			[]RoleMapping{},
+			true,
+		},
+		{
+			"managedEntryRemovedFromSources",
+			func(t *testing.T, merged corev1.ConfigMap) corev1.ConfigMap { return merged },
+			[]RoleMapping{},
+			[]RoleMapping{},
+			false,
+		},
+		{
+			"managedEntryModified",
+			func(t *testing.T, merged corev1.ConfigMap) corev1.ConfigMap {
+				modified := managedRoleMapping
+				modified.Username = "modified"
+				return setRoleMappingsOutOfBand(t, merged, []RoleMapping{modified})
+			},
+			[]RoleMapping{managedRoleMapping},
+			[]RoleMapping{},
+			true,
+		},
+		{
+			"noManagedMappingsAnnotation",
+			func(t *testing.T, merged corev1.ConfigMap) corev1.ConfigMap {
+				delete(merged.Annotations, managedMappingsAnnotationKey)
+				return setRoleMappingsOutOfBand(t, merged, []RoleMapping{managedRoleMapping, foreignRoleMapping})
+			},
+			[]RoleMapping{managedRoleMapping},
+			[]RoleMapping{},
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			existing := tc.existing(t, mergeTestConfigMaps(t, []RoleMapping{managedRoleMapping}))
+			desired := mergeTestConfigMaps(t, tc.desiredRoles)
+			drift, err := detectDrift(existing, desired)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedForeign, drift.foreign.mapRoles)
+			assert.Equal(t, len(tc.expectedForeign) > 0, !drift.foreign.isEmpty())
+			assert.Equal(t, tc.expectModified, drift.modified)
+		})
+	}
+}
+
+func TestReconcileDrift(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name                 string
+		policy               driftPolicy
+		expectedRoles        []RoleMapping
+		expectedManagedRoles []RoleMapping
+		expectAdopted        bool
+	}{
+		{"revert", driftPolicyRevert, []RoleMapping{managedRoleMapping}, []RoleMapping{managedRoleMapping}, false},
+		{"alertOnly", driftPolicyAlertOnly, []RoleMapping{managedRoleMapping, foreignRoleMapping}, []RoleMapping{managedRoleMapping}, false},
+		{"adopt", driftPolicyAdopt, []RoleMapping{managedRoleMapping, foreignRoleMapping}, []RoleMapping{managedRoleMapping, foreignRoleMapping}, true},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			desired := mergeTestConfigMaps(t, []RoleMapping{managedRoleMapping})
+			existing := setRoleMappingsOutOfBand(t, desired, []RoleMapping{managedRoleMapping, foreignRoleMapping})
+			existing.ResourceVersion = "1"
+			clientset := fake.NewSimpleClientset(&existing)
+			authMerger := AwsAuthMerger{
+				namespace:        "aws-auth-merger",
+				autoCreateLabels: map[string]string{"aws-auth-merger": "true"},
+				driftPolicy:      tc.policy,
+				clientset:        clientset,
+				ctx:              context.Background(),
+				logger:           logrus.New(),
+			}
+
+			_, action, err := authMerger.upsertConfigMap(desired)
+			require.NoError(t, err)
+			if tc.policy == driftPolicyRevert {
+				assert.Equal(t, upsertActionUpdated, action)
+			}
+
+			updated, err := clientset.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "aws-auth", metav1.GetOptions{})
+			require.NoError(t, err)
+			roles, err := getRoleMappingFromConfigMap(*updated)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedRoles, roles)
+			assert.Equal(t, hashConfigMapData(updated.Data), updated.Annotations[contentHashAnnotationKey])
+			expectedManaged, err := encodeManagedMappings(parsedAwsAuthConfigMap{mapRoles: tc.expectedManagedRoles})
+			require.NoError(t, err)
+			assert.Equal(t, expectedManaged, updated.Annotations[managedMappingsAnnotationKey])
+
+			adopted, err := clientset.CoreV1().ConfigMaps("aws-auth-merger").Get(context.Background(), adoptedConfigMapName, metav1.GetOptions{})
+			if !tc.expectAdopted {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, authMerger.autoCreateLabels, adopted.Labels)
+			assert.Equal(t, "true", adopted.Annotations[autoCreateAnnotationKey])
+			adoptedRoles, err := getRoleMappingFromConfigMap(*adopted)
+			require.NoError(t, err)
+			assert.Equal(t, []RoleMapping{foreignRoleMapping}, adoptedRoles)
+
+			// Once the adopted ConfigMap is merged in as a source, there is nothing left to update.
+			source := newAwsAuthConfigMap(t, "source", "", []RoleMapping{managedRoleMapping}, []UserMapping{})
+			result, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{*adopted, source}, mergeOptions{conflictStrategy: conflictStrategyFail})
+			require.NoError(t, err)
+			mergedRoles, err := getRoleMappingFromConfigMap(result.merged)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tc.expectedRoles, mergedRoles)
+		})
+	}
+}
+
+// Test that the out of band entries are not adopted when the lockout guards refuse to write the main aws-auth
+// ConfigMap.
+func TestReconcileDriftDoesNotAdoptWhenGuardTrips(t *testing.T) {
+	t.Parallel()
+
+	desired := mergeTestConfigMaps(t, []RoleMapping{managedRoleMapping})
+	existing := setRoleMappingsOutOfBand(t, desired, []RoleMapping{managedRoleMapping, foreignRoleMapping})
+	existing.ResourceVersion = "1"
+	clientset := fake.NewSimpleClientset(&existing)
+	authMerger := AwsAuthMerger{
+		namespace:        "aws-auth-merger",
+		autoCreateLabels: map[string]string{"aws-auth-merger": "true"},
+		driftPolicy:      driftPolicyAdopt,
+		lockoutGuards:    lockoutGuards{mustKeepArns: []string{"arn:aws:iam::123456789012:role/missing"}, maxRemovalFraction: 1},
+		clientset:        clientset,
+		ctx:              context.Background(),
+		logger:           logrus.New(),
+	}
+
+	_, _, err := authMerger.upsertConfigMap(desired)
+	require.Error(t, err)
+
+	_, err = clientset.CoreV1().ConfigMaps("aws-auth-merger").Get(context.Background(), adoptedConfigMapName, metav1.GetOptions{})
+	assert.True(t, k8serrors.IsNotFound(err))
+	assert.Empty(t, authMerger.pendingAdoptions)
+}
+
+// Test that the adopted entries are kept in the main aws-auth ConfigMap and adopted on the next sync when they could
+// not be copied into the adopted ConfigMap after the write.
+func TestReconcileDriftRetriesPendingAdoptions(t *testing.T) {
+	t.Parallel()
+
+	desired := mergeTestConfigMaps(t, []RoleMapping{managedRoleMapping})
+	existing := setRoleMappingsOutOfBand(t, desired, []RoleMapping{managedRoleMapping, foreignRoleMapping})
+	existing.ResourceVersion = "1"
+	clientset := fake.NewSimpleClientset(&existing)
+	createAttempts := 0
+	clientset.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		createAttempts++
+		if createAttempts == 1 {
+			return true, nil, fmt.Errorf("injected create error")
+		}
+		return false, nil, nil
+	})
+	authMerger := AwsAuthMerger{
+		namespace:        "aws-auth-merger",
+		autoCreateLabels: map[string]string{"aws-auth-merger": "true"},
+		driftPolicy:      driftPolicyAdopt,
+		clientset:        clientset,
+		ctx:              context.Background(),
+		logger:           logrus.New(),
+	}
+
+	_, _, err := authMerger.upsertConfigMap(desired)
+	require.Error(t, err)
+	require.Len(t, authMerger.pendingAdoptions, 1)
+
+	// The entry is now managed, so it is no longer detected as drift, but it must not be reverted before it is adopted.
+	written, _, err := authMerger.upsertConfigMap(desired)
+	require.NoError(t, err)
+	roles, err := getRoleMappingFromConfigMap(written)
+	require.NoError(t, err)
+	assert.Equal(t, []RoleMapping{managedRoleMapping, foreignRoleMapping}, roles)
+	assert.Empty(t, authMerger.pendingAdoptions)
+
+	adopted, err := clientset.CoreV1().ConfigMaps("aws-auth-merger").Get(context.Background(), adoptedConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	adoptedRoles, err := getRoleMappingFromConfigMap(*adopted)
+	require.NoError(t, err)
+	assert.Equal(t, []RoleMapping{foreignRoleMapping}, adoptedRoles)
+}
+
+func TestParseDriftPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range validDriftPolicies {
+		parsed, err := parseDriftPolicy(string(policy))
+		require.NoError(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+
+	_, err := parseDriftPolicy("ignore")
+	assert.Error(t, err)
+}
+
+// mergeTestConfigMaps returns the merged ConfigMap for a single source ConfigMap with the given role mappings.
+func mergeTestConfigMaps(t *testing.T, roleMapping []RoleMapping) corev1.ConfigMap {
+	source := newAwsAuthConfigMap(t, "source", "", roleMapping, []UserMapping{})
+	result, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{source}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	require.NoError(t, err)
+	return result.merged
+}
+
+// setRoleMappingsOutOfBand returns a copy of the given ConfigMap with the role mappings replaced, without updating any
+// of the annotations. This simulates an edit made by a human or by EKS.
+func setRoleMappingsOutOfBand(t *testing.T, configmap corev1.ConfigMap, roleMapping []RoleMapping) corev1.ConfigMap {
+	mapRolesYaml, err := yaml.Marshal(roleMapping)
+	require.NoError(t, err)
+	updated := *configmap.DeepCopy()
+	updated.Data[mapRolesKey] = string(mapRolesYaml)
+	return updated
+}
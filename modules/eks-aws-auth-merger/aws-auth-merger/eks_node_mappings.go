@@ -0,0 +1,59 @@
+package main
+
+const (
+	// Name of the ConfigMap in the watch Namespace that the worker node entries added by EKS are copied into.
+	eksNodeConfigMapName = "eks-node-aws-auth"
+
+	// EKS adds a role mapping with one of these usernames for the worker IAM role of Managed Node Groups (EC2 private
+	// DNS name) and for the pod execution IAM role of Fargate Profiles (session name).
+	eksNodeUsernameEC2     = "system:node:{{EC2PrivateDNSName}}"
+	eksNodeUsernameFargate = "system:node:{{SessionName}}"
+
+	// Every worker node mapping added by EKS includes this group. The mappings also include system:bootstrappers, and
+	// depending on the type of worker, additional groups such as system:node-proxier for Fargate.
+	eksNodeGroup = "system:nodes"
+)
+
+// isEksNodeRoleMapping returns true if the given role mapping looks like one that EKS adds to the main aws-auth
+// ConfigMap when a Managed Node Group or Fargate Profile is created.
+func isEksNodeRoleMapping(roleMapping RoleMapping) bool {
+	if roleMapping.Username != eksNodeUsernameEC2 && roleMapping.Username != eksNodeUsernameFargate {
+		return false
+	}
+	return isNodeRoleMapping(roleMapping)
+}
+
+// isNodeRoleMapping returns true if the given role mapping grants worker nodes access to the cluster, regardless of
+// whether it was added by EKS or by the user.
+func isNodeRoleMapping(roleMapping RoleMapping) bool {
+	for _, group := range roleMapping.Groups {
+		if group == eksNodeGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// splitEksNodeMappings splits the given mappings into the worker node role mappings added by EKS, and everything else.
+func splitEksNodeMappings(mappings parsedAwsAuthConfigMap) (parsedAwsAuthConfigMap, parsedAwsAuthConfigMap) {
+	eksNodeMappings := parsedAwsAuthConfigMap{
+		name:        mappings.name,
+		mapRoles:    []RoleMapping{},
+		mapUsers:    []UserMapping{},
+		mapAccounts: []AccountMapping{},
+	}
+	otherMappings := parsedAwsAuthConfigMap{
+		name:        mappings.name,
+		mapRoles:    []RoleMapping{},
+		mapUsers:    mappings.mapUsers,
+		mapAccounts: mappings.mapAccounts,
+	}
+	for _, roleMapping := range mappings.mapRoles {
+		if isEksNodeRoleMapping(roleMapping) {
+			eksNodeMappings.mapRoles = append(eksNodeMappings.mapRoles, roleMapping)
+		} else {
+			otherMappings.mapRoles = append(otherMappings.mapRoles, roleMapping)
+		}
+	}
+	return eksNodeMappings, otherMappings
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	eksNodeGroupRoleMapping = RoleMapping{
+		RoleArn:  "arn:aws:iam::123456789012:role/eks-node-group",
+		Username: "system:node:{{EC2PrivateDNSName}}",
+		Groups:   []string{"system:bootstrappers", "system:nodes"},
+	}
+	eksFargateRoleMapping = RoleMapping{
+		RoleArn:  "arn:aws:iam::123456789012:role/eks-fargate",
+		Username: "system:node:{{SessionName}}",
+		Groups:   []string{"system:bootstrappers", "system:nodes", "system:node-proxier"},
+	}
+)
+
+func TestIsEksNodeRoleMapping(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		roleMapping RoleMapping
+		expected    bool
+	}{
+		{"managedNodeGroup", eksNodeGroupRoleMapping, true},
+		{"fargateProfile", eksFargateRoleMapping, true},
+		{"admin", RoleMapping{RoleArn: "arn:aws:iam::123456789012:role/admin", Username: "admin", Groups: []string{"system:masters"}}, false},
+		{"nodeUsernameWithoutNodeGroup", RoleMapping{RoleArn: "arn:aws:iam::123456789012:role/other", Username: "system:node:{{EC2PrivateDNSName}}", Groups: []string{"system:masters"}}, false},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, isEksNodeRoleMapping(tc.roleMapping))
+		})
+	}
+}
+
+// Test that the worker node entries added by EKS are adopted into the EKS node ConfigMap even with the revert drift
+// policy, while other out of band entries are still reverted.
+func TestReconcileDriftAdoptsEksNodeMappings(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		unmanaged bool
+	}{
+		{"managed", false},
+		{"unmanaged", true},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			desired := mergeTestConfigMaps(t, []RoleMapping{managedRoleMapping})
+			existing := setRoleMappingsOutOfBand(
+				t,
+				desired,
+				[]RoleMapping{managedRoleMapping, eksNodeGroupRoleMapping, foreignRoleMapping, eksFargateRoleMapping},
+			)
+			existing.ResourceVersion = "1"
+			if tc.unmanaged {
+				existing.Labels = map[string]string{}
+				existing.Annotations = map[string]string{}
+			}
+			clientset := fake.NewSimpleClientset(&existing)
+			authMerger := AwsAuthMerger{
+				namespace:            "aws-auth-merger",
+				autoCreateLabels:     map[string]string{"aws-auth-merger": "true"},
+				driftPolicy:          driftPolicyRevert,
+				adoptEksNodeMappings: true,
+				lockoutGuards:        lockoutGuards{maxRemovalFraction: 1},
+				clientset:            clientset,
+				ctx:                  context.Background(),
+				logger:               logrus.New(),
+			}
+
+			_, action, err := authMerger.upsertConfigMap(desired)
+			require.NoError(t, err)
+			assert.Equal(t, upsertActionUpdated, action)
+
+			expectedRoles := []RoleMapping{managedRoleMapping, eksNodeGroupRoleMapping, eksFargateRoleMapping}
+			updated, err := clientset.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "aws-auth", metav1.GetOptions{})
+			require.NoError(t, err)
+			roles, err := getRoleMappingFromConfigMap(*updated)
+			require.NoError(t, err)
+			assert.Equal(t, expectedRoles, roles)
+			expectedManaged, err := encodeManagedMappings(parsedAwsAuthConfigMap{mapRoles: expectedRoles})
+			require.NoError(t, err)
+			assert.Equal(t, expectedManaged, updated.Annotations[managedMappingsAnnotationKey])
+
+			adopted, err := clientset.CoreV1().ConfigMaps("aws-auth-merger").Get(context.Background(), eksNodeConfigMapName, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, authMerger.autoCreateLabels, adopted.Labels)
+			assert.Equal(t, "true", adopted.Annotations[autoCreateAnnotationKey])
+			adoptedRoles, err := getRoleMappingFromConfigMap(*adopted)
+			require.NoError(t, err)
+			assert.Equal(t, []RoleMapping{eksNodeGroupRoleMapping, eksFargateRoleMapping}, adoptedRoles)
+
+			// Once the EKS node ConfigMap is merged in as a source, the worker node entries are kept without any drift.
+			source := newAwsAuthConfigMap(t, "source", "", []RoleMapping{managedRoleMapping}, []UserMapping{})
+			result, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{*adopted, source}, mergeOptions{conflictStrategy: conflictStrategyFail})
+			require.NoError(t, err)
+			mergedRoles, err := getRoleMappingFromConfigMap(result.merged)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, expectedRoles, mergedRoles)
+			drift, err := detectDrift(*updated, result.merged)
+			require.NoError(t, err)
+			assert.True(t, drift.foreign.isEmpty())
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the Kubernetes Events that the merger records on the source ConfigMaps and the main aws-auth ConfigMap, so
+// that the owners of the ConfigMaps can see the outcome of the merge with kubectl describe.
+const (
+	// Recorded on a source ConfigMap when its mappings made it into the main aws-auth ConfigMap.
+	eventReasonAccepted = "Accepted"
+	// Recorded on the source ConfigMaps that have conflicting mappings.
+	eventReasonMappingConflict = "MappingConflict"
+	// Recorded on a source ConfigMap that can not be parsed.
+	eventReasonInvalidConfigMap = "InvalidConfigMap"
+	// Recorded on the main aws-auth ConfigMap when the merger creates or updates it.
+	eventReasonUpdated = "Updated"
+	// Recorded on the main aws-auth ConfigMap when the merged ConfigMap fails the lockout guards.
+	eventReasonLockoutGuardTripped = "LockoutGuardTripped"
+)
+
+// newEventRecorder returns a recorder that records Events as the merger, along with a function to stop recording.
+func (authMerger *AwsAuthMerger) newEventRecorder() (record.EventRecorder, func()) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: authMerger.clientset.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: commandName})
+	return recorder, broadcaster.Shutdown
+}
+
+// recordMergeErrEvents records a warning Event on the source ConfigMaps that caused the merge to fail, if the given
+// error from the merge can be traced back to them.
+func (authMerger *AwsAuthMerger) recordMergeErrEvents(configmaps []corev1.ConfigMap, err error) {
+	switch mergeErr := errors.Unwrap(err).(type) {
+	case MappingConflictErr:
+		authMerger.recordConflictEvents(configmaps, mergeErr, "The aws-auth ConfigMap in kube-system was not updated")
+	case InvalidMappingListErr:
+		authMerger.recordSourceEvent(configmaps, mergeErr.configMapName, corev1.EventTypeWarning, eventReasonInvalidConfigMap, fmt.Sprintf("%s The aws-auth ConfigMap in kube-system was not updated.", mergeErr))
+	case InvalidPriorityErr:
+		authMerger.recordSourceEvent(configmaps, mergeErr.configMapName, corev1.EventTypeWarning, eventReasonInvalidConfigMap, fmt.Sprintf("%s The aws-auth ConfigMap in kube-system was not updated.", mergeErr))
+	}
+}
+
+// recordMergeResultEvents records warning Events on the source ConfigMaps that were quarantined or had conflicts
+// that were resolved by the conflict strategy.
+func (authMerger *AwsAuthMerger) recordMergeResultEvents(configmaps []corev1.ConfigMap, result mergeResult) {
+	for name, reason := range result.rejected {
+		authMerger.recordSourceEvent(configmaps, name, corev1.EventTypeWarning, eventReasonInvalidConfigMap, fmt.Sprintf("%s The ConfigMap was excluded from the merge.", reason))
+	}
+	for _, conflict := range result.resolvedConflicts {
+		authMerger.recordConflictEvents(configmaps, conflict, fmt.Sprintf("Resolved using the %s conflict strategy", conflict.strategy))
+	}
+}
+
+// recordAcceptedEvents records an Event on each source ConfigMap that was merged into the main aws-auth ConfigMap, with
+// the number of its mappings that were accepted. To avoid recording the same Event on every sync, this is only recorded
+// once for each version of the source ConfigMap.
+// Files in the source directory are not Kubernetes objects, so no Events are recorded for them.
+func (authMerger *AwsAuthMerger) recordAcceptedEvents(configmaps []corev1.ConfigMap, result mergeResult) {
+	if authMerger.recorder == nil {
+		return
+	}
+	if authMerger.acceptedVersions == nil {
+		authMerger.acceptedVersions = map[string]string{}
+	}
+	for i := range configmaps {
+		configmap := &configmaps[i]
+		if isFileSource(*configmap) {
+			continue
+		}
+		name := authMerger.mergeOptions().sourceName(*configmap)
+		if _, isRejected := result.rejected[name]; isRejected {
+			delete(authMerger.acceptedVersions, name)
+			continue
+		}
+		if version, isAccepted := authMerger.acceptedVersions[name]; isAccepted && version == configmap.ResourceVersion {
+			continue
+		}
+		authMerger.acceptedVersions[name] = configmap.ResourceVersion
+		accepted := result.accepted[name]
+		authMerger.recorder.Eventf(
+			sourceEventObject(configmap),
+			corev1.EventTypeNormal,
+			eventReasonAccepted,
+			"The mappings in this %s are included in ConfigMap %s in Namespace %s: %d role, %d user, and %d account mappings.",
+			sourceKind(*configmap),
+			mainAwsAuthConfigMapName,
+			mainAwsAuthConfigMapNamespace,
+			accepted.roles,
+			accepted.users,
+			accepted.accounts,
+		)
+	}
+}
+
+// recordMainConfigMapEvent records an Event on the main aws-auth ConfigMap. The existing ConfigMap is used to identify
+// it when available, so that the Event shows up in kubectl describe.
+func (authMerger *AwsAuthMerger) recordMainConfigMapEvent(existing *corev1.ConfigMap, eventType string, reason string, message string) {
+	if authMerger.recorder == nil {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		Kind:       "ConfigMap",
+		APIVersion: "v1",
+		Name:       mainAwsAuthConfigMapName,
+		Namespace:  mainAwsAuthConfigMapNamespace,
+	}
+	if existing != nil {
+		ref.UID = existing.UID
+		ref.ResourceVersion = existing.ResourceVersion
+	}
+	authMerger.recorder.Event(ref, eventType, reason, message)
+}
+
+// recordConflictEvents records a warning Event about the given conflict on both of the source ConfigMaps that were
+// involved.
+func (authMerger *AwsAuthMerger) recordConflictEvents(configmaps []corev1.ConfigMap, conflict MappingConflictErr, outcome string) {
+	message := fmt.Sprintf("%s %s.", conflict, outcome)
+	authMerger.recordSourceEvent(configmaps, conflict.configMapName, corev1.EventTypeWarning, eventReasonMappingConflict, message)
+	if conflict.existingConfigMapName != conflict.configMapName {
+		authMerger.recordSourceEvent(configmaps, conflict.existingConfigMapName, corev1.EventTypeWarning, eventReasonMappingConflict, message)
+	}
+}
+
+// recordSourceEvent records an Event on the source ConfigMap or Secret with the given source name. Nothing is recorded if
+// the name is not one of the given source ConfigMaps, or if it is a file in the source directory.
+func (authMerger *AwsAuthMerger) recordSourceEvent(configmaps []corev1.ConfigMap, name string, eventType string, reason string, message string) {
+	if authMerger.recorder == nil {
+		return
+	}
+	for i := range configmaps {
+		if authMerger.mergeOptions().sourceName(configmaps[i]) == name {
+			if isFileSource(configmaps[i]) {
+				return
+			}
+			authMerger.recorder.Event(sourceEventObject(&configmaps[i]), eventType, reason, message)
+			return
+		}
+	}
+}
+
+// sourceEventObject returns the object to record Events about the given source ConfigMap on. Sources that were read
+// from other kinds of objects are referenced as the original object, as the Event recorder would otherwise reference a
+// ConfigMap of the same name.
+func sourceEventObject(configmap *corev1.ConfigMap) runtime.Object {
+	if sourceKind(*configmap) == configMapSourceKind {
+		return configmap
+	}
+	return &corev1.ObjectReference{
+		Kind:            configmap.Kind,
+		APIVersion:      configmap.APIVersion,
+		Namespace:       configmap.Namespace,
+		Name:            configmap.Name,
+		UID:             configmap.UID,
+		ResourceVersion: configmap.ResourceVersion,
+	}
+}
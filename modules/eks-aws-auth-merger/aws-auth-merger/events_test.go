@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test that syncing records Events on the source ConfigMaps and the main aws-auth ConfigMap for the outcome of the
+// merge.
+func TestSyncEvents(t *testing.T) {
+	t.Parallel()
+
+	conflicting := adminRoleMapping
+	conflicting.Username = "other-admin"
+
+	testCases := []struct {
+		name           string
+		sources        map[string][]RoleMapping
+		invalidSource  string
+		quarantine     bool
+		strategy       conflictStrategy
+		expectedEvents []string
+	}{
+		{
+			"accepted",
+			map[string][]RoleMapping{"team-a": {adminRoleMapping}, "team-b": {deployRoleMapping}},
+			"",
+			false,
+			conflictStrategyFail,
+			[]string{
+				"Normal Updated aws-auth",
+				"Normal Accepted team-a",
+				"Normal Accepted team-b",
+			},
+		},
+		{
+			"conflict",
+			map[string][]RoleMapping{"team-a": {adminRoleMapping}, "team-b": {conflicting}},
+			"",
+			false,
+			conflictStrategyFail,
+			[]string{
+				"Warning MappingConflict team-b",
+				"Warning MappingConflict team-a",
+			},
+		},
+		{
+			"resolvedConflict",
+			map[string][]RoleMapping{"team-a": {adminRoleMapping}, "team-b": {conflicting}},
+			"",
+			false,
+			conflictStrategySkipLater,
+			[]string{
+				"Warning MappingConflict team-b",
+				"Warning MappingConflict team-a",
+				"Normal Updated aws-auth",
+				"Normal Accepted team-a",
+				"Normal Accepted team-b",
+			},
+		},
+		{
+			"invalid",
+			map[string][]RoleMapping{"team-a": {adminRoleMapping}, "team-b": {deployRoleMapping}},
+			"team-b",
+			false,
+			conflictStrategyFail,
+			[]string{
+				"Warning InvalidConfigMap team-b",
+			},
+		},
+		{
+			"quarantined",
+			map[string][]RoleMapping{"team-a": {adminRoleMapping}, "team-b": {deployRoleMapping}},
+			"team-b",
+			true,
+			conflictStrategyFail,
+			[]string{
+				"Warning InvalidConfigMap team-b",
+				"Normal Updated aws-auth",
+				"Normal Accepted team-a",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			objects := []runtime.Object{}
+			for name, mappings := range tc.sources {
+				source := newAwsAuthConfigMap(t, name, "", mappings, []UserMapping{})
+				source.Namespace = "aws-auth-merger"
+				if name == tc.invalidSource {
+					source.Data[mapRolesKey] = "- rolearn: [not, a, string"
+				}
+				objects = append(objects, &source)
+			}
+			recorder := &testEventRecorder{}
+			authMerger := AwsAuthMerger{
+				namespace:                "aws-auth-merger",
+				conflictStrategy:         tc.strategy,
+				quarantineInvalidSources: tc.quarantine,
+				clientset:                fake.NewSimpleClientset(objects...),
+				recorder:                 recorder,
+				ctx:                      context.Background(),
+				logger:                   logrus.New(),
+			}
+
+			// Ignore the sync error, as some of the test cases are expected to fail.
+			authMerger.syncAwsAuthConfigMaps()
+			assert.ElementsMatch(t, tc.expectedEvents, recorder.drain())
+
+			// Syncing again without changes does not record the Accepted and Updated Events again.
+			authMerger.syncAwsAuthConfigMaps()
+			for _, event := range recorder.drain() {
+				assert.True(t, strings.HasPrefix(event, "Warning"), event)
+			}
+		})
+	}
+}
+
+// Test that a merged ConfigMap that fails the lockout guards records an Event on the main aws-auth ConfigMap.
+func TestSyncEventsLockoutGuard(t *testing.T) {
+	t.Parallel()
+
+	source := newAwsAuthConfigMap(t, "team-a", "", []RoleMapping{deployRoleMapping}, []UserMapping{})
+	source.Namespace = "aws-auth-merger"
+	main := newAwsAuthConfigMap(t, mainAwsAuthConfigMapName, "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	main.Namespace = mainAwsAuthConfigMapNamespace
+	recorder := &testEventRecorder{}
+	authMerger := AwsAuthMerger{
+		namespace:     "aws-auth-merger",
+		lockoutGuards: lockoutGuards{mustKeepArns: []string{adminRoleMapping.RoleArn}, maxRemovalFraction: 1},
+		clientset:     fake.NewSimpleClientset(&source, &main),
+		recorder:      recorder,
+		ctx:           context.Background(),
+		logger:        logrus.New(),
+	}
+
+	require.Error(t, authMerger.syncAwsAuthConfigMaps())
+	assert.Equal(t, []string{"Warning LockoutGuardTripped aws-auth"}, recorder.drain())
+}
+
+// testEventRecorder is an EventRecorder that keeps the recorded Events in memory as the type, reason, and name of the
+// object they were recorded on, so that tests can check which ConfigMap an Event was recorded on.
+type testEventRecorder struct {
+	events []string
+}
+
+func (recorder *testEventRecorder) Event(object runtime.Object, eventType, reason, message string) {
+	name := ""
+	if accessor, err := meta.Accessor(object); err == nil {
+		name = accessor.GetName()
+	} else if ref, isRef := object.(*corev1.ObjectReference); isRef {
+		name = ref.Name
+	}
+	recorder.events = append(recorder.events, eventType+" "+reason+" "+name)
+}
+
+func (recorder *testEventRecorder) Eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	recorder.Event(object, eventType, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (recorder *testEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventType, reason, messageFmt string, args ...interface{}) {
+	recorder.Event(object, eventType, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// drain returns the Events that were recorded since the last call.
+func (recorder *testEventRecorder) drain() []string {
+	events := recorder.events
+	recorder.events = []string{}
+	return events
+}
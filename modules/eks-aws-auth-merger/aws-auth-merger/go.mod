@@ -3,10 +3,13 @@ module github.com/gruntwork-io/terraform-aws-eks/modules/aws-auth-merger/aws-aut
 go 1.15
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gruntwork-io/gruntwork-cli v0.7.0
 	github.com/gruntwork-io/terratest v0.40.0
+	github.com/hashicorp/go-multierror v1.1.0
 	github.com/hashicorp/golang-lru v0.5.3 // indirect
 	github.com/mitchellh/go-homedir v1.1.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli v1.22.2
@@ -15,4 +18,5 @@ require (
 	k8s.io/apimachinery v0.20.6
 	k8s.io/client-go v0.20.6
 	sigs.k8s.io/structured-merge-diff/v4 v4.1.2 // indirect
+	sigs.k8s.io/yaml v1.2.0
 )
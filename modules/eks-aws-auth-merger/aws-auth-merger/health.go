@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+)
+
+const (
+	livenessPath  = "/healthz"
+	readinessPath = "/readyz"
+)
+
+// healthChecker tracks the health of the merge loop for the liveness and readiness probes. All the methods are safe to
+// call on a nil healthChecker, in which case nothing is tracked, so that the merger can run without the probes.
+//
+// A replica that is standing by for the leader Lease does not sync, so it is always reported as live and ready.
+// Otherwise, a rollout of the Deployment would wait forever for the new standby replicas to become ready.
+type healthChecker struct {
+	// How long the merge loop may run without a successful sync before it is reported as not live.
+	livenessThreshold time.Duration
+
+	mutex sync.Mutex
+	// Whether this replica is running the merge loop, and since when.
+	running        bool
+	runningSince   time.Time
+	lastSuccessful time.Time
+	// Returns whether the informer caches of the watcher are synced. Nil until the watcher is set up.
+	informersSynced func() bool
+	// Returns the current time. Overridden in tests.
+	now func() time.Time
+}
+
+// validateLivenessIntervalMultiplier returns an error if the given multiplier of the refresh interval would make the
+// liveness probe fail before the next forced sync.
+func validateLivenessIntervalMultiplier(multiplier int) error {
+	if multiplier < 1 {
+		return errors.WithStackTrace(InvalidLivenessIntervalMultiplierErr(multiplier))
+	}
+	return nil
+}
+
+func newHealthChecker(livenessThreshold time.Duration) *healthChecker {
+	return &healthChecker{livenessThreshold: livenessThreshold, now: time.Now}
+}
+
+// startedMergeLoop records that this replica started running the merge loop. Any syncs from a previous run are
+// forgotten, so that the replica is only ready once it has synced again.
+func (health *healthChecker) startedMergeLoop() {
+	if health == nil {
+		return
+	}
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.running = true
+	health.runningSince = health.now()
+	health.lastSuccessful = time.Time{}
+	health.informersSynced = nil
+}
+
+// stoppedMergeLoop records that this replica stopped running the merge loop.
+func (health *healthChecker) stoppedMergeLoop() {
+	if health == nil {
+		return
+	}
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.running = false
+}
+
+// setInformersSynced records the function to call to check if the informer caches of the watcher are synced.
+func (health *healthChecker) setInformersSynced(informersSynced func() bool) {
+	if health == nil {
+		return
+	}
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.informersSynced = informersSynced
+}
+
+// recordSync records the result of a sync.
+func (health *healthChecker) recordSync(err error) {
+	if health == nil || err != nil {
+		return
+	}
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.lastSuccessful = health.now()
+}
+
+// checkReadiness returns an error if the merge loop is running, but the watcher is not synced or no sync has
+// succeeded yet.
+func (health *healthChecker) checkReadiness() error {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	if !health.running {
+		return nil
+	}
+	if health.informersSynced == nil || !health.informersSynced() {
+		return fmt.Errorf("the watcher for ConfigMaps is not synced")
+	}
+	if health.lastSuccessful.IsZero() {
+		return fmt.Errorf("the aws-auth ConfigMaps have not been synced yet")
+	}
+	return nil
+}
+
+// checkLiveness returns an error if the merge loop is running, but has not synced successfully within the liveness
+// threshold. Before the first successful sync, the threshold counts from when the merge loop started.
+func (health *healthChecker) checkLiveness() error {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	if !health.running {
+		return nil
+	}
+	lastSuccessful := health.lastSuccessful
+	if lastSuccessful.IsZero() {
+		lastSuccessful = health.runningSince
+	}
+	if sinceSuccessful := health.now().Sub(lastSuccessful); sinceSuccessful > health.livenessThreshold {
+		return fmt.Errorf("no successful sync of the aws-auth ConfigMaps in %s, which is more than %s", sinceSuccessful.Round(time.Second), health.livenessThreshold)
+	}
+	return nil
+}
+
+// probeHandler returns an HTTP handler that responds with 200 if the given check passes, and 503 with the error
+// otherwise.
+func probeHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "%s\n", err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// startHealthServer starts serving the liveness and readiness probes on the configured port in the background, until
+// the given context is done.
+func (authMerger *AwsAuthMerger) startHealthServer(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle(livenessPath, probeHandler(authMerger.health.checkLiveness))
+	mux.Handle(readinessPath, probeHandler(authMerger.health.checkReadiness))
+	if err := authMerger.startHTTPServer(ctx, "health probes", authMerger.healthPort, mux); err != nil {
+		return err
+	}
+	authMerger.logger.Infof("Serving health probes on port %d at %s and %s", authMerger.healthPort, livenessPath, readinessPath)
+	return nil
+}
+
+// Custom errors
+
+type InvalidLivenessIntervalMultiplierErr int
+
+func (err InvalidLivenessIntervalMultiplierErr) Error() string {
+	return fmt.Sprintf("Invalid liveness interval multiplier %d. Must be at least 1.", int(err))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that readiness requires the watcher to be synced and a successful sync, and that standby replicas are ready.
+func TestHealthCheckerReadiness(t *testing.T) {
+	t.Parallel()
+
+	health := newHealthChecker(time.Minute)
+	assert.NoError(t, health.checkReadiness())
+
+	health.startedMergeLoop()
+	assert.Error(t, health.checkReadiness())
+
+	informersSynced := false
+	health.setInformersSynced(func() bool { return informersSynced })
+	assert.Error(t, health.checkReadiness())
+	informersSynced = true
+	assert.Error(t, health.checkReadiness())
+
+	health.recordSync(fmt.Errorf("injected sync error"))
+	assert.Error(t, health.checkReadiness())
+	health.recordSync(nil)
+	assert.NoError(t, health.checkReadiness())
+
+	// Failed syncs after the first successful sync do not affect readiness.
+	health.recordSync(fmt.Errorf("injected sync error"))
+	assert.NoError(t, health.checkReadiness())
+
+	health.stoppedMergeLoop()
+	assert.NoError(t, health.checkReadiness())
+}
+
+// Test that liveness fails once no sync has succeeded for longer than the threshold, counting from the start of the
+// merge loop before the first successful sync.
+func TestHealthCheckerLiveness(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	health := newHealthChecker(time.Minute)
+	health.now = func() time.Time { return now }
+	assert.NoError(t, health.checkLiveness())
+
+	health.startedMergeLoop()
+	now = now.Add(59 * time.Second)
+	assert.NoError(t, health.checkLiveness())
+	now = now.Add(2 * time.Second)
+	assert.Error(t, health.checkLiveness())
+
+	health.recordSync(nil)
+	assert.NoError(t, health.checkLiveness())
+	now = now.Add(30 * time.Second)
+	health.recordSync(fmt.Errorf("injected sync error"))
+	assert.NoError(t, health.checkLiveness())
+	now = now.Add(31 * time.Second)
+	assert.Error(t, health.checkLiveness())
+
+	health.stoppedMergeLoop()
+	assert.NoError(t, health.checkLiveness())
+}
+
+// Test that the health checker can be used without being set up, which is the case when the probes are disabled.
+func TestHealthCheckerDisabled(t *testing.T) {
+	t.Parallel()
+
+	var health *healthChecker
+	health.startedMergeLoop()
+	health.setInformersSynced(func() bool { return true })
+	health.recordSync(nil)
+	health.stoppedMergeLoop()
+}
+
+func TestProbeHandler(t *testing.T) {
+	t.Parallel()
+
+	recorder := httptest.NewRecorder()
+	probeHandler(func() error { return nil })(recorder, httptest.NewRequest(http.MethodGet, livenessPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	probeHandler(func() error { return fmt.Errorf("not synced") })(recorder, httptest.NewRequest(http.MethodGet, readinessPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "not synced\n", recorder.Body.String())
+}
+
+func TestValidateLivenessIntervalMultiplier(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateLivenessIntervalMultiplier(1))
+	err := validateLivenessIntervalMultiplier(0)
+	_, isInvalid := errors.Unwrap(err).(InvalidLivenessIntervalMultiplierErr)
+	assert.True(t, isInvalid)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+)
+
+// How long to wait for in-flight requests to complete when shutting down an HTTP server.
+const httpServerShutdownTimeout = 5 * time.Second
+
+// startHTTPServer starts serving the given handler on the given port in the background, until the given context is
+// done. This returns an error if the port can not be bound, so that a misconfiguration is reported on startup. The name
+// is used to identify the server in the logs.
+func (authMerger *AwsAuthMerger) startHTTPServer(ctx context.Context, name string, port int, handler http.Handler) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	server := &http.Server{Handler: handler}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			authMerger.logger.Errorf("Error while serving %s: %s", name, err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpServerShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			authMerger.logger.Warnf("Error while shutting down the %s server: %s", name, err)
+		}
+	}()
+	return nil
+}
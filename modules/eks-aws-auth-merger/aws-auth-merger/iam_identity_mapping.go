@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// The kind of the IAMIdentityMapping custom resource, which holds a single role or user mapping. The
+	// CustomResourceDefinition is in crds/iamidentitymappings.yaml.
+	iamIdentityMappingSourceKind = "IAMIdentityMapping"
+
+	// The current status of the IAMIdentityMapping is carried on the ConfigMap that it is converted to with this
+	// annotation, so that the status is only patched when it changes. This annotation is never written to the cluster.
+	iamIdentityMappingStatusAnnotationKey = "gruntwork.io/aws-auth-merger-iam-identity-mapping-status"
+
+	// The reasons that are reported in the status of an IAMIdentityMapping.
+	iamIdentityMappingReasonMerged   = "Merged"
+	iamIdentityMappingReasonRejected = "Rejected"
+	iamIdentityMappingReasonDropped  = "Dropped"
+)
+
+// iamIdentityMappingResource is the API resource of the IAMIdentityMapping custom resource.
+var iamIdentityMappingResource = schema.GroupVersionResource{
+	Group:    "aws-auth-merger.gruntwork.io",
+	Version:  "v1alpha1",
+	Resource: "iamidentitymappings",
+}
+
+// IAMIdentityMapping is a custom resource that maps a single IAM role or user to a Kubernetes user and groups. This is
+// an alternative to the aws-auth ConfigMaps that is validated against a schema by the Kubernetes API, and reports
+// whether the mapping is live in the main aws-auth ConfigMap in its status.
+type IAMIdentityMapping struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IAMIdentityMappingSpec   `json:"spec"`
+	Status IAMIdentityMappingStatus `json:"status,omitempty"`
+}
+
+// IAMIdentityMappingSpec is the mapping of an IAMIdentityMapping. Exactly one of RoleArn and UserArn is set, which is
+// enforced by the schema of the CustomResourceDefinition.
+type IAMIdentityMappingSpec struct {
+	RoleArn  string   `json:"roleArn,omitempty"`
+	UserArn  string   `json:"userArn,omitempty"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// IAMIdentityMappingStatus reports whether the mapping of an IAMIdentityMapping is live in the main aws-auth ConfigMap,
+// and if not, why.
+type IAMIdentityMappingStatus struct {
+	// Whether the mapping is in the main aws-auth ConfigMap.
+	Live bool `json:"live"`
+	// One of Merged, Rejected, or Dropped.
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	// The generation of the IAMIdentityMapping that the status was recorded for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// isIAMIdentityMappingSource returns true if the given source ConfigMap was converted from an IAMIdentityMapping.
+func isIAMIdentityMappingSource(configmap corev1.ConfigMap) bool {
+	return configmap.Kind == iamIdentityMappingSourceKind
+}
+
+// decodeIAMIdentityMapping converts the given object, as returned by the dynamic client and informer, to an
+// IAMIdentityMapping.
+func decodeIAMIdentityMapping(obj runtime.Object) (IAMIdentityMapping, error) {
+	var mapping IAMIdentityMapping
+	object, isUnstructured := obj.(*unstructured.Unstructured)
+	if !isUnstructured {
+		return mapping, errors.WithStackTrace(UnexpectedIAMIdentityMappingObjectErr{obj})
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.UnstructuredContent(), &mapping); err != nil {
+		return mapping, errors.WithStackTrace(err)
+	}
+	return mapping, nil
+}
+
+// configMapFromIAMIdentityMapping converts the given IAMIdentityMapping to a ConfigMap with the same metadata, and the
+// mapping as the only entry in mapRoles or mapUsers, so that it can be merged along with the source ConfigMaps. The
+// Kind of the returned ConfigMap is set to IAMIdentityMapping, which is how the rest of the merger tells it apart.
+func configMapFromIAMIdentityMapping(mapping IAMIdentityMapping) (corev1.ConfigMap, error) {
+	data := map[string]string{}
+	if mapping.Spec.RoleArn != "" {
+		mapRoles, err := yaml.Marshal([]RoleMapping{{RoleArn: mapping.Spec.RoleArn, Username: mapping.Spec.Username, Groups: mapping.Spec.Groups}})
+		if err != nil {
+			return corev1.ConfigMap{}, errors.WithStackTrace(err)
+		}
+		data[mapRolesKey] = string(mapRoles)
+	}
+	if mapping.Spec.UserArn != "" {
+		mapUsers, err := yaml.Marshal([]UserMapping{{UserArn: mapping.Spec.UserArn, Username: mapping.Spec.Username, Groups: mapping.Spec.Groups}})
+		if err != nil {
+			return corev1.ConfigMap{}, errors.WithStackTrace(err)
+		}
+		data[mapUsersKey] = string(mapUsers)
+	}
+
+	status, err := json.Marshal(mapping.Status)
+	if err != nil {
+		return corev1.ConfigMap{}, errors.WithStackTrace(err)
+	}
+	objectMeta := *mapping.ObjectMeta.DeepCopy()
+	if objectMeta.Annotations == nil {
+		objectMeta.Annotations = map[string]string{}
+	}
+	objectMeta.Annotations[iamIdentityMappingStatusAnnotationKey] = string(status)
+
+	configmap := corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{Kind: iamIdentityMappingSourceKind, APIVersion: iamIdentityMappingResource.GroupVersion().String()},
+		ObjectMeta: objectMeta,
+		Data:       data,
+	}
+	return configmap, nil
+}
+
+// configMapsFromIAMIdentityMappings decodes and converts the given IAMIdentityMapping objects to ConfigMaps.
+func configMapsFromIAMIdentityMappings(objects []runtime.Object) ([]corev1.ConfigMap, error) {
+	configmaps := make([]corev1.ConfigMap, 0, len(objects))
+	for _, obj := range objects {
+		mapping, err := decodeIAMIdentityMapping(obj)
+		if err != nil {
+			return nil, err
+		}
+		configmap, err := configMapFromIAMIdentityMapping(mapping)
+		if err != nil {
+			return nil, err
+		}
+		configmaps = append(configmaps, configmap)
+	}
+	return configmaps, nil
+}
+
+// listAwsAuthIAMIdentityMappingsFromAPI will list the IAMIdentityMappings that should be merged together from the
+// Kubernetes API, converted to ConfigMaps. Returns nothing if IAMIdentityMappings are not watched.
+func (authMerger *AwsAuthMerger) listAwsAuthIAMIdentityMappingsFromAPI() ([]corev1.ConfigMap, error) {
+	if !authMerger.watchIAMIdentityMappings {
+		return []corev1.ConfigMap{}, nil
+	}
+	if !authMerger.watchesMultipleNamespaces() {
+		return authMerger.listIAMIdentityMappingsFromAPI(authMerger.namespace)
+	}
+	namespaces, err := authMerger.listSourceNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	allMappings, err := authMerger.listIAMIdentityMappingsFromAPI(metav1.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+	return filterConfigMapsByNamespace(allMappings, namespaces), nil
+}
+
+// listIAMIdentityMappingsFromAPI will list the IAMIdentityMappings with the configured label selector in the given
+// Namespace from the Kubernetes API, paginating through the results, and convert them to ConfigMaps. Pass
+// metav1.NamespaceAll to list across all Namespaces.
+func (authMerger *AwsAuthMerger) listIAMIdentityMappingsFromAPI(namespace string) ([]corev1.ConfigMap, error) {
+	options := metav1.ListOptions{LabelSelector: authMerger.labelSelector}
+	allMappings := []corev1.ConfigMap{}
+	for {
+		mappingList, err := authMerger.dynamicClient.Resource(iamIdentityMappingResource).Namespace(namespace).List(authMerger.ctx, options)
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		objects := make([]runtime.Object, 0, len(mappingList.Items))
+		for i := range mappingList.Items {
+			objects = append(objects, &mappingList.Items[i])
+		}
+		mappings, err := configMapsFromIAMIdentityMappings(objects)
+		if err != nil {
+			return nil, err
+		}
+		allMappings = append(allMappings, mappings...)
+		if mappingList.GetContinue() == "" {
+			return allMappings, nil
+		}
+		options.Continue = mappingList.GetContinue()
+	}
+}
+
+// listAwsAuthIAMIdentityMappingsFromCache will list the IAMIdentityMappings that should be merged together from the
+// informer cache of the watcher, converted to ConfigMaps. Like listAwsAuthConfigMapsFromCache, the IAMIdentityMappings
+// are filtered by the source Namespaces when watching multiple Namespaces, and sorted by Namespace and name. Returns
+// nothing if IAMIdentityMappings are not watched.
+func (authMerger *AwsAuthMerger) listAwsAuthIAMIdentityMappingsFromCache() ([]corev1.ConfigMap, error) {
+	if !authMerger.watchIAMIdentityMappings || authMerger.iamIdentityMappingLister == nil {
+		return []corev1.ConfigMap{}, nil
+	}
+	var cached []runtime.Object
+	var err error
+	if authMerger.watchesMultipleNamespaces() {
+		cached, err = authMerger.iamIdentityMappingLister.List(labels.Everything())
+	} else {
+		cached, err = authMerger.iamIdentityMappingLister.ByNamespace(authMerger.namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	// The conversion copies what it needs, so the shared objects in the cache are not modified.
+	mappings, err := configMapsFromIAMIdentityMappings(cached)
+	if err != nil {
+		return nil, err
+	}
+	if authMerger.watchesMultipleNamespaces() {
+		namespaces, err := authMerger.listSourceNamespaces()
+		if err != nil {
+			return nil, err
+		}
+		mappings = filterConfigMapsByNamespace(mappings, namespaces)
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		if mappings[i].Namespace != mappings[j].Namespace {
+			return mappings[i].Namespace < mappings[j].Namespace
+		}
+		return mappings[i].Name < mappings[j].Name
+	})
+	return mappings, nil
+}
+
+// updateIAMIdentityMappingStatus records whether the mapping of the given IAMIdentityMapping, converted to a ConfigMap
+// and identified by the given source name in the merge result, made it into the main aws-auth ConfigMap in the status
+// subresource. The status is only patched when it changes, so that we don't trigger a new sync from the watcher every
+// time we sync.
+func (authMerger *AwsAuthMerger) updateIAMIdentityMappingStatus(configmap corev1.ConfigMap, name string, result mergeResult) error {
+	desired := iamIdentityMappingStatus(configmap, name, result, authMerger.conflictStrategy)
+	var current IAMIdentityMappingStatus
+	if err := json.Unmarshal([]byte(configmap.Annotations[iamIdentityMappingStatusAnnotationKey]), &current); err == nil && current == desired {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"status": desired})
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	_, err = authMerger.dynamicClient.Resource(iamIdentityMappingResource).Namespace(configmap.Namespace).Patch(
+		authMerger.ctx,
+		configmap.Name,
+		types.MergePatchType,
+		patch,
+		metav1.PatchOptions{},
+		"status",
+	)
+	return errors.WithStackTrace(err)
+}
+
+// iamIdentityMappingStatus returns the status of the given IAMIdentityMapping, converted to a ConfigMap and identified
+// by the given source name, for the given merge result with the given conflict strategy.
+func iamIdentityMappingStatus(configmap corev1.ConfigMap, name string, result mergeResult, strategy conflictStrategy) IAMIdentityMappingStatus {
+	status := IAMIdentityMappingStatus{ObservedGeneration: configmap.Generation}
+	if rejectErr, isRejected := result.rejected[name]; isRejected {
+		status.Reason = iamIdentityMappingReasonRejected
+		status.Message = rejectErr.Error()
+		return status
+	}
+	if accepted := result.accepted[name]; accepted.roles+accepted.users+accepted.accounts > 0 {
+		status.Live = true
+		status.Reason = iamIdentityMappingReasonMerged
+		status.Message = fmt.Sprintf("The mapping is included in ConfigMap %s in Namespace %s.", mainAwsAuthConfigMapName, mainAwsAuthConfigMapNamespace)
+		return status
+	}
+	if len(configmap.Data) == 0 {
+		status.Reason = iamIdentityMappingReasonRejected
+		status.Message = "The mapping has neither a roleArn nor a userArn."
+		return status
+	}
+	status.Reason = iamIdentityMappingReasonDropped
+	status.Message = fmt.Sprintf("The mapping conflicts with a mapping from another source, and was dropped by the %s conflict strategy.", strategy)
+	return status
+}
+
+// Custom errors
+
+type UnexpectedIAMIdentityMappingObjectErr struct {
+	obj runtime.Object
+}
+
+func (err UnexpectedIAMIdentityMappingObjectErr) Error() string {
+	return fmt.Sprintf("Expected an unstructured IAMIdentityMapping object, but got %T", err.obj)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newIAMIdentityMapping returns an IAMIdentityMapping in the aws-auth-merger Namespace for the given role mapping, as
+// an unstructured object like the ones returned by the dynamic client.
+func newIAMIdentityMapping(t *testing.T, name string, mapping RoleMapping) *unstructured.Unstructured {
+	identityMapping := IAMIdentityMapping{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       iamIdentityMappingSourceKind,
+			APIVersion: iamIdentityMappingResource.GroupVersion().String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "aws-auth-merger", Generation: 1},
+		Spec:       IAMIdentityMappingSpec{RoleArn: mapping.RoleArn, Username: mapping.Username, Groups: mapping.Groups},
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&identityMapping)
+	require.NoError(t, err)
+	return &unstructured.Unstructured{Object: content}
+}
+
+// newTestDynamicClient returns a fake dynamic client that knows how to list IAMIdentityMappings.
+func newTestDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{iamIdentityMappingResource: "IAMIdentityMappingList"},
+		objects...,
+	)
+}
+
+func TestConfigMapFromIAMIdentityMapping(t *testing.T) {
+	t.Parallel()
+
+	mapping, err := decodeIAMIdentityMapping(newIAMIdentityMapping(t, "admin", adminRoleMapping))
+	require.NoError(t, err)
+	configmap, err := configMapFromIAMIdentityMapping(mapping)
+	require.NoError(t, err)
+	assert.True(t, isIAMIdentityMappingSource(configmap))
+	assert.Equal(t, "iamidentitymapping/admin", mergeOptions{}.sourceName(configmap))
+	assert.NotContains(t, configmap.Data, mapUsersKey)
+
+	parsed, err := parseAwsAuthConfigMap(configmap, conflictStrategyFail)
+	require.NoError(t, err)
+	assert.Equal(t, []RoleMapping{adminRoleMapping}, parsed.mapRoles)
+	assert.Equal(t, iamIdentityMappingSourceKind, parsed.source.Kind)
+
+	_, err = decodeIAMIdentityMapping(&corev1.ConfigMap{})
+	assert.Error(t, err)
+}
+
+func TestIAMIdentityMappingStatus(t *testing.T) {
+	t.Parallel()
+
+	mapping, err := decodeIAMIdentityMapping(newIAMIdentityMapping(t, "admin", adminRoleMapping))
+	require.NoError(t, err)
+	configmap, err := configMapFromIAMIdentityMapping(mapping)
+	require.NoError(t, err)
+	empty := configmap.DeepCopy()
+	empty.Data = map[string]string{}
+
+	testCases := []struct {
+		name           string
+		configmap      corev1.ConfigMap
+		result         mergeResult
+		expectedLive   bool
+		expectedReason string
+	}{
+		{
+			"merged",
+			configmap,
+			mergeResult{accepted: map[string]acceptedMappingCounts{"iamidentitymapping/admin": {roles: 1}}},
+			true,
+			iamIdentityMappingReasonMerged,
+		},
+		{
+			"rejected",
+			configmap,
+			mergeResult{rejected: map[string]error{"iamidentitymapping/admin": fmt.Errorf("invalid")}},
+			false,
+			iamIdentityMappingReasonRejected,
+		},
+		{
+			"dropped",
+			configmap,
+			mergeResult{accepted: map[string]acceptedMappingCounts{"iamidentitymapping/admin": {}}},
+			false,
+			iamIdentityMappingReasonDropped,
+		},
+		{
+			"empty",
+			*empty,
+			mergeResult{accepted: map[string]acceptedMappingCounts{"iamidentitymapping/admin": {}}},
+			false,
+			iamIdentityMappingReasonRejected,
+		},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			status := iamIdentityMappingStatus(tc.configmap, "iamidentitymapping/admin", tc.result, conflictStrategySkipLater)
+			assert.Equal(t, tc.expectedLive, status.Live)
+			assert.Equal(t, tc.expectedReason, status.Reason)
+			assert.Equal(t, int64(1), status.ObservedGeneration)
+		})
+	}
+}
+
+// Test that syncing merges the IAMIdentityMappings along with the ConfigMaps, reports whether each mapping is live in
+// the status, and only patches the status when it changes.
+func TestSyncIAMIdentityMappings(t *testing.T) {
+	t.Parallel()
+
+	configmap := newAwsAuthConfigMap(t, "team", "", []RoleMapping{deployRoleMapping}, []UserMapping{})
+	configmap.Namespace = "aws-auth-merger"
+	dynamicClient := newTestDynamicClient(
+		newIAMIdentityMapping(t, "admin", adminRoleMapping),
+		newIAMIdentityMapping(t, "admin-duplicate", adminRoleMapping),
+	)
+	clientset := fake.NewSimpleClientset(&configmap)
+	authMerger := AwsAuthMerger{
+		namespace:                "aws-auth-merger",
+		watchIAMIdentityMappings: true,
+		conflictStrategy:         conflictStrategySkipLater,
+		clientset:                clientset,
+		dynamicClient:            dynamicClient,
+		ctx:                      context.Background(),
+		logger:                   logrus.New(),
+	}
+	require.NoError(t, authMerger.syncAwsAuthConfigMaps())
+
+	main, err := clientset.CoreV1().ConfigMaps(mainAwsAuthConfigMapNamespace).Get(context.Background(), mainAwsAuthConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, `["iamidentitymapping/admin","iamidentitymapping/admin-duplicate","team"]`, main.Annotations[sourcesAnnotationKey])
+
+	expectedStatuses := map[string]struct {
+		live   bool
+		reason string
+	}{
+		"admin":           {true, iamIdentityMappingReasonMerged},
+		"admin-duplicate": {false, iamIdentityMappingReasonDropped},
+	}
+	for name, expected := range expectedStatuses {
+		obj, err := dynamicClient.Resource(iamIdentityMappingResource).Namespace("aws-auth-merger").Get(context.Background(), name, metav1.GetOptions{})
+		require.NoError(t, err)
+		mapping, err := decodeIAMIdentityMapping(obj)
+		require.NoError(t, err)
+		assert.Equal(t, expected.live, mapping.Status.Live, name)
+		assert.Equal(t, expected.reason, mapping.Status.Reason, name)
+		assert.NotContains(t, mapping.Annotations, iamIdentityMappingStatusAnnotationKey, name)
+	}
+
+	// Syncing again without changes does not patch the status.
+	dynamicClient.ClearActions()
+	require.NoError(t, authMerger.syncAwsAuthConfigMaps())
+	for _, action := range dynamicClient.Actions() {
+		assert.False(t, action.Matches("patch", "iamidentitymappings"), "unexpected patch of IAMIdentityMapping %v", action)
+	}
+}
+
+// Test that the watcher enqueues a sync when a source IAMIdentityMapping changes.
+func TestConfigMapWatchControllerIAMIdentityMappings(t *testing.T) {
+	t.Parallel()
+
+	dynamicClient := newTestDynamicClient()
+	queue := newSyncQueue(time.Millisecond, 10*time.Millisecond)
+	defer queue.ShutDown()
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	controller := NewConfigMapWatchController(logrus.New(), fake.NewSimpleClientset(), []string{"aws-auth-merger"}, "", "", false, dynamicClient, queue)
+	require.NoError(t, controller.Run(stopChan))
+	require.NotNil(t, controller.IAMIdentityMappingLister())
+
+	_, err := dynamicClient.Resource(iamIdentityMappingResource).Namespace("aws-auth-merger").Create(
+		context.Background(),
+		newIAMIdentityMapping(t, "admin", adminRoleMapping),
+		metav1.CreateOptions{},
+	)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return queue.Len() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	cached, err := controller.IAMIdentityMappingLister().ByNamespace("aws-auth-merger").List(labels.Everything())
+	require.NoError(t, err)
+	assert.Len(t, cached, 1)
+}
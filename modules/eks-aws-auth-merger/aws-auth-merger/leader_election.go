@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// leaderElectionConfig configures the Lease based leader election, which makes sure that only one replica of the merger
+// syncs the main aws-auth ConfigMap at a time. The other replicas stand by, and take over when the leader goes away.
+type leaderElectionConfig struct {
+	// Whether to run leader election. When false, the merger syncs as soon as it starts.
+	enabled bool
+	// Name and Namespace of the Lease object that is used as the lock.
+	leaseName      string
+	leaseNamespace string
+	// How long standby replicas wait before taking over a Lease that has not been renewed.
+	leaseDuration time.Duration
+	// How long the leader keeps trying to renew the Lease before giving up leadership.
+	renewDeadline time.Duration
+	// How long to wait between attempts to acquire or renew the Lease.
+	retryPeriod time.Duration
+}
+
+// runWithLeaderElection blocks until this replica acquires the leader Lease, and then runs the merge loop until it
+// returns, leadership is lost, or the given context is done. Losing leadership is returned as an error so that the
+// process restarts as a standby. When the context is done because the process is shutting down, this waits for the
+// merge loop to finish the in-flight sync, and only then releases the Lease and returns without an error.
+func (authMerger *AwsAuthMerger) runWithLeaderElection(ctx context.Context) error {
+	identity, err := os.Hostname()
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      authMerger.leaderElection.leaseName,
+			Namespace: authMerger.leaderElection.leaseNamespace,
+		},
+		Client:     authMerger.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	// The elector releases the Lease as soon as its context is cancelled, without waiting for the code guarded by the
+	// Lease. So the election context is not derived from the given context: it is only cancelled once the merge loop
+	// has returned, or when shutting down before this replica became the leader.
+	electionCtx, cancelElection := context.WithCancel(context.Background())
+	defer cancelElection()
+	startedLeading := make(chan context.Context, 1)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   authMerger.leaderElection.leaseDuration,
+		RenewDeadline:   authMerger.leaderElection.renewDeadline,
+		RetryPeriod:     authMerger.leaderElection.retryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				startedLeading <- leaderCtx
+			},
+			OnStoppedLeading: func() {
+				authMerger.logger.Infof("Stopped leading as %s.", identity)
+				authMerger.metrics.setLeader(false)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					authMerger.logger.Infof("Replica %s is the leader. Standing by.", leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+
+	authMerger.logger.Infof("Waiting to acquire Lease %s in Namespace %s as %s.", lock.LeaseMeta.Name, lock.LeaseMeta.Namespace, identity)
+	electionDone := make(chan struct{})
+	go func() {
+		defer close(electionDone)
+		elector.Run(electionCtx)
+	}()
+
+	var leaderCtx context.Context
+	select {
+	case leaderCtx = <-startedLeading:
+	case <-ctx.Done():
+	}
+	if ctx.Err() != nil {
+		// Shutting down before the merge loop started, so there is nothing to wait for before releasing the Lease.
+		cancelElection()
+		<-electionDone
+		return nil
+	}
+
+	authMerger.metrics.setLeader(true)
+	authMerger.logger.Infof("Acquired Lease %s in Namespace %s as %s. Starting to sync aws-auth ConfigMaps.", lock.LeaseMeta.Name, lock.LeaseMeta.Namespace, identity)
+	// The merge loop stops when either the process is shutting down, or the elector stops renewing the Lease.
+	mergeLoopCtx, cancelMergeLoop := context.WithCancel(leaderCtx)
+	defer cancelMergeLoop()
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancelMergeLoop()
+		case <-mergeLoopCtx.Done():
+		}
+	}()
+	mergeLoopErr := authMerger.mergeLoop(mergeLoopCtx)
+	lostLeadership := leaderCtx.Err() != nil
+
+	// Release the Lease now that the in-flight sync is done, so that a standby replica can take over right away.
+	cancelElection()
+	<-electionDone
+	if mergeLoopErr != nil {
+		return mergeLoopErr
+	}
+	if lostLeadership && ctx.Err() == nil {
+		return errors.WithStackTrace(LostLeadershipErr(identity))
+	}
+	return nil
+}
+
+// Custom errors
+
+type LostLeadershipErr string
+
+func (err LostLeadershipErr) Error() string {
+	return fmt.Sprintf("Replica %s lost the leader Lease. Exiting so that it restarts as a standby.", string(err))
+}
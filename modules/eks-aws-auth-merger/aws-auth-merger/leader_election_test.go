@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var testLeaderElectionConfig = leaderElectionConfig{
+	enabled:        true,
+	leaseName:      "aws-auth-merger",
+	leaseNamespace: "aws-auth-merger",
+	leaseDuration:  15 * time.Second,
+	renewDeadline:  10 * time.Second,
+	retryPeriod:    100 * time.Millisecond,
+}
+
+// Test that the leader runs the merge loop, retrying failed syncs, and releases the Lease when it is shut down so that a
+// standby replica can take over right away.
+func TestRunWithLeaderElectionLeader(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	createAttempts := 0
+	clientset.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		createAttempts++
+		return true, nil, fmt.Errorf("injected create error")
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	authMerger := AwsAuthMerger{
+		namespace:          "aws-auth-merger",
+		refreshInterval:    time.Minute,
+		syncRetryBaseDelay: 10 * time.Millisecond,
+		syncRetryMaxDelay:  100 * time.Millisecond,
+		syncMaxRetries:     3,
+		leaderElection:     testLeaderElectionConfig,
+		clientset:          clientset,
+		ctx:                ctx,
+		logger:             logrus.New(),
+	}
+
+	require.NoError(t, authMerger.runWithLeaderElection(ctx))
+	// The initial sync and each of the retries try to create the main aws-auth ConfigMap.
+	assert.Equal(t, 4, createAttempts)
+
+	lease, err := clientset.CoordinationV1().Leases("aws-auth-merger").Get(context.Background(), "aws-auth-merger", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, lease.Spec.HolderIdentity)
+	assert.Equal(t, "", *lease.Spec.HolderIdentity)
+}
+
+// Test that a leader that is shut down in the middle of a sync only releases the Lease after the in-flight sync is done,
+// so that a standby replica never syncs at the same time.
+func TestRunWithLeaderElectionShutdownDuringSync(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	clientset.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		cancel()
+		return false, nil, nil
+	})
+	authMerger := AwsAuthMerger{
+		namespace:          "aws-auth-merger",
+		refreshInterval:    time.Minute,
+		syncRetryBaseDelay: 10 * time.Millisecond,
+		syncRetryMaxDelay:  100 * time.Millisecond,
+		syncMaxRetries:     3,
+		leaderElection:     testLeaderElectionConfig,
+		clientset:          clientset,
+		ctx:                context.Background(),
+		logger:             logrus.New(),
+	}
+
+	require.NoError(t, authMerger.runWithLeaderElection(ctx))
+
+	// The Lease is released with an update that clears the holder, which must come after the last ConfigMap request.
+	lastConfigMapAction := -1
+	lastLeaseUpdate := -1
+	for i, action := range clientset.Actions() {
+		switch {
+		case action.GetResource().Resource == "configmaps":
+			lastConfigMapAction = i
+		case action.GetResource().Resource == "leases" && action.GetVerb() == "update":
+			lastLeaseUpdate = i
+		}
+	}
+	require.NotEqual(t, -1, lastConfigMapAction)
+	assert.Greater(t, lastLeaseUpdate, lastConfigMapAction)
+
+	lease, err := clientset.CoordinationV1().Leases("aws-auth-merger").Get(context.Background(), "aws-auth-merger", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, lease.Spec.HolderIdentity)
+	assert.Equal(t, "", *lease.Spec.HolderIdentity)
+}
+
+// Test that a standby replica does not run the merge loop while another replica holds the Lease.
+func TestRunWithLeaderElectionStandby(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	otherLeader := "other-" + hostname
+	leaseDurationSeconds := int32(testLeaderElectionConfig.leaseDuration.Seconds())
+	now := metav1.NewMicroTime(time.Now())
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "aws-auth-merger", Namespace: "aws-auth-merger"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &otherLeader,
+			LeaseDurationSeconds: &leaseDurationSeconds,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	}
+	clientset := fake.NewSimpleClientset(lease)
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	authMerger := AwsAuthMerger{
+		namespace:      "aws-auth-merger",
+		leaderElection: testLeaderElectionConfig,
+		clientset:      clientset,
+		ctx:            ctx,
+		logger:         logrus.New(),
+	}
+
+	require.NoError(t, authMerger.runWithLeaderElection(ctx))
+	for _, action := range clientset.Actions() {
+		assert.NotEqual(t, "configmaps", action.GetResource().Resource)
+	}
+}
+
+// Test that the leader exits with an error when it fails to renew the Lease, so that it restarts as a standby.
+func TestRunWithLeaderElectionLostLeadership(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("injected update error")
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	authMerger := AwsAuthMerger{
+		namespace:          "aws-auth-merger",
+		refreshInterval:    time.Minute,
+		syncRetryBaseDelay: 10 * time.Millisecond,
+		syncRetryMaxDelay:  100 * time.Millisecond,
+		syncMaxRetries:     3,
+		leaderElection: leaderElectionConfig{
+			enabled:        true,
+			leaseName:      "aws-auth-merger",
+			leaseNamespace: "aws-auth-merger",
+			leaseDuration:  1 * time.Second,
+			renewDeadline:  500 * time.Millisecond,
+			retryPeriod:    100 * time.Millisecond,
+		},
+		clientset: clientset,
+		ctx:       ctx,
+		logger:    logrus.New(),
+	}
+
+	err := authMerger.runWithLeaderElection(ctx)
+	require.Error(t, err)
+	_, isLostLeadership := errors.Unwrap(err).(LostLeadershipErr)
+	assert.True(t, isLostLeadership)
+	assert.NoError(t, ctx.Err())
+}
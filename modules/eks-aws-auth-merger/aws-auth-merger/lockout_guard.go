@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// This annotation can be set on the main aws-auth ConfigMap to allow the merger to write a merged ConfigMap that
+	// trips the lockout guards. The value must be the content hash of the merged ConfigMap that was refused, as reported
+	// in the error, so that the override only applies to that specific write. The annotation is cleared when the merged
+	// ConfigMap is written.
+	allowUnsafeWriteAnnotationKey = "gruntwork.io/aws-auth-merger-allow-unsafe-write"
+)
+
+// lockoutGuards configures the safety checks that are evaluated before writing the merged ConfigMap, to protect
+// against writes that would lock the workers or the administrators out of the cluster. For example, this can happen
+// when a source ConfigMap is deleted during a Terraform replace.
+type lockoutGuards struct {
+	// The minimum number of worker node role mappings (mappings with the system:nodes group) that must be in the merged
+	// ConfigMap. Disabled if 0.
+	minNodeRoleMappings int
+	// Role ARNs, user ARNs, or account IDs that must be in the merged ConfigMap.
+	mustKeepArns []string
+	// The maximum fraction of the mappings managed by the merger that can be removed in a single sync, between 0 and 1.
+	// Set to 1 to allow removing all the mappings.
+	maxRemovalFraction float64
+}
+
+// validateMaxRemovalFraction returns an error if the given fraction is not between 0 and 1.
+func validateMaxRemovalFraction(fraction float64) error {
+	if fraction < 0 || fraction > 1 {
+		return errors.WithStackTrace(InvalidMaxRemovalFractionErr(fraction))
+	}
+	return nil
+}
+
+// checkLockoutGuards evaluates the configured lockout guards against the desired merged ConfigMap, and returns an error
+// describing every guard that was tripped. The removal fraction is computed against the mappings that the merger
+// manages in the existing main aws-auth ConfigMap, or against all its mappings if it was not written by the merger.
+// The guards are skipped if the main aws-auth ConfigMap does not exist yet, since there is no access to lose, or if it
+// has the allow unsafe write annotation for the desired content.
+func (authMerger *AwsAuthMerger) checkLockoutGuards(existing *corev1.ConfigMap, desired corev1.ConfigMap) error {
+	if existing == nil {
+		return nil
+	}
+	desiredHash := desired.Annotations[contentHashAnnotationKey]
+	if allowedHash, hasAllowed := existing.Annotations[allowUnsafeWriteAnnotationKey]; hasAllowed && allowedHash == desiredHash {
+		authMerger.logger.Warnf("ConfigMap %s in Namespace %s has the %s annotation set for the merged content. Skipping the lockout guards.", existing.Name, existing.Namespace, allowUnsafeWriteAnnotationKey)
+		return nil
+	}
+
+	desiredMappings, err := parseAwsAuthConfigMap(desired, conflictStrategyFail)
+	if err != nil {
+		return err
+	}
+	desiredKeys := mappingKeySet(desiredMappings)
+	violations := []string{}
+
+	if authMerger.lockoutGuards.minNodeRoleMappings > 0 {
+		numNodeRoleMappings := 0
+		for _, roleMapping := range desiredMappings.mapRoles {
+			if isNodeRoleMapping(roleMapping) {
+				numNodeRoleMappings++
+			}
+		}
+		if numNodeRoleMappings < authMerger.lockoutGuards.minNodeRoleMappings {
+			violations = append(violations, fmt.Sprintf("only %d worker node role mappings would remain, but at least %d are required", numNodeRoleMappings, authMerger.lockoutGuards.minNodeRoleMappings))
+		}
+	}
+
+	for _, arn := range authMerger.lockoutGuards.mustKeepArns {
+		if !desiredKeys[mappingKey{roleMappingType, arn}] && !desiredKeys[mappingKey{userMappingType, arn}] && !desiredKeys[mappingKey{accountMappingType, arn}] {
+			violations = append(violations, fmt.Sprintf("%s would be removed, but it is configured to be kept", arn))
+		}
+	}
+
+	previousKeys, err := getPreviousMappingKeys(*existing)
+	if err != nil {
+		// The removals can't be computed if the existing ConfigMap can not be parsed, but that also means that EKS can't
+		// read it, so there is no access to lose.
+		authMerger.logger.Warnf("Could not determine the mappings in ConfigMap %s in Namespace %s, so the removal guard is skipped: %s", existing.Name, existing.Namespace, err)
+	} else if len(previousKeys) > 0 {
+		numRemoved := 0
+		for key := range previousKeys {
+			if !desiredKeys[key] {
+				numRemoved++
+			}
+		}
+		removalFraction := float64(numRemoved) / float64(len(previousKeys))
+		if removalFraction > authMerger.lockoutGuards.maxRemovalFraction {
+			violations = append(violations, fmt.Sprintf("%d of %d mappings would be removed, which is more than the maximum fraction of %g", numRemoved, len(previousKeys), authMerger.lockoutGuards.maxRemovalFraction))
+		}
+	}
+
+	if len(violations) > 0 {
+		return errors.WithStackTrace(LockoutGuardErr{existing.Name, existing.Namespace, desiredHash, violations})
+	}
+	return nil
+}
+
+// getPreviousMappingKeys returns the keys of the mappings that the merger manages in the existing main aws-auth
+// ConfigMap, as recorded in the managed mappings annotation. If the annotation is not available, this returns the keys
+// of all the mappings in the ConfigMap.
+func getPreviousMappingKeys(existing corev1.ConfigMap) (map[mappingKey]bool, error) {
+	if managedRaw, hasManaged := existing.Annotations[managedMappingsAnnotationKey]; hasManaged && isManagedByMerger(&existing) {
+		return decodeManagedMappings(managedRaw)
+	}
+	existingMappings, err := parseAwsAuthConfigMap(existing, conflictStrategyFail)
+	if err != nil {
+		return nil, err
+	}
+	return mappingKeySet(existingMappings), nil
+}
+
+// Custom errors
+
+type LockoutGuardErr struct {
+	configMapName      string
+	configMapNamespace string
+	contentHash        string
+	violations         []string
+}
+
+func (err LockoutGuardErr) Error() string {
+	return fmt.Sprintf(
+		"Refusing to write ConfigMap %s in Namespace %s, as it could lock users or workers out of the cluster: %s. If this is intended, set the annotation %s=%s on the ConfigMap to allow this write.",
+		err.configMapName,
+		err.configMapNamespace,
+		strings.Join(err.violations, "; "),
+		allowUnsafeWriteAnnotationKey,
+		err.contentHash,
+	)
+}
+
+type InvalidMaxRemovalFractionErr float64
+
+func (err InvalidMaxRemovalFractionErr) Error() string {
+	return fmt.Sprintf("Invalid max removal fraction %g. Must be between 0 and 1.", float64(err))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	adminRoleMapping  = RoleMapping{RoleArn: "arn:aws:iam::123456789012:role/admin", Username: "admin", Groups: []string{"system:masters"}}
+	deployRoleMapping = RoleMapping{RoleArn: "arn:aws:iam::123456789012:role/deploy", Username: "deploy", Groups: []string{"deployers"}}
+	opsRoleMapping    = RoleMapping{RoleArn: "arn:aws:iam::123456789012:role/ops", Username: "ops", Groups: []string{"operators"}}
+)
+
+func TestCheckLockoutGuards(t *testing.T) {
+	t.Parallel()
+
+	allRoles := []RoleMapping{adminRoleMapping, deployRoleMapping, opsRoleMapping, eksNodeGroupRoleMapping}
+
+	testCases := []struct {
+		name         string
+		guards       lockoutGuards
+		desiredRoles []RoleMapping
+		expectErr    bool
+	}{
+		{"noGuards", lockoutGuards{maxRemovalFraction: 1}, []RoleMapping{}, false},
+		{"minNodeRoleMappingsMet", lockoutGuards{minNodeRoleMappings: 1, maxRemovalFraction: 1}, allRoles, false},
+		{"minNodeRoleMappingsTripped", lockoutGuards{minNodeRoleMappings: 1, maxRemovalFraction: 1}, []RoleMapping{adminRoleMapping}, true},
+		{"mustKeepArnsMet", lockoutGuards{mustKeepArns: []string{adminRoleMapping.RoleArn}, maxRemovalFraction: 1}, []RoleMapping{adminRoleMapping}, false},
+		{"mustKeepArnsTripped", lockoutGuards{mustKeepArns: []string{adminRoleMapping.RoleArn}, maxRemovalFraction: 1}, []RoleMapping{deployRoleMapping}, true},
+		{"maxRemovalFractionMet", lockoutGuards{maxRemovalFraction: 0.5}, []RoleMapping{adminRoleMapping, eksNodeGroupRoleMapping}, false},
+		{"maxRemovalFractionTripped", lockoutGuards{maxRemovalFraction: 0.5}, []RoleMapping{adminRoleMapping}, true},
+		{"additionsAreNotRemovals", lockoutGuards{maxRemovalFraction: 0}, append(allRoles, foreignRoleMapping), false},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			existing := mergeTestConfigMaps(t, allRoles)
+			desired := mergeTestConfigMaps(t, tc.desiredRoles)
+			authMerger := AwsAuthMerger{lockoutGuards: tc.guards, logger: logrus.New()}
+
+			err := authMerger.checkLockoutGuards(&existing, desired)
+			if tc.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// The guards never apply when the main aws-auth ConfigMap does not exist yet.
+			assert.NoError(t, authMerger.checkLockoutGuards(nil, desired))
+		})
+	}
+}
+
+// Test that syncAwsAuthConfigMaps refuses to write a merged ConfigMap that trips the lockout guards, and that the write
+// goes through once it is allowed with the annotation on the main aws-auth ConfigMap.
+func TestSyncAwsAuthConfigMapsLockoutGuardOverride(t *testing.T) {
+	t.Parallel()
+
+	existing := mergeTestConfigMaps(t, []RoleMapping{adminRoleMapping, eksNodeGroupRoleMapping})
+	source := newAwsAuthConfigMap(t, "source", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	source.Namespace = "aws-auth-merger"
+	clientset := fake.NewSimpleClientset(&existing, &source)
+	authMerger := AwsAuthMerger{
+		namespace:     "aws-auth-merger",
+		driftPolicy:   driftPolicyRevert,
+		lockoutGuards: lockoutGuards{minNodeRoleMappings: 1, maxRemovalFraction: 1},
+		clientset:     clientset,
+		ctx:           context.Background(),
+		logger:        logrus.New(),
+	}
+
+	err := authMerger.syncAwsAuthConfigMaps()
+	require.Error(t, err)
+	guardErr, isGuardErr := errors.Unwrap(err).(LockoutGuardErr)
+	require.True(t, isGuardErr)
+	updated, err := clientset.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "aws-auth", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, existing.Data, updated.Data)
+
+	// A stale override does not allow the write.
+	updated.Annotations[allowUnsafeWriteAnnotationKey] = "stale"
+	updated, err = clientset.CoreV1().ConfigMaps("kube-system").Update(context.Background(), updated, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Error(t, authMerger.syncAwsAuthConfigMaps())
+
+	updated.Annotations[allowUnsafeWriteAnnotationKey] = guardErr.contentHash
+	_, err = clientset.CoreV1().ConfigMaps("kube-system").Update(context.Background(), updated, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, authMerger.syncAwsAuthConfigMaps())
+
+	updated, err = clientset.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "aws-auth", metav1.GetOptions{})
+	require.NoError(t, err)
+	roles, err := getRoleMappingFromConfigMap(*updated)
+	require.NoError(t, err)
+	assert.Equal(t, []RoleMapping{adminRoleMapping}, roles)
+	assert.NotContains(t, updated.Annotations, allowUnsafeWriteAnnotationKey)
+}
+
+// Test that the lockout guards are evaluated after the EKS worker node entries are adopted, so that the worker node
+// role mappings that EKS added to the main aws-auth ConfigMap count towards the guards.
+func TestSyncAwsAuthConfigMapsLockoutGuardAfterAdoption(t *testing.T) {
+	t.Parallel()
+
+	existing := mergeTestConfigMaps(t, []RoleMapping{adminRoleMapping})
+	existing = setRoleMappingsOutOfBand(t, existing, []RoleMapping{adminRoleMapping, eksNodeGroupRoleMapping})
+	source := newAwsAuthConfigMap(t, "source", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	source.Namespace = "aws-auth-merger"
+	clientset := fake.NewSimpleClientset(&existing, &source)
+	authMerger := AwsAuthMerger{
+		namespace:            "aws-auth-merger",
+		driftPolicy:          driftPolicyRevert,
+		adoptEksNodeMappings: true,
+		lockoutGuards:        lockoutGuards{minNodeRoleMappings: 1, maxRemovalFraction: 0},
+		clientset:            clientset,
+		ctx:                  context.Background(),
+		logger:               logrus.New(),
+	}
+	require.NoError(t, authMerger.syncAwsAuthConfigMaps())
+
+	updated, err := clientset.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "aws-auth", metav1.GetOptions{})
+	require.NoError(t, err)
+	roles, err := getRoleMappingFromConfigMap(*updated)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []RoleMapping{adminRoleMapping, eksNodeGroupRoleMapping}, roles)
+	_, err = clientset.CoreV1().ConfigMaps("aws-auth-merger").Get(context.Background(), eksNodeConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestValidateMaxRemovalFraction(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateMaxRemovalFraction(0))
+	assert.NoError(t, validateMaxRemovalFraction(0.5))
+	assert.NoError(t, validateMaxRemovalFraction(1))
+	assert.Error(t, validateMaxRemovalFraction(-0.1))
+	assert.Error(t, validateMaxRemovalFraction(1.5))
+}
@@ -10,10 +10,47 @@ import (
 type mappingType string
 
 const (
-	roleMappingType mappingType = "Role"
-	userMappingType             = "User"
+	roleMappingType    mappingType = "Role"
+	userMappingType                = "User"
+	accountMappingType mappingType = "Account"
 )
 
+// conflictStrategy determines how the merger handles the same ARN showing up in more than one source ConfigMap.
+type conflictStrategy string
+
+const (
+	// Return an error on any conflict. This is the strictest option and the default.
+	conflictStrategyFail conflictStrategy = "fail"
+	// Keep the mapping from the source that was merged first, and drop the later ones.
+	conflictStrategySkipLater conflictStrategy = "skip-later"
+	// Keep the mapping from the source with the highest priority, as set by the priority annotation. Conflicts between
+	// sources with the same priority are treated as errors.
+	conflictStrategyPriority conflictStrategy = "priority"
+	// Combine the groups of the conflicting mappings if they map to the same username. Conflicts where the username
+	// differs are treated as errors.
+	conflictStrategyUnionGroups conflictStrategy = "union-groups"
+)
+
+// validConflictStrategies lists all the supported conflict strategies, in the order they should be displayed to the
+// user.
+var validConflictStrategies = []conflictStrategy{
+	conflictStrategyFail,
+	conflictStrategySkipLater,
+	conflictStrategyPriority,
+	conflictStrategyUnionGroups,
+}
+
+// parseConflictStrategy converts the given string to a conflictStrategy, returning an error if it is not one of the
+// supported strategies.
+func parseConflictStrategy(strategyRaw string) (conflictStrategy, error) {
+	for _, strategy := range validConflictStrategies {
+		if string(strategy) == strategyRaw {
+			return strategy, nil
+		}
+	}
+	return "", errors.WithStackTrace(InvalidConflictStrategyErr(strategyRaw))
+}
+
 type RoleMapping struct {
 	RoleArn  string   `yaml:"rolearn"`
 	Username string   `yaml:"username"`
@@ -26,55 +63,200 @@ type UserMapping struct {
 	Groups   []string `yaml:"groups"`
 }
 
-// mergeRoleMapping merges the two role mapping lists, using the RoleArn as a key to determine conflicts. This will
-// return an error if there is a conflict.
-func mergeRoleMappingLists(roleMappingA []RoleMapping, roleMappingB []RoleMapping) ([]RoleMapping, error) {
-	seen := map[string]bool{}
+// String returns a human readable description of the role mapping, for use in log and error messages.
+func (mapping RoleMapping) String() string {
+	return fmt.Sprintf("{rolearn: %s, username: %s, groups: [%s]}", mapping.RoleArn, mapping.Username, strings.Join(mapping.Groups, ", "))
+}
+
+// String returns a human readable description of the user mapping, for use in log and error messages.
+func (mapping UserMapping) String() string {
+	return fmt.Sprintf("{userarn: %s, username: %s, groups: [%s]}", mapping.UserArn, mapping.Username, strings.Join(mapping.Groups, ", "))
+}
+
+// AccountMapping is an AWS account ID whose IAM roles and users are all automatically mapped to a Kubernetes user of
+// the same ARN. This corresponds to an entry in the mapAccounts list.
+type AccountMapping string
+
+// mergeRoleMapping merges the two role mapping lists, using the RoleArn as a key to determine conflicts. Conflicts are
+// handled according to the given strategy: this will return an error if the strategy is fail, or if the conflict can
+// not be resolved. Conflicts that were resolved are returned so that they can be reported.
+//
+// Note that the priority strategy requires knowledge of the sources, so it can not be handled at this level. The
+// caller is expected to order the lists by priority and merge them using skip-later.
+func mergeRoleMappingLists(roleMappingA []RoleMapping, roleMappingB []RoleMapping, strategy conflictStrategy) ([]RoleMapping, []MappingConflictErr, error) {
+	seen := map[string]int{}
 	newRoleMapping := []RoleMapping{}
-	// Technically, we will want to handle conflicts in each individual list, but that adds complexity to the code. We
-	// choose not to handle that situation here by assuming that conflicts scoped within each individual ConfigMap is
-	// easier to detect by reading the source creating/managing it.
+	resolved := []MappingConflictErr{}
 	for _, roleMapping := range roleMappingA {
-		seen[roleMapping.RoleArn] = true
+		seen[roleMapping.RoleArn] = len(newRoleMapping)
 		newRoleMapping = append(newRoleMapping, roleMapping)
 	}
+	// We also track the entries of the second list as we add them, so that duplicates within that list are detected
+	// and handled the same way as conflicts across the lists.
 	for _, roleMapping := range roleMappingB {
-		if _, hasSeen := seen[roleMapping.RoleArn]; hasSeen {
-			return nil, errors.WithStackTrace(MappingConflictErr{roleMappingType, roleMapping.RoleArn})
+		idx, hasSeen := seen[roleMapping.RoleArn]
+		if !hasSeen {
+			seen[roleMapping.RoleArn] = len(newRoleMapping)
+			newRoleMapping = append(newRoleMapping, roleMapping)
+			continue
+		}
+
+		existing := newRoleMapping[idx]
+		conflict := MappingConflictErr{
+			mappingType: roleMappingType,
+			arn:         roleMapping.RoleArn,
+			strategy:    strategy,
+			existing:    existing.String(),
+			conflicting: roleMapping.String(),
+		}
+		switch {
+		case strategy == conflictStrategySkipLater:
+			resolved = append(resolved, conflict)
+		case strategy == conflictStrategyUnionGroups && existing.Username == roleMapping.Username:
+			existing.Groups = unionGroups(existing.Groups, roleMapping.Groups)
+			newRoleMapping[idx] = existing
+			resolved = append(resolved, conflict)
+		default:
+			return nil, nil, errors.WithStackTrace(conflict)
 		}
-		newRoleMapping = append(newRoleMapping, roleMapping)
 	}
-	return newRoleMapping, nil
+	return newRoleMapping, resolved, nil
 }
 
-// mergeUserMapping merges the two user mapping lists, using the UserArn as a key to determine conflicts. This will
-// return an error if there is a conflict.
-func mergeUserMappingLists(userMappingA []UserMapping, userMappingB []UserMapping) ([]UserMapping, error) {
-	seen := map[string]bool{}
+// mergeUserMapping merges the two user mapping lists, using the UserArn as a key to determine conflicts. Conflicts are
+// handled according to the given strategy: this will return an error if the strategy is fail, or if the conflict can
+// not be resolved. Conflicts that were resolved are returned so that they can be reported.
+//
+// Note that the priority strategy requires knowledge of the sources, so it can not be handled at this level. The
+// caller is expected to order the lists by priority and merge them using skip-later.
+func mergeUserMappingLists(userMappingA []UserMapping, userMappingB []UserMapping, strategy conflictStrategy) ([]UserMapping, []MappingConflictErr, error) {
+	seen := map[string]int{}
 	newUserMapping := []UserMapping{}
-	// Technically, we will want to handle conflicts in each individual list, but that adds complexity to the code. We
-	// choose not to handle that situation here by assuming that conflicts scoped within each individual ConfigMap is
-	// easier to detect by reading the source creating/managing it.
+	resolved := []MappingConflictErr{}
 	for _, userMapping := range userMappingA {
-		seen[userMapping.UserArn] = true
+		seen[userMapping.UserArn] = len(newUserMapping)
 		newUserMapping = append(newUserMapping, userMapping)
 	}
+	// We also track the entries of the second list as we add them, so that duplicates within that list are detected
+	// and handled the same way as conflicts across the lists.
 	for _, userMapping := range userMappingB {
-		if _, hasSeen := seen[userMapping.UserArn]; hasSeen {
-			return nil, errors.WithStackTrace(MappingConflictErr{userMappingType, userMapping.UserArn})
+		idx, hasSeen := seen[userMapping.UserArn]
+		if !hasSeen {
+			seen[userMapping.UserArn] = len(newUserMapping)
+			newUserMapping = append(newUserMapping, userMapping)
+			continue
 		}
-		newUserMapping = append(newUserMapping, userMapping)
+
+		existing := newUserMapping[idx]
+		conflict := MappingConflictErr{
+			mappingType: userMappingType,
+			arn:         userMapping.UserArn,
+			strategy:    strategy,
+			existing:    existing.String(),
+			conflicting: userMapping.String(),
+		}
+		switch {
+		case strategy == conflictStrategySkipLater:
+			resolved = append(resolved, conflict)
+		case strategy == conflictStrategyUnionGroups && existing.Username == userMapping.Username:
+			existing.Groups = unionGroups(existing.Groups, userMapping.Groups)
+			newUserMapping[idx] = existing
+			resolved = append(resolved, conflict)
+		default:
+			return nil, nil, errors.WithStackTrace(conflict)
+		}
+	}
+	return newUserMapping, resolved, nil
+}
+
+// mergeAccountMappingLists merges the two account mapping lists. Account mappings have no username or groups, so a
+// conflict is the same account ID showing up in both lists. Any strategy other than fail will resolve the conflict by
+// keeping a single entry for the account. Conflicts that were resolved are returned so that they can be reported.
+func mergeAccountMappingLists(accountMappingA []AccountMapping, accountMappingB []AccountMapping, strategy conflictStrategy) ([]AccountMapping, []MappingConflictErr, error) {
+	seen := map[AccountMapping]bool{}
+	newAccountMapping := []AccountMapping{}
+	resolved := []MappingConflictErr{}
+	for _, accountMapping := range accountMappingA {
+		seen[accountMapping] = true
+		newAccountMapping = append(newAccountMapping, accountMapping)
+	}
+	// We also track the entries of the second list as we add them, so that duplicates within that list are detected
+	// and handled the same way as conflicts across the lists.
+	for _, accountMapping := range accountMappingB {
+		if !seen[accountMapping] {
+			seen[accountMapping] = true
+			newAccountMapping = append(newAccountMapping, accountMapping)
+			continue
+		}
+
+		conflict := MappingConflictErr{mappingType: accountMappingType, arn: string(accountMapping), strategy: strategy}
+		if strategy == conflictStrategyFail {
+			return nil, nil, errors.WithStackTrace(conflict)
+		}
+		resolved = append(resolved, conflict)
+	}
+	return newAccountMapping, resolved, nil
+}
+
+// unionGroups returns the groups in groupsA followed by the groups in groupsB that are not already in groupsA. Note
+// that this always returns a new slice so that the inputs are not modified.
+func unionGroups(groupsA []string, groupsB []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, group := range append(append([]string{}, groupsA...), groupsB...) {
+		if seen[group] {
+			continue
+		}
+		seen[group] = true
+		out = append(out, group)
 	}
-	return newUserMapping, nil
+	return out
 }
 
 // Custom error messages
 
 type MappingConflictErr struct {
 	mappingType mappingType
-	arn         string
+	// The key of the conflicting mapping. This is the ARN for roles and users, and the account ID for accounts.
+	arn string
+	// The strategy that was used when the conflict was detected.
+	strategy conflictStrategy
+	// Human readable descriptions of the two conflicting entries. These are blank for account mappings, as the entries
+	// are identical.
+	existing    string
+	conflicting string
+	// The names of the ConfigMaps that the existing and conflicting entries came from. These are set by the caller that
+	// knows about the sources, and can be blank.
+	existingConfigMapName string
+	configMapName         string
 }
 
 func (err MappingConflictErr) Error() string {
-	return fmt.Sprintf("%v ARN %s is already in the %s mapping list.", err.mappingType, err.arn, strings.ToLower(string(err.mappingType)))
+	keyName := "ARN"
+	if err.mappingType == accountMappingType {
+		keyName = "ID"
+	}
+	msg := fmt.Sprintf("%v %s %s is already in the %s mapping list.", err.mappingType, keyName, err.arn, strings.ToLower(string(err.mappingType)))
+	switch {
+	case err.configMapName != "" && err.existingConfigMapName == err.configMapName:
+		msg = fmt.Sprintf("%s Found duplicate entries in ConfigMap %s.", msg, err.configMapName)
+	case err.configMapName != "" && err.existingConfigMapName != "":
+		msg = fmt.Sprintf("%s Found conflicting entries in ConfigMaps %s and %s.", msg, err.existingConfigMapName, err.configMapName)
+	case err.configMapName != "":
+		msg = fmt.Sprintf("%s Found conflicting entry in ConfigMap %s.", msg, err.configMapName)
+	}
+	if err.existing != "" && err.conflicting != "" {
+		msg = fmt.Sprintf("%s Existing entry: %s. Conflicting entry: %s.", msg, err.existing, err.conflicting)
+	}
+	return msg
+}
+
+type InvalidConflictStrategyErr string
+
+func (err InvalidConflictStrategyErr) Error() string {
+	validStrategies := []string{}
+	for _, strategy := range validConflictStrategies {
+		validStrategies = append(validStrategies, string(strategy))
+	}
+	return fmt.Sprintf("Invalid conflict strategy %s. Must be one of: %s.", string(err), strings.Join(validStrategies, ", "))
 }
@@ -72,6 +72,13 @@ func TestMergeRoleMapping(t *testing.T) {
 			nil,
 			true,
 		},
+		{
+			"hasConflictWithinList",
+			[]RoleMapping{sampleOne},
+			[]RoleMapping{sampleTwo, sampleThree, sampleTwo},
+			nil,
+			true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -82,7 +89,7 @@ func TestMergeRoleMapping(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			mapping, err := mergeRoleMappingLists(tc.mappingA, tc.mappingB)
+			mapping, _, err := mergeRoleMappingLists(tc.mappingA, tc.mappingB, conflictStrategyFail)
 			if tc.hasConflict {
 				assert.Error(t, err)
 				assert.Nil(t, mapping)
@@ -160,6 +167,221 @@ func TestMergeUserMapping(t *testing.T) {
 			nil,
 			true,
 		},
+		{
+			"hasConflictWithinList",
+			[]UserMapping{sampleOne},
+			[]UserMapping{sampleTwo, sampleThree, sampleTwo},
+			nil,
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable so that it doesn't change as the goroutine swaps contexts across the parallel sub
+		// tests.
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			mapping, _, err := mergeUserMappingLists(tc.mappingA, tc.mappingB, conflictStrategyFail)
+			if tc.hasConflict {
+				assert.Error(t, err)
+				assert.Nil(t, mapping)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, mapping)
+			}
+		})
+	}
+}
+
+func TestMergeRoleMappingConflictStrategies(t *testing.T) {
+	t.Parallel()
+
+	existing := RoleMapping{
+		RoleArn:  "hjkl",
+		Username: "Hjkl",
+		Groups:   []string{"system:masters", "system:node"},
+	}
+	sameUsername := RoleMapping{
+		RoleArn:  "hjkl",
+		Username: "Hjkl",
+		Groups:   []string{"system:node", "autodeploy"},
+	}
+	otherUsername := RoleMapping{
+		RoleArn:  "hjkl",
+		Username: "Other",
+		Groups:   []string{"autodeploy"},
+	}
+
+	testCases := []struct {
+		name        string
+		strategy    conflictStrategy
+		conflicting RoleMapping
+		expected    []RoleMapping
+		hasConflict bool
+	}{
+		{
+			"skipLater",
+			conflictStrategySkipLater,
+			otherUsername,
+			[]RoleMapping{existing},
+			false,
+		},
+		{
+			"unionGroupsSameUsername",
+			conflictStrategyUnionGroups,
+			sameUsername,
+			[]RoleMapping{{RoleArn: "hjkl", Username: "Hjkl", Groups: []string{"system:masters", "system:node", "autodeploy"}}},
+			false,
+		},
+		{
+			"unionGroupsOtherUsername",
+			conflictStrategyUnionGroups,
+			otherUsername,
+			nil,
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable so that it doesn't change as the goroutine swaps contexts across the parallel sub
+		// tests.
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			mapping, resolved, err := mergeRoleMappingLists([]RoleMapping{existing}, []RoleMapping{tc.conflicting}, tc.strategy)
+			if tc.hasConflict {
+				assert.Error(t, err)
+				assert.Nil(t, mapping)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, mapping)
+				assert.Len(t, resolved, 1)
+			}
+		})
+	}
+
+	// Make sure the input groups are not modified as a side effect of the union.
+	assert.Equal(t, []string{"system:masters", "system:node"}, existing.Groups)
+}
+
+func TestMergeUserMappingConflictStrategies(t *testing.T) {
+	t.Parallel()
+
+	existing := UserMapping{
+		UserArn:  "hjkl",
+		Username: "Hjkl",
+		Groups:   []string{"system:masters"},
+	}
+	sameUsername := UserMapping{
+		UserArn:  "hjkl",
+		Username: "Hjkl",
+		Groups:   []string{"autodeploy"},
+	}
+	otherUsername := UserMapping{
+		UserArn:  "hjkl",
+		Username: "Other",
+		Groups:   []string{"autodeploy"},
+	}
+
+	testCases := []struct {
+		name        string
+		strategy    conflictStrategy
+		conflicting UserMapping
+		expected    []UserMapping
+		hasConflict bool
+	}{
+		{
+			"skipLater",
+			conflictStrategySkipLater,
+			otherUsername,
+			[]UserMapping{existing},
+			false,
+		},
+		{
+			"unionGroupsSameUsername",
+			conflictStrategyUnionGroups,
+			sameUsername,
+			[]UserMapping{{UserArn: "hjkl", Username: "Hjkl", Groups: []string{"system:masters", "autodeploy"}}},
+			false,
+		},
+		{
+			"unionGroupsOtherUsername",
+			conflictStrategyUnionGroups,
+			otherUsername,
+			nil,
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable so that it doesn't change as the goroutine swaps contexts across the parallel sub
+		// tests.
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			mapping, resolved, err := mergeUserMappingLists([]UserMapping{existing}, []UserMapping{tc.conflicting}, tc.strategy)
+			if tc.hasConflict {
+				assert.Error(t, err)
+				assert.Nil(t, mapping)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, mapping)
+				assert.Len(t, resolved, 1)
+			}
+		})
+	}
+}
+
+func TestMergeAccountMapping(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		strategy    conflictStrategy
+		mappingA    []AccountMapping
+		mappingB    []AccountMapping
+		expected    []AccountMapping
+		hasConflict bool
+	}{
+		{
+			"mergeEmpty",
+			conflictStrategyFail,
+			[]AccountMapping{},
+			[]AccountMapping{},
+			[]AccountMapping{},
+			false,
+		},
+		{
+			"mergeBoth",
+			conflictStrategyFail,
+			[]AccountMapping{"111122223333"},
+			[]AccountMapping{"555555555555"},
+			[]AccountMapping{"111122223333", "555555555555"},
+			false,
+		},
+		{
+			"hasConflict",
+			conflictStrategyFail,
+			[]AccountMapping{"111122223333"},
+			[]AccountMapping{"111122223333"},
+			nil,
+			true,
+		},
+		{
+			"skipLaterConflict",
+			conflictStrategySkipLater,
+			[]AccountMapping{"111122223333"},
+			[]AccountMapping{"111122223333", "555555555555"},
+			[]AccountMapping{"111122223333", "555555555555"},
+			false,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -170,7 +392,7 @@ func TestMergeUserMapping(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			mapping, err := mergeUserMappingLists(tc.mappingA, tc.mappingB)
+			mapping, _, err := mergeAccountMappingLists(tc.mappingA, tc.mappingB, tc.strategy)
 			if tc.hasConflict {
 				assert.Error(t, err)
 				assert.Nil(t, mapping)
@@ -181,3 +403,16 @@ func TestMergeUserMapping(t *testing.T) {
 		})
 	}
 }
+
+func TestParseConflictStrategy(t *testing.T) {
+	t.Parallel()
+
+	for _, strategy := range validConflictStrategies {
+		parsed, err := parseConflictStrategy(string(strategy))
+		assert.NoError(t, err)
+		assert.Equal(t, strategy, parsed)
+	}
+
+	_, err := parseConflictStrategy("last-wins")
+	assert.Error(t, err)
+}
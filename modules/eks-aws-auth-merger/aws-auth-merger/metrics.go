@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	metricsNamespace = "aws_auth_merger"
+	metricsPath      = "/metrics"
+
+	// Values of the result label of the sync metrics.
+	syncResultSuccess = "success"
+	syncResultError   = "error"
+)
+
+// mergerMetrics holds the Prometheus metrics of the merger. All the methods are safe to call on a nil mergerMetrics,
+// in which case nothing is recorded, so that the merger can run without metrics.
+type mergerMetrics struct {
+	registry *prometheus.Registry
+
+	syncs                    *prometheus.CounterVec
+	syncDuration             *prometheus.HistogramVec
+	lastSuccessfulSync       prometheus.Gauge
+	sourceConfigMaps         prometheus.Gauge
+	rejectedSourceConfigMaps prometheus.Gauge
+	mappings                 *prometheus.GaugeVec
+	mappingConflicts         *prometheus.CounterVec
+	configMapUpserts         *prometheus.CounterVec
+	isLeader                 prometheus.Gauge
+	workqueue                *workqueueMetricsProvider
+}
+
+// newMergerMetrics creates the metrics of the merger, and registers them on a new registry along with the Go runtime
+// and process metrics.
+func newMergerMetrics() *mergerMetrics {
+	metrics := &mergerMetrics{
+		registry: prometheus.NewRegistry(),
+		syncs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "syncs_total",
+			Help:      "Number of syncs of the aws-auth ConfigMaps, by result.",
+		}, []string{"result"}),
+		syncDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "sync_duration_seconds",
+			Help:      "How long the syncs of the aws-auth ConfigMaps took, by result.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"result"}),
+		lastSuccessfulSync: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_successful_sync_timestamp_seconds",
+			Help:      "Unix timestamp of the last successful sync of the aws-auth ConfigMaps.",
+		}),
+		sourceConfigMaps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "source_configmaps",
+			Help:      "Number of source ConfigMaps found in the last sync.",
+		}),
+		rejectedSourceConfigMaps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "rejected_source_configmaps",
+			Help:      "Number of invalid source ConfigMaps that were excluded from the last merge.",
+		}),
+		mappings: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "mappings",
+			Help:      "Number of mappings in the merged aws-auth ConfigMap after the last successful sync, by type.",
+		}, []string{"type"}),
+		mappingConflicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "mapping_conflicts_total",
+			Help:      "Number of mapping conflicts detected across the source ConfigMaps, by the conflict strategy that handled them.",
+		}, []string{"strategy"}),
+		configMapUpserts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "configmap_upserts_total",
+			Help:      "Number of upserts of the main aws-auth ConfigMap, by the action that was taken.",
+		}, []string{"action"}),
+		isLeader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "is_leader",
+			Help:      "Whether this replica is syncing the aws-auth ConfigMaps (1) or standing by (0).",
+		}),
+		workqueue: newWorkqueueMetricsProvider(),
+	}
+	metrics.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		metrics.syncs,
+		metrics.syncDuration,
+		metrics.lastSuccessfulSync,
+		metrics.sourceConfigMaps,
+		metrics.rejectedSourceConfigMaps,
+		metrics.mappings,
+		metrics.mappingConflicts,
+		metrics.configMapUpserts,
+		metrics.isLeader,
+	)
+	metrics.workqueue.register(metrics.registry)
+	return metrics
+}
+
+// observeSync records the result and duration of a sync.
+func (metrics *mergerMetrics) observeSync(err error, duration time.Duration) {
+	if metrics == nil {
+		return
+	}
+	result := syncResultSuccess
+	if err != nil {
+		result = syncResultError
+	} else {
+		metrics.lastSuccessfulSync.SetToCurrentTime()
+	}
+	metrics.syncs.WithLabelValues(result).Inc()
+	metrics.syncDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// observeSources records the number of source ConfigMaps that were found, and how many of them were rejected.
+func (metrics *mergerMetrics) observeSources(found int, rejected int) {
+	if metrics == nil {
+		return
+	}
+	metrics.sourceConfigMaps.Set(float64(found))
+	metrics.rejectedSourceConfigMaps.Set(float64(rejected))
+}
+
+// observeConflict records a mapping conflict that was handled with the given strategy.
+func (metrics *mergerMetrics) observeConflict(strategy conflictStrategy) {
+	if metrics == nil {
+		return
+	}
+	metrics.mappingConflicts.WithLabelValues(string(strategy)).Inc()
+}
+
+// observeUpsert records the action that was taken to upsert the given main aws-auth ConfigMap, and the number of
+// mappings in it.
+func (metrics *mergerMetrics) observeUpsert(action upsertAction, configmap corev1.ConfigMap) {
+	if metrics == nil {
+		return
+	}
+	metrics.configMapUpserts.WithLabelValues(string(action)).Inc()
+	// The merged ConfigMap was already validated, so this can only fail if something is very wrong, in which case we
+	// keep the counts from the previous sync.
+	parsed, err := parseAwsAuthConfigMap(configmap, conflictStrategySkipLater)
+	if err != nil {
+		return
+	}
+	metrics.mappings.WithLabelValues("role").Set(float64(len(parsed.mapRoles)))
+	metrics.mappings.WithLabelValues("user").Set(float64(len(parsed.mapUsers)))
+	metrics.mappings.WithLabelValues("account").Set(float64(len(parsed.mapAccounts)))
+}
+
+// setLeader records whether this replica is syncing the aws-auth ConfigMaps.
+func (metrics *mergerMetrics) setLeader(isLeader bool) {
+	if metrics == nil {
+		return
+	}
+	if isLeader {
+		metrics.isLeader.Set(1)
+	} else {
+		metrics.isLeader.Set(0)
+	}
+}
+
+// startMetricsServer starts serving the metrics on the configured port in the background, until the given context is
+// done.
+func (authMerger *AwsAuthMerger) startMetricsServer(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.HandlerFor(authMerger.metrics.registry, promhttp.HandlerOpts{}))
+	if err := authMerger.startHTTPServer(ctx, "metrics", authMerger.metricsPort, mux); err != nil {
+		return err
+	}
+	authMerger.logger.Infof("Serving metrics on port %d at %s", authMerger.metricsPort, metricsPath)
+	return nil
+}
+
+// workqueueMetricsProvider records the metrics of the sync queue, such as how many syncs were enqueued and retried,
+// and how long they waited in the queue. The metrics are labeled with the name of the queue.
+type workqueueMetricsProvider struct {
+	depth                   *prometheus.GaugeVec
+	adds                    *prometheus.CounterVec
+	latency                 *prometheus.HistogramVec
+	workDuration            *prometheus.HistogramVec
+	unfinishedWork          *prometheus.GaugeVec
+	longestRunningProcessor *prometheus.GaugeVec
+	retries                 *prometheus.CounterVec
+}
+
+func newWorkqueueMetricsProvider() *workqueueMetricsProvider {
+	return &workqueueMetricsProvider{
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "workqueue",
+			Name:      "depth",
+			Help:      "Number of syncs waiting in the queue.",
+		}, []string{"name"}),
+		adds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "workqueue",
+			Name:      "adds_total",
+			Help:      "Number of syncs added to the queue. Events that come in while a sync is already waiting are collapsed into it and not counted.",
+		}, []string{"name"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "workqueue",
+			Name:      "queue_duration_seconds",
+			Help:      "How long syncs waited in the queue before being processed.",
+			Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 10),
+		}, []string{"name"}),
+		workDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "workqueue",
+			Name:      "work_duration_seconds",
+			Help:      "How long processing syncs from the queue took.",
+			Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 10),
+		}, []string{"name"}),
+		unfinishedWork: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "workqueue",
+			Name:      "unfinished_work_seconds",
+			Help:      "How long the syncs that are in progress have been running.",
+		}, []string{"name"}),
+		longestRunningProcessor: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "workqueue",
+			Name:      "longest_running_processor_seconds",
+			Help:      "How long the longest running sync that is in progress has been running.",
+		}, []string{"name"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "workqueue",
+			Name:      "retries_total",
+			Help:      "Number of failed syncs that were scheduled for a retry.",
+		}, []string{"name"}),
+	}
+}
+
+func (provider *workqueueMetricsProvider) register(registry *prometheus.Registry) {
+	registry.MustRegister(
+		provider.depth,
+		provider.adds,
+		provider.latency,
+		provider.workDuration,
+		provider.unfinishedWork,
+		provider.longestRunningProcessor,
+		provider.retries,
+	)
+}
+
+func (provider *workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return provider.depth.WithLabelValues(name)
+}
+
+func (provider *workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return provider.adds.WithLabelValues(name)
+}
+
+func (provider *workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return provider.latency.WithLabelValues(name)
+}
+
+func (provider *workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return provider.workDuration.WithLabelValues(name)
+}
+
+func (provider *workqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return provider.unfinishedWork.WithLabelValues(name)
+}
+
+func (provider *workqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return provider.longestRunningProcessor.WithLabelValues(name)
+}
+
+func (provider *workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return provider.retries.WithLabelValues(name)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test that a sync records the result, the sources, and the mappings in the metrics.
+func TestSyncMetrics(t *testing.T) {
+	t.Parallel()
+
+	source := newAwsAuthConfigMap(t, "source", "", []RoleMapping{adminRoleMapping, deployRoleMapping}, []UserMapping{})
+	source.Namespace = "aws-auth-merger"
+	clientset := fake.NewSimpleClientset(&source)
+	metrics := newMergerMetrics()
+	authMerger := AwsAuthMerger{
+		namespace: "aws-auth-merger",
+		clientset: clientset,
+		metrics:   metrics,
+		ctx:       context.Background(),
+		logger:    logrus.New(),
+	}
+
+	queue := newSyncQueue(time.Millisecond, 10*time.Millisecond)
+	defer queue.ShutDown()
+	queue.Add(syncQueueKey)
+	require.True(t, authMerger.processNextSync(context.Background(), queue))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.syncs.WithLabelValues(syncResultSuccess)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.syncs.WithLabelValues(syncResultError)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.sourceConfigMaps))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.rejectedSourceConfigMaps))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.mappings.WithLabelValues("role")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.mappings.WithLabelValues("user")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.configMapUpserts.WithLabelValues(string(upsertActionCreated))))
+	assert.Greater(t, testutil.ToFloat64(metrics.lastSuccessfulSync), float64(0))
+}
+
+// Test that conflicts are recorded, both when they fail the merge and when they are resolved by the strategy.
+func TestSyncMetricsConflicts(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		strategy       conflictStrategy
+		expectedResult string
+	}{
+		{conflictStrategyFail, syncResultError},
+		{conflictStrategySkipLater, syncResultSuccess},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(string(tc.strategy), func(t *testing.T) {
+			t.Parallel()
+
+			conflicting := adminRoleMapping
+			conflicting.Username = "other-admin"
+			first := newAwsAuthConfigMap(t, "first", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+			first.Namespace = "aws-auth-merger"
+			second := newAwsAuthConfigMap(t, "second", "", []RoleMapping{conflicting}, []UserMapping{})
+			second.Namespace = "aws-auth-merger"
+			metrics := newMergerMetrics()
+			authMerger := AwsAuthMerger{
+				namespace:        "aws-auth-merger",
+				conflictStrategy: tc.strategy,
+				clientset:        fake.NewSimpleClientset(&first, &second),
+				metrics:          metrics,
+				ctx:              context.Background(),
+				logger:           logrus.New(),
+			}
+
+			queue := newSyncQueue(time.Millisecond, 10*time.Millisecond)
+			defer queue.ShutDown()
+			queue.Add(syncQueueKey)
+			require.True(t, authMerger.processNextSync(context.Background(), queue))
+
+			assert.Equal(t, float64(1), testutil.ToFloat64(metrics.mappingConflicts.WithLabelValues(string(tc.strategy))))
+			assert.Equal(t, float64(1), testutil.ToFloat64(metrics.syncs.WithLabelValues(tc.expectedResult)))
+		})
+	}
+}
+
+// Test that the metrics server serves the metrics of the merger and the sync queue, and stops when the context is done.
+func TestStartMetricsServer(t *testing.T) {
+	t.Parallel()
+
+	port := getFreePort(t)
+	metrics := newMergerMetrics()
+	metrics.observeSync(nil, time.Second)
+	metrics.workqueue.NewRetriesMetric(commandName).Inc()
+	authMerger := AwsAuthMerger{metricsPort: port, metrics: metrics, logger: logrus.New()}
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, authMerger.startMetricsServer(ctx))
+
+	url := fmt.Sprintf("http://localhost:%d%s", port, metricsPath)
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `aws_auth_merger_syncs_total{result="success"} 1`)
+	assert.Contains(t, string(body), `aws_auth_merger_workqueue_retries_total{name="aws-auth-merger"} 1`)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, err := http.Get(url)
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// Test that the metrics methods can be called without metrics, which is the case when metrics are disabled.
+func TestMergerMetricsDisabled(t *testing.T) {
+	t.Parallel()
+
+	var metrics *mergerMetrics
+	metrics.observeSync(nil, time.Second)
+	metrics.observeSources(1, 0)
+	metrics.observeConflict(conflictStrategyFail)
+	metrics.observeUpsert(upsertActionCreated, newAwsAuthConfigMap(t, "aws-auth", "", []RoleMapping{}, []UserMapping{}))
+	metrics.setLeader(true)
+}
+
+// getFreePort returns a port that is free to listen on.
+func getFreePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+const (
+	// The exit codes of the merge subcommand, so that CI can tell invalid sources apart from conflicts between
+	// sources. Other errors exit with the default exit code of 1.
+	mergeExitCodeInvalidSource = 2
+	mergeExitCodeConflict      = 3
+
+	// The output of the merge subcommand is written to stdout when the output path is blank or set to this.
+	stdoutOutputPath = "-"
+)
+
+// renderAwsAuthConfigMap merges the ConfigMap manifests in the given input files and directories into the main
+// aws-auth ConfigMap, using the same merge logic as the merger running in the cluster. This does not talk to the
+// cluster, so the steps that depend on the existing aws-auth ConfigMap (adopting the EKS node mappings, the removal
+// grace period, the drift policy, and the lockout guards) are skipped.
+//
+// The source ConfigMaps are identified by their Namespace and name if the manifests span more than one Namespace, like
+// when the merger watches multiple Namespaces. The merged timestamp annotation is left out, so that rendering the same
+// sources always renders the same manifest.
+func renderAwsAuthConfigMap(logger *logrus.Logger, inputs []string, options mergeOptions) (corev1.ConfigMap, error) {
+	configmaps, err := readConfigMapManifests(inputs)
+	if err != nil {
+		return corev1.ConfigMap{}, err
+	}
+	options.qualifySourceNames = spansMultipleNamespaces(configmaps)
+	if err := checkDuplicateSources(configmaps, options); err != nil {
+		return corev1.ConfigMap{}, err
+	}
+	logger.Infof("Found %d ConfigMaps in %d input paths", len(configmaps), len(inputs))
+
+	result, err := mergeAwsAuthConfigMaps(configmaps, options)
+	if err != nil {
+		return corev1.ConfigMap{}, withMergeExitCode(err)
+	}
+	for _, conflict := range result.resolvedConflicts {
+		logger.Warnf("Resolved mapping conflict using strategy %s: %s", conflict.strategy, conflict)
+	}
+
+	merged := result.merged
+	merged.TypeMeta = metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"}
+	delete(merged.Annotations, mergedTimestampAnnotationKey)
+	return merged, nil
+}
+
+// readConfigMapManifests reads the ConfigMap manifests in the given input paths. Each input path is either a manifest
+// file, or a directory, in which case the .yaml, .yml, and .json files directly in the directory are read in the order
+// of their names. A manifest file can hold multiple ConfigMaps separated by ---.
+func readConfigMapManifests(inputs []string) ([]corev1.ConfigMap, error) {
+	paths := []string{}
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		if !info.IsDir() {
+			absPath, err := filepath.Abs(input)
+			if err != nil {
+				return nil, errors.WithStackTrace(err)
+			}
+			paths = append(paths, absPath)
+			continue
+		}
+		directoryPaths, err := listSourceFilePaths(input)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, directoryPaths...)
+	}
+
+	configmaps := []corev1.ConfigMap{}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		decoded, err := decodeConfigMapManifests(path, content)
+		if err != nil {
+			return nil, err
+		}
+		configmaps = append(configmaps, decoded...)
+	}
+	return configmaps, nil
+}
+
+// decodeConfigMapManifests decodes the ConfigMaps in the given content of the manifest file at the given path. Empty
+// documents are skipped, and any other kind of object is rejected, so that a typo in the kind does not silently drop
+// the mappings.
+func decodeConfigMapManifests(path string, content []byte) ([]corev1.ConfigMap, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(content), len(content))
+	configmaps := []corev1.ConfigMap{}
+	for {
+		var configmap corev1.ConfigMap
+		err := decoder.Decode(&configmap)
+		if err == io.EOF {
+			return configmaps, nil
+		}
+		if err != nil {
+			return nil, withMergeExitCode(errors.WithStackTrace(InvalidConfigMapManifestErr{path, err.Error()}))
+		}
+		if configmap.Kind == "" && configmap.APIVersion == "" && configmap.Name == "" {
+			continue
+		}
+		if configmap.Kind != "ConfigMap" || configmap.APIVersion != "v1" {
+			reason := fmt.Sprintf("expected a v1 ConfigMap, but found %s %s", configmap.APIVersion, configmap.Kind)
+			return nil, withMergeExitCode(errors.WithStackTrace(InvalidConfigMapManifestErr{path, reason}))
+		}
+		if configmap.Name == "" {
+			return nil, withMergeExitCode(errors.WithStackTrace(InvalidConfigMapManifestErr{path, "ConfigMap has no name"}))
+		}
+		// Clear the TypeMeta, so that the ConfigMap is treated the same as the ConfigMaps listed from the API.
+		configmap.TypeMeta = metav1.TypeMeta{}
+		configmaps = append(configmaps, configmap)
+	}
+}
+
+// spansMultipleNamespaces returns true if the given ConfigMaps are not all in the same Namespace.
+func spansMultipleNamespaces(configmaps []corev1.ConfigMap) bool {
+	for _, configmap := range configmaps {
+		if configmap.Namespace != configmaps[0].Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDuplicateSources returns an error if more than one of the given ConfigMaps has the same source name. In the
+// cluster, the names are unique, but the same ConfigMap can show up in more than one manifest file.
+func checkDuplicateSources(configmaps []corev1.ConfigMap, options mergeOptions) error {
+	seen := map[string]bool{}
+	for _, configmap := range configmaps {
+		name := options.sourceName(configmap)
+		if seen[name] {
+			return withMergeExitCode(errors.WithStackTrace(DuplicateSourceConfigMapErr(name)))
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// withMergeExitCode sets the exit code of the merge subcommand on the given error, based on whether the error is about
+// an invalid source or a conflict between sources. Other errors are returned as is.
+func withMergeExitCode(err error) error {
+	switch errors.Unwrap(err).(type) {
+	case InvalidMappingListErr, InvalidPriorityErr, InvalidConfigMapManifestErr, DuplicateSourceConfigMapErr:
+		return errors.WithStackTrace(errors.ErrorWithExitCode{Err: errors.Unwrap(err), ExitCode: mergeExitCodeInvalidSource})
+	case MappingConflictErr:
+		return errors.WithStackTrace(errors.ErrorWithExitCode{Err: errors.Unwrap(err), ExitCode: mergeExitCodeConflict})
+	}
+	return err
+}
+
+// encodeConfigMapManifest encodes the given ConfigMap as a YAML manifest that can be applied with kubectl. The
+// creation timestamp is left out, as it is always empty.
+func encodeConfigMapManifest(configmap corev1.ConfigMap) ([]byte, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&configmap)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	unstructured.RemoveNestedField(content, "metadata", "creationTimestamp")
+	manifest, err := k8syaml.Marshal(content)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	return manifest, nil
+}
+
+// writeConfigMapManifest writes the given ConfigMap as a YAML manifest to the given output path, or to stdout if the
+// output path is blank or -.
+func writeConfigMapManifest(configmap corev1.ConfigMap, output string) error {
+	manifest, err := encodeConfigMapManifest(configmap)
+	if err != nil {
+		return err
+	}
+	if output == "" || output == stdoutOutputPath {
+		_, err = os.Stdout.Write(manifest)
+		return errors.WithStackTrace(err)
+	}
+	return errors.WithStackTrace(ioutil.WriteFile(output, manifest, 0644))
+}
+
+// Custom errors
+
+type InvalidConfigMapManifestErr struct {
+	path   string
+	reason string
+}
+
+func (err InvalidConfigMapManifestErr) Error() string {
+	return fmt.Sprintf("Error parsing ConfigMap manifest %s: %s", err.path, err.reason)
+}
+
+type DuplicateSourceConfigMapErr string
+
+func (err DuplicateSourceConfigMapErr) Error() string {
+	return fmt.Sprintf("ConfigMap %s is defined more than once in the input manifests.", string(err))
+}
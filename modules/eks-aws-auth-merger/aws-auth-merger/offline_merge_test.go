@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+// writeConfigMapManifestFile writes the given ConfigMaps as a multi document manifest file in the given directory.
+func writeConfigMapManifestFile(t *testing.T, directory string, name string, configmaps ...corev1.ConfigMap) string {
+	content := []byte{}
+	for _, configmap := range configmaps {
+		configmap.TypeMeta = metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"}
+		manifest, err := k8syaml.Marshal(configmap)
+		require.NoError(t, err)
+		content = append(content, []byte("---\n")...)
+		content = append(content, manifest...)
+	}
+	path := filepath.Join(directory, name)
+	require.NoError(t, ioutil.WriteFile(path, content, 0644))
+	return path
+}
+
+// Test that rendering the manifests produces the same aws-auth ConfigMap as merging the ConfigMaps in the cluster.
+func TestRenderAwsAuthConfigMap(t *testing.T) {
+	t.Parallel()
+
+	admin := newAwsAuthConfigMap(t, "admin", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	admin.Namespace = "aws-auth-merger"
+	deploy := newAwsAuthConfigMap(t, "deploy", "", []RoleMapping{deployRoleMapping}, []UserMapping{})
+	deploy.Namespace = "aws-auth-merger"
+
+	directory := t.TempDir()
+	writeConfigMapManifestFile(t, directory, "admin.yaml", admin)
+	deployPath := writeConfigMapManifestFile(t, t.TempDir(), "deploy.yaml", deploy)
+	writeConfigMapManifestFile(t, directory, "README.md")
+
+	rendered, err := renderAwsAuthConfigMap(logrus.New(), []string{directory, deployPath}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	require.NoError(t, err)
+	expected, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{admin, deploy}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	require.NoError(t, err)
+
+	assert.Equal(t, expected.merged.Data, rendered.Data)
+	assert.Equal(t, "ConfigMap", rendered.Kind)
+	assert.Equal(t, mainAwsAuthConfigMapName, rendered.Name)
+	assert.Equal(t, mainAwsAuthConfigMapNamespace, rendered.Namespace)
+	assert.Equal(t, `["admin","deploy"]`, rendered.Annotations[sourcesAnnotationKey])
+	assert.NotContains(t, rendered.Annotations, mergedTimestampAnnotationKey)
+
+	manifest, err := encodeConfigMapManifest(rendered)
+	require.NoError(t, err)
+	assert.NotContains(t, string(manifest), "creationTimestamp")
+	decoded, err := decodeConfigMapManifests("aws-auth.yaml", manifest)
+	require.NoError(t, err)
+	require.Len(t, decoded, 1)
+	assert.Equal(t, rendered.Data, decoded[0].Data)
+}
+
+// Test that the source names are qualified with the Namespace when the manifests span multiple Namespaces, like when
+// the merger watches multiple Namespaces.
+func TestRenderAwsAuthConfigMapMultipleNamespaces(t *testing.T) {
+	t.Parallel()
+
+	admin := newAwsAuthConfigMap(t, "team", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	admin.Namespace = "aws-auth-merger"
+	deploy := newAwsAuthConfigMap(t, "team", "", []RoleMapping{deployRoleMapping}, []UserMapping{})
+	deploy.Namespace = "team"
+
+	directory := t.TempDir()
+	writeConfigMapManifestFile(t, directory, "teams.yaml", admin, deploy)
+
+	rendered, err := renderAwsAuthConfigMap(logrus.New(), []string{directory}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	require.NoError(t, err)
+	assert.Equal(t, `["aws-auth-merger/team","team/team"]`, rendered.Annotations[sourcesAnnotationKey])
+}
+
+// Test that invalid manifests and conflicts fail the render with the exit code for each kind of error.
+func TestRenderAwsAuthConfigMapErrors(t *testing.T) {
+	t.Parallel()
+
+	admin := newAwsAuthConfigMap(t, "admin", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	conflicting := newAwsAuthConfigMap(t, "conflicting", "", []RoleMapping{{RoleArn: adminRoleMapping.RoleArn, Username: "other"}}, []UserMapping{})
+	invalid := newAwsAuthConfigMap(t, "invalid", "", []RoleMapping{}, []UserMapping{})
+	invalid.Data[mapRolesKey] = "not a list"
+
+	testCases := []struct {
+		name             string
+		manifest         string
+		configmaps       []corev1.ConfigMap
+		expectedExitCode int
+	}{
+		{"conflict", "", []corev1.ConfigMap{admin, conflicting}, mergeExitCodeConflict},
+		{"invalidMappings", "", []corev1.ConfigMap{admin, invalid}, mergeExitCodeInvalidSource},
+		{"duplicate", "", []corev1.ConfigMap{admin, admin}, mergeExitCodeInvalidSource},
+		{"notConfigMap", "apiVersion: v1\nkind: Secret\nmetadata:\n  name: admin\n", nil, mergeExitCodeInvalidSource},
+		{"noName", "apiVersion: v1\nkind: ConfigMap\ndata: {}\n", nil, mergeExitCodeInvalidSource},
+		{"invalidYaml", "apiVersion: v1\nkind: [ConfigMap\n", nil, mergeExitCodeInvalidSource},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			directory := t.TempDir()
+			if tc.manifest != "" {
+				require.NoError(t, ioutil.WriteFile(filepath.Join(directory, "source.yaml"), []byte(tc.manifest), 0644))
+			} else {
+				writeConfigMapManifestFile(t, directory, "source.yaml", tc.configmaps...)
+			}
+
+			_, err := renderAwsAuthConfigMap(logrus.New(), []string{directory}, mergeOptions{conflictStrategy: conflictStrategyFail})
+			require.Error(t, err)
+			exitErr, hasExitCode := errors.Unwrap(err).(errors.ErrorWithExitCode)
+			require.True(t, hasExitCode, "error without exit code: %s", err)
+			assert.Equal(t, tc.expectedExitCode, exitErr.ExitCode)
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// The name of the ConfigMap in the kube-system Namespace where the merger records which source ConfigMap each of the
+	// mappings in the main aws-auth ConfigMap came from. This is kept in a separate ConfigMap instead of an annotation on
+	// the main aws-auth ConfigMap, as annotations are limited to 256KiB in total, which is not enough for large clusters.
+	provenanceConfigMapName = "aws-auth-provenance"
+
+	// The kinds that are recorded in the provenance for the mappings in the main aws-auth ConfigMap that were not merged
+	// from any of the sources: the mappings that were added outside of the merger and adopted or kept by the drift
+	// policy, and the mappings that were removed from all the sources but are kept for the removal grace period.
+	driftSourceKind          = "Drift"
+	pendingRemovalSourceKind = "PendingRemoval"
+)
+
+// mappingSource identifies the version of the source ConfigMap or Secret that a merged mapping came from.
+type mappingSource struct {
+	Kind            string `json:"kind"`
+	Namespace       string `json:"namespace"`
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// recordMappingProvenance records the given ConfigMap as a source of the mapping if it made it into the merged
+// ConfigMap. When the mapping was already seen in an earlier ConfigMap, the later one is dropped by the conflict
+// strategy, except with the union-groups strategy, where both are combined.
+func recordMappingProvenance(provenance map[mappingKey][]mappingSource, key mappingKey, parsed parsedAwsAuthConfigMap, strategy conflictStrategy) {
+	sources, hasSeen := provenance[key]
+	if !hasSeen {
+		provenance[key] = []mappingSource{parsed.source}
+		return
+	}
+	if strategy != conflictStrategyUnionGroups {
+		return
+	}
+	for _, source := range sources {
+		if source == parsed.source {
+			return
+		}
+	}
+	provenance[key] = append(sources, parsed.source)
+}
+
+// withUnmergedProvenance returns the given provenance of the merged mappings, with an entry added for each of the
+// mappings in the given main aws-auth ConfigMap, as it was written, that were not merged from any of the sources. The
+// mappings that are pending removal are recorded with the PendingRemoval kind. The mappings that were added outside of
+// the merger are recorded with the Drift kind, along with the source ConfigMap they were adopted into, which they are
+// merged from on the next sync, or the main aws-auth ConfigMap if they are kept without being managed by the merger.
+func (authMerger *AwsAuthMerger) withUnmergedProvenance(provenance map[mappingKey][]mappingSource, written corev1.ConfigMap) map[mappingKey][]mappingSource {
+	writtenMappings, err := parseAwsAuthConfigMap(written, conflictStrategyFail)
+	if err != nil {
+		authMerger.logger.Warnf("Could not parse ConfigMap %s in Namespace %s, so the provenance of the mappings that were not merged from any source is not recorded: %s", written.Name, written.Namespace, err)
+		return provenance
+	}
+	managed := map[mappingKey]bool{}
+	if managedRaw, hasManaged := written.Annotations[managedMappingsAnnotationKey]; hasManaged {
+		if managed, err = decodeManagedMappings(managedRaw); err != nil {
+			authMerger.logger.Warnf("Could not parse the %s annotation on ConfigMap %s in Namespace %s: %s", managedMappingsAnnotationKey, written.Name, written.Namespace, err)
+		}
+	}
+	pendingSince := map[mappingKey]time.Time{}
+	if pendingRaw, hasPending := written.Annotations[pendingRemovalAnnotationKey]; hasPending {
+		if pendingSince, err = decodePendingRemovals(pendingRaw); err != nil {
+			authMerger.logger.Warnf("Could not parse the %s annotation on ConfigMap %s in Namespace %s: %s", pendingRemovalAnnotationKey, written.Name, written.Namespace, err)
+		}
+	}
+
+	withUnmerged := map[mappingKey][]mappingSource{}
+	for key, sources := range provenance {
+		withUnmerged[key] = sources
+	}
+	// addUnmerged records the source of the mapping with the given key if it was not merged from any of the sources.
+	// adoptedInto is the name of the source ConfigMap that the mapping was adopted into, if it is managed.
+	addUnmerged := func(key mappingKey, adoptedInto string) {
+		if _, isMerged := withUnmerged[key]; isMerged {
+			return
+		}
+		source := mappingSource{Kind: driftSourceKind, Namespace: written.Namespace, Name: written.Name}
+		if _, isPending := pendingSince[key]; isPending {
+			source.Kind = pendingRemovalSourceKind
+		} else if managed[key] {
+			source.Namespace = authMerger.namespace
+			source.Name = adoptedInto
+		}
+		withUnmerged[key] = []mappingSource{source}
+	}
+	for _, roleMapping := range writtenMappings.mapRoles {
+		adoptedInto := adoptedConfigMapName
+		if authMerger.adoptEksNodeMappings && isEksNodeRoleMapping(roleMapping) {
+			adoptedInto = eksNodeConfigMapName
+		}
+		addUnmerged(mappingKey{roleMappingType, roleMapping.RoleArn}, adoptedInto)
+	}
+	for _, userMapping := range writtenMappings.mapUsers {
+		addUnmerged(mappingKey{userMappingType, userMapping.UserArn}, adoptedConfigMapName)
+	}
+	for _, accountMapping := range writtenMappings.mapAccounts {
+		addUnmerged(mappingKey{accountMappingType, string(accountMapping)}, adoptedConfigMapName)
+	}
+	return withUnmerged
+}
+
+// newProvenanceConfigMap returns the provenance ConfigMap for the given provenance of the merged mappings. The data
+// is keyed by the aws-auth data key, and each value is a JSON object mapping the ARN or account ID to the list of
+// source ConfigMaps it came from, so that it can be queried with kubectl and jq.
+func newProvenanceConfigMap(provenance map[mappingKey][]mappingSource) (corev1.ConfigMap, error) {
+	byDataKey := map[string]map[string][]mappingSource{
+		mapRolesKey:    {},
+		mapUsersKey:    {},
+		mapAccountsKey: {},
+	}
+	for key, sources := range provenance {
+		byDataKey[mappingTypeDataKeys[key.mappingType]][key.key] = sources
+	}
+
+	data := map[string]string{}
+	for dataKey, sourcesByKey := range byDataKey {
+		// json.Marshal sorts the keys of the map, so that the data is deterministic.
+		sourcesJson, err := json.Marshal(sourcesByKey)
+		if err != nil {
+			return corev1.ConfigMap{}, errors.WithStackTrace(err)
+		}
+		data[dataKey] = string(sourcesJson)
+	}
+
+	configmap := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      provenanceConfigMapName,
+			Namespace: mainAwsAuthConfigMapNamespace,
+			Labels: map[string]string{
+				managedByLabelKey: managedByLabelValue,
+			},
+		},
+		Data: data,
+	}
+	return configmap, nil
+}
+
+// decodeProvenanceConfigMap decodes the given provenance ConfigMap into the source ConfigMaps of each of the merged
+// mappings.
+func decodeProvenanceConfigMap(configmap corev1.ConfigMap) (map[mappingKey][]mappingSource, error) {
+	provenance := map[mappingKey][]mappingSource{}
+	for mappingType, dataKey := range mappingTypeDataKeys {
+		sourcesRaw, hasSources := configmap.Data[dataKey]
+		if !hasSources {
+			continue
+		}
+		var sourcesByKey map[string][]mappingSource
+		if err := json.Unmarshal([]byte(sourcesRaw), &sourcesByKey); err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		for key, sources := range sourcesByKey {
+			provenance[mappingKey{mappingType, key}] = sources
+		}
+	}
+	return provenance, nil
+}
+
+// upsertProvenanceConfigMap creates or updates the provenance ConfigMap in the kube-system Namespace with the given
+// provenance of the merged mappings. The update is skipped if the data is already up to date, as determined by
+// isProvenanceUpToDate. Like upsertConfigMap, this retries when the ConfigMap is modified concurrently.
+func (authMerger *AwsAuthMerger) upsertProvenanceConfigMap(provenance map[mappingKey][]mappingSource) error {
+	desired, err := newProvenanceConfigMap(provenance)
+	if err != nil {
+		return err
+	}
+	configmaps := authMerger.clientset.CoreV1().ConfigMaps(desired.Namespace)
+	err = retry.OnError(upsertBackoff, isRetriableUpsertErr, func() error {
+		existing, err := configmaps.Get(authMerger.ctx, desired.Name, metav1.GetOptions{})
+		if err != nil && k8serrors.IsNotFound(err) {
+			_, err := configmaps.Create(authMerger.ctx, &desired, metav1.CreateOptions{})
+			return err
+		} else if err != nil {
+			return err
+		}
+		if isManagedByMerger(existing) && isProvenanceUpToDate(*existing, provenance) {
+			return nil
+		}
+		updated := desired.DeepCopy()
+		updated.ResourceVersion = existing.ResourceVersion
+		_, err = configmaps.Update(authMerger.ctx, updated, metav1.UpdateOptions{})
+		return err
+	})
+	return errors.WithStackTrace(err)
+}
+
+// isProvenanceUpToDate returns true if the given existing provenance ConfigMap records the same sources for the same
+// mappings as the given provenance. The resource versions of the sources are ignored: the merger patches the status
+// annotations on the sources right after recording the provenance, so comparing them would rewrite the provenance on
+// the sync that the patch triggers, only to record a new version of the same content.
+func isProvenanceUpToDate(existing corev1.ConfigMap, provenance map[mappingKey][]mappingSource) bool {
+	existingProvenance, err := decodeProvenanceConfigMap(existing)
+	if err != nil {
+		return false
+	}
+	existingWithoutVersions, err := newProvenanceConfigMap(withoutResourceVersions(existingProvenance))
+	if err != nil {
+		return false
+	}
+	desiredWithoutVersions, err := newProvenanceConfigMap(withoutResourceVersions(provenance))
+	if err != nil {
+		return false
+	}
+	return hashConfigMapData(existingWithoutVersions.Data) == hashConfigMapData(desiredWithoutVersions.Data)
+}
+
+// withoutResourceVersions returns a copy of the given provenance with the resource versions of the sources cleared.
+func withoutResourceVersions(provenance map[mappingKey][]mappingSource) map[mappingKey][]mappingSource {
+	cleared := map[mappingKey][]mappingSource{}
+	for key, sources := range provenance {
+		clearedSources := make([]mappingSource, len(sources))
+		for i, source := range sources {
+			source.ResourceVersion = ""
+			clearedSources[i] = source
+		}
+		cleared[key] = clearedSources
+	}
+	return cleared
+}
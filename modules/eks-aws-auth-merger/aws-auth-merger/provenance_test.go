@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test that the provenance of each merged mapping is the source ConfigMap it was taken from, or all of the source
+// ConfigMaps that were combined with the union-groups strategy.
+func TestMergeAwsAuthConfigMapsProvenance(t *testing.T) {
+	t.Parallel()
+
+	sameUsername := adminRoleMapping
+	sameUsername.Groups = []string{"viewers"}
+	teamA := newAwsAuthConfigMap(t, "team-a", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	teamA.Namespace = "aws-auth-merger"
+	teamA.ResourceVersion = "1"
+	teamB := newAwsAuthConfigMap(t, "team-b", "", []RoleMapping{sameUsername, deployRoleMapping}, []UserMapping{})
+	teamB.Namespace = "aws-auth-merger"
+	teamB.ResourceVersion = "2"
+	teamASource := mappingSource{Kind: configMapSourceKind, Namespace: "aws-auth-merger", Name: "team-a", ResourceVersion: "1"}
+	teamBSource := mappingSource{Kind: configMapSourceKind, Namespace: "aws-auth-merger", Name: "team-b", ResourceVersion: "2"}
+
+	testCases := []struct {
+		name               string
+		strategy           conflictStrategy
+		expectedProvenance map[mappingKey][]mappingSource
+	}{
+		{
+			"skipLater",
+			conflictStrategySkipLater,
+			map[mappingKey][]mappingSource{
+				{roleMappingType, adminRoleMapping.RoleArn}:  {teamASource},
+				{roleMappingType, deployRoleMapping.RoleArn}: {teamBSource},
+			},
+		},
+		{
+			"unionGroups",
+			conflictStrategyUnionGroups,
+			map[mappingKey][]mappingSource{
+				{roleMappingType, adminRoleMapping.RoleArn}:  {teamASource, teamBSource},
+				{roleMappingType, deployRoleMapping.RoleArn}: {teamBSource},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{teamB, teamA}, mergeOptions{conflictStrategy: tc.strategy})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedProvenance, result.provenance)
+		})
+	}
+}
+
+// Test that the mappings in the written aws-auth ConfigMap that were not merged from any source are recorded with the
+// Drift or PendingRemoval kind, depending on why they were kept.
+func TestWithUnmergedProvenance(t *testing.T) {
+	t.Parallel()
+
+	source := newAwsAuthConfigMap(t, "source", "", []RoleMapping{managedRoleMapping, deployRoleMapping}, []UserMapping{})
+	source.Namespace = "aws-auth-merger"
+	result, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{source}, mergeOptions{conflictStrategy: conflictStrategyFail})
+	require.NoError(t, err)
+	// The deploy role was removed from the source, and is kept for the removal grace period.
+	pendingJson, err := encodePendingRemovals(map[mappingKey]time.Time{{roleMappingType, deployRoleMapping.RoleArn}: time.Now()})
+	require.NoError(t, err)
+	result.merged.Annotations[pendingRemovalAnnotationKey] = pendingJson
+	delete(result.provenance, mappingKey{roleMappingType, deployRoleMapping.RoleArn})
+	written, err := addMappingsToConfigMap(result.merged, parsedAwsAuthConfigMap{mapRoles: []RoleMapping{eksNodeGroupRoleMapping, adminRoleMapping}}, true)
+	require.NoError(t, err)
+	written, err = addMappingsToConfigMap(written, parsedAwsAuthConfigMap{mapRoles: []RoleMapping{foreignRoleMapping}}, false)
+	require.NoError(t, err)
+
+	authMerger := AwsAuthMerger{namespace: "aws-auth-merger", adoptEksNodeMappings: true, logger: logrus.New()}
+	provenance := authMerger.withUnmergedProvenance(result.provenance, written)
+	assert.Equal(
+		t,
+		map[mappingKey][]mappingSource{
+			{roleMappingType, managedRoleMapping.RoleArn}:      {{Kind: configMapSourceKind, Namespace: "aws-auth-merger", Name: "source"}},
+			{roleMappingType, deployRoleMapping.RoleArn}:       {{Kind: pendingRemovalSourceKind, Namespace: mainAwsAuthConfigMapNamespace, Name: mainAwsAuthConfigMapName}},
+			{roleMappingType, eksNodeGroupRoleMapping.RoleArn}: {{Kind: driftSourceKind, Namespace: "aws-auth-merger", Name: eksNodeConfigMapName}},
+			{roleMappingType, adminRoleMapping.RoleArn}:        {{Kind: driftSourceKind, Namespace: "aws-auth-merger", Name: adoptedConfigMapName}},
+			{roleMappingType, foreignRoleMapping.RoleArn}:      {{Kind: driftSourceKind, Namespace: mainAwsAuthConfigMapNamespace, Name: mainAwsAuthConfigMapName}},
+		},
+		provenance,
+	)
+	// The provenance of the merged mappings is left as is.
+	assert.Len(t, result.provenance, 1)
+}
+
+func TestProvenanceConfigMapRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	provenance := map[mappingKey][]mappingSource{
+		{roleMappingType, adminRoleMapping.RoleArn}:               {{Kind: configMapSourceKind, Namespace: "aws-auth-merger", Name: "team-a", ResourceVersion: "1"}},
+		{userMappingType, "arn:aws:iam::123456789012:user/alice"}: {{Kind: configMapSourceKind, Namespace: "aws-auth-merger", Name: "team-b", ResourceVersion: "2"}},
+		{accountMappingType, "123456789012"}:                      {{Kind: configMapSourceKind, Namespace: "aws-auth-merger", Name: "team-c", ResourceVersion: "3"}},
+	}
+	configmap, err := newProvenanceConfigMap(provenance)
+	require.NoError(t, err)
+	assert.Equal(t, provenanceConfigMapName, configmap.Name)
+	assert.Equal(t, mainAwsAuthConfigMapNamespace, configmap.Namespace)
+	assert.Equal(
+		t,
+		`{"arn:aws:iam::123456789012:role/admin":[{"kind":"ConfigMap","namespace":"aws-auth-merger","name":"team-a","resourceVersion":"1"}]}`,
+		configmap.Data[mapRolesKey],
+	)
+
+	decoded, err := decodeProvenanceConfigMap(configmap)
+	require.NoError(t, err)
+	assert.Equal(t, provenance, decoded)
+}
+
+// Test that the provenance ConfigMap is created, and only updated when the provenance changes.
+func TestUpsertProvenanceConfigMap(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	authMerger := AwsAuthMerger{
+		clientset: clientset,
+		ctx:       context.Background(),
+		logger:    logrus.New(),
+	}
+	provenance := map[mappingKey][]mappingSource{
+		{roleMappingType, adminRoleMapping.RoleArn}: {{Kind: configMapSourceKind, Namespace: "aws-auth-merger", Name: "team-a", ResourceVersion: "1"}},
+	}
+	require.NoError(t, authMerger.upsertProvenanceConfigMap(provenance))
+	created, err := clientset.CoreV1().ConfigMaps(mainAwsAuthConfigMapNamespace).Get(context.Background(), provenanceConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	decoded, err := decodeProvenanceConfigMap(*created)
+	require.NoError(t, err)
+	assert.Equal(t, provenance, decoded)
+
+	clientset.ClearActions()
+	require.NoError(t, authMerger.upsertProvenanceConfigMap(provenance))
+	for _, action := range clientset.Actions() {
+		assert.False(t, action.Matches("update", "configmaps"), "unexpected update of ConfigMap %v", action)
+	}
+
+	// A new version of the same source, such as the one from patching the status annotations, is not recorded on its
+	// own.
+	clientset.ClearActions()
+	provenance[mappingKey{roleMappingType, adminRoleMapping.RoleArn}][0].ResourceVersion = "2"
+	require.NoError(t, authMerger.upsertProvenanceConfigMap(provenance))
+	for _, action := range clientset.Actions() {
+		assert.False(t, action.Matches("update", "configmaps"), "unexpected update of ConfigMap %v", action)
+	}
+
+	provenance[mappingKey{roleMappingType, adminRoleMapping.RoleArn}] = []mappingSource{{Kind: configMapSourceKind, Namespace: "aws-auth-merger", Name: "team-b", ResourceVersion: "3"}}
+	require.NoError(t, authMerger.upsertProvenanceConfigMap(provenance))
+	updated, err := clientset.CoreV1().ConfigMaps(mainAwsAuthConfigMapNamespace).Get(context.Background(), provenanceConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	decoded, err = decodeProvenanceConfigMap(*updated)
+	require.NoError(t, err)
+	assert.Equal(t, provenance, decoded)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// This annotation is set by the merger on the main aws-auth ConfigMap to track the mappings that were removed from
+	// all the sources, but are kept until the removal grace period is over. The value is a JSON object keyed by the
+	// aws-auth data key, mapping the ARN or account ID to the time the mapping was first found missing.
+	pendingRemovalAnnotationKey = "gruntwork.io/aws-auth-merger-pending-removal"
+)
+
+// mappingTypeDataKeys maps each mapping type to the aws-auth data key for the list of that type.
+var mappingTypeDataKeys = map[mappingType]string{
+	roleMappingType:    mapRolesKey,
+	userMappingType:    mapUsersKey,
+	accountMappingType: mapAccountsKey,
+}
+
+// applyRemovalGracePeriod returns the desired merged ConfigMap with the mappings that were removed from all the sources
+// added back, if they were removed less than the removal grace period ago. This protects against the window where a
+// source ConfigMap is deleted and not yet recreated, such as when Terraform replaces it. The kept mappings are still
+// managed by the merger, and are tracked in the pending removal annotation so that they are dropped once the grace
+// period is over. Mappings that show up in the sources again are no longer pending removal.
+func (authMerger *AwsAuthMerger) applyRemovalGracePeriod(existing *corev1.ConfigMap, desired corev1.ConfigMap) (corev1.ConfigMap, error) {
+	if authMerger.removalGracePeriod <= 0 || existing == nil || !isManagedByMerger(existing) {
+		return desired, nil
+	}
+	managedRaw, hasManaged := existing.Annotations[managedMappingsAnnotationKey]
+	if !hasManaged {
+		return desired, nil
+	}
+	managed, err := decodeManagedMappings(managedRaw)
+	if err != nil {
+		authMerger.logger.Warnf("Could not determine the managed mappings in ConfigMap %s in Namespace %s, so removed mappings are dropped immediately: %s", existing.Name, existing.Namespace, err)
+		return desired, nil
+	}
+	existingMappings, err := parseAwsAuthConfigMap(*existing, conflictStrategyFail)
+	if err != nil {
+		authMerger.logger.Warnf("Could not parse ConfigMap %s in Namespace %s, so removed mappings are dropped immediately: %s", existing.Name, existing.Namespace, err)
+		return desired, nil
+	}
+	pendingSince := map[mappingKey]time.Time{}
+	if pendingRaw, hasPending := existing.Annotations[pendingRemovalAnnotationKey]; hasPending {
+		pendingSince, err = decodePendingRemovals(pendingRaw)
+		if err != nil {
+			// Restart the grace period for all the removed mappings, so that they are not dropped early.
+			authMerger.logger.Warnf("Could not parse the %s annotation on ConfigMap %s in Namespace %s, so the removal grace period is restarted: %s", pendingRemovalAnnotationKey, existing.Name, existing.Namespace, err)
+			pendingSince = map[mappingKey]time.Time{}
+		}
+	}
+	desiredMappings, err := parseAwsAuthConfigMap(desired, conflictStrategyFail)
+	if err != nil {
+		return desired, err
+	}
+	desiredKeys := mappingKeySet(desiredMappings)
+
+	now := time.Now().UTC()
+	kept := parsedAwsAuthConfigMap{
+		name:        existing.Name,
+		mapRoles:    []RoleMapping{},
+		mapUsers:    []UserMapping{},
+		mapAccounts: []AccountMapping{},
+	}
+	stillPending := map[mappingKey]time.Time{}
+	// isKept returns true if the mapping with the given key was removed from all the sources, but is still within the
+	// removal grace period.
+	isKept := func(key mappingKey, description string) bool {
+		if !managed[key] || desiredKeys[key] {
+			return false
+		}
+		since, isPending := pendingSince[key]
+		if !isPending {
+			since = now
+		}
+		if now.Sub(since) >= authMerger.removalGracePeriod {
+			authMerger.logger.Infof("Removal grace period is over for %s mapping %s. Removing it from ConfigMap %s in Namespace %s.", key.mappingType, description, existing.Name, existing.Namespace)
+			return false
+		}
+		authMerger.logger.Infof("%s mapping %s was removed from all source ConfigMaps. Keeping it in ConfigMap %s in Namespace %s until %s.", key.mappingType, description, existing.Name, existing.Namespace, since.Add(authMerger.removalGracePeriod).Format(time.RFC3339))
+		stillPending[key] = since
+		return true
+	}
+	for _, roleMapping := range existingMappings.mapRoles {
+		if isKept(mappingKey{roleMappingType, roleMapping.RoleArn}, roleMapping.String()) {
+			kept.mapRoles = append(kept.mapRoles, roleMapping)
+		}
+	}
+	for _, userMapping := range existingMappings.mapUsers {
+		if isKept(mappingKey{userMappingType, userMapping.UserArn}, userMapping.String()) {
+			kept.mapUsers = append(kept.mapUsers, userMapping)
+		}
+	}
+	for _, accountMapping := range existingMappings.mapAccounts {
+		if isKept(mappingKey{accountMappingType, string(accountMapping)}, string(accountMapping)) {
+			kept.mapAccounts = append(kept.mapAccounts, accountMapping)
+		}
+	}
+	if len(stillPending) == 0 {
+		return desired, nil
+	}
+	authMerger.scheduleRemovalSync(stillPending, now)
+
+	updated, err := addMappingsToConfigMap(desired, kept, true)
+	if err != nil {
+		return desired, err
+	}
+	pendingJson, err := encodePendingRemovals(stillPending)
+	if err != nil {
+		return desired, err
+	}
+	updated.Annotations[pendingRemovalAnnotationKey] = pendingJson
+	return updated, nil
+}
+
+// scheduleRemovalSync schedules a sync for when the earliest of the given pending removals is over, so that the mapping
+// is removed right after its grace period instead of on the next refresh interval. The queue keeps the earliest of the
+// scheduled times, so scheduling again on every sync is fine.
+func (authMerger *AwsAuthMerger) scheduleRemovalSync(pendingSince map[mappingKey]time.Time, now time.Time) {
+	if authMerger.syncQueue == nil {
+		return
+	}
+	var earliest time.Time
+	for _, since := range pendingSince {
+		if earliest.IsZero() || since.Before(earliest) {
+			earliest = since
+		}
+	}
+	delay := earliest.Add(authMerger.removalGracePeriod).Sub(now)
+	authMerger.logger.Infof("Scheduling a sync in %s for when the removal grace period of the next pending removal is over.", delay)
+	authMerger.syncQueue.AddAfter(syncQueueKey, delay)
+}
+
+// encodePendingRemovals encodes the given removal times as JSON, keyed by the aws-auth data key, so that they can be
+// recorded in the pending removal annotation.
+func encodePendingRemovals(pendingSince map[mappingKey]time.Time) (string, error) {
+	pending := map[string]map[string]string{}
+	for key, since := range pendingSince {
+		dataKey := mappingTypeDataKeys[key.mappingType]
+		if pending[dataKey] == nil {
+			pending[dataKey] = map[string]string{}
+		}
+		pending[dataKey][key.key] = since.UTC().Format(time.RFC3339)
+	}
+	pendingJson, err := json.Marshal(pending)
+	if err != nil {
+		return "", errors.WithStackTrace(err)
+	}
+	return string(pendingJson), nil
+}
+
+// decodePendingRemovals decodes the pending removal annotation into the time each mapping was first found missing.
+func decodePendingRemovals(pendingRaw string) (map[mappingKey]time.Time, error) {
+	var pending map[string]map[string]string
+	if err := json.Unmarshal([]byte(pendingRaw), &pending); err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	pendingSince := map[mappingKey]time.Time{}
+	for mappingType, dataKey := range mappingTypeDataKeys {
+		for key, sinceRaw := range pending[dataKey] {
+			since, err := time.Parse(time.RFC3339, sinceRaw)
+			if err != nil {
+				return nil, errors.WithStackTrace(InvalidPendingRemovalErr{key, sinceRaw})
+			}
+			pendingSince[mappingKey{mappingType, key}] = since
+		}
+	}
+	return pendingSince, nil
+}
+
+// Custom errors
+
+type InvalidPendingRemovalErr struct {
+	key   string
+	since string
+}
+
+func (err InvalidPendingRemovalErr) Error() string {
+	return fmt.Sprintf("Invalid pending removal time for %s: %s is not an RFC3339 timestamp.", err.key, err.since)
+}
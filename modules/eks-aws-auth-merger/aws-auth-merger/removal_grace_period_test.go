@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyRemovalGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	removedKey := mappingKey{roleMappingType, deployRoleMapping.RoleArn}
+
+	testCases := []struct {
+		name            string
+		gracePeriod     time.Duration
+		pendingSince    map[mappingKey]time.Time
+		desiredRoles    []RoleMapping
+		expectedRoles   []RoleMapping
+		expectedPending []mappingKey
+	}{
+		{"disabled", 0, nil, []RoleMapping{adminRoleMapping}, []RoleMapping{adminRoleMapping}, nil},
+		{"newlyRemoved", 10 * time.Minute, nil, []RoleMapping{adminRoleMapping}, []RoleMapping{adminRoleMapping, deployRoleMapping}, []mappingKey{removedKey}},
+		{"withinGracePeriod", 10 * time.Minute, map[mappingKey]time.Time{removedKey: time.Now().Add(-5 * time.Minute)}, []RoleMapping{adminRoleMapping}, []RoleMapping{adminRoleMapping, deployRoleMapping}, []mappingKey{removedKey}},
+		{"gracePeriodOver", 10 * time.Minute, map[mappingKey]time.Time{removedKey: time.Now().Add(-15 * time.Minute)}, []RoleMapping{adminRoleMapping}, []RoleMapping{adminRoleMapping}, nil},
+		{"reappeared", 10 * time.Minute, map[mappingKey]time.Time{removedKey: time.Now().Add(-5 * time.Minute)}, []RoleMapping{adminRoleMapping, deployRoleMapping}, []RoleMapping{adminRoleMapping, deployRoleMapping}, nil},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			existing := mergeTestConfigMaps(t, []RoleMapping{adminRoleMapping, deployRoleMapping})
+			if tc.pendingSince != nil {
+				pendingJson, err := encodePendingRemovals(tc.pendingSince)
+				require.NoError(t, err)
+				existing.Annotations[pendingRemovalAnnotationKey] = pendingJson
+			}
+			desired := mergeTestConfigMaps(t, tc.desiredRoles)
+			authMerger := AwsAuthMerger{removalGracePeriod: tc.gracePeriod, logger: logrus.New()}
+
+			updated, err := authMerger.applyRemovalGracePeriod(&existing, desired)
+			require.NoError(t, err)
+			roles, err := getRoleMappingFromConfigMap(updated)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedRoles, roles)
+			assert.Equal(t, hashConfigMapData(updated.Data), updated.Annotations[contentHashAnnotationKey])
+
+			// The kept mappings are still managed by the merger, so that they are not mistaken for drift.
+			expectedManaged, err := encodeManagedMappings(parsedAwsAuthConfigMap{mapRoles: tc.expectedRoles})
+			require.NoError(t, err)
+			assert.Equal(t, expectedManaged, updated.Annotations[managedMappingsAnnotationKey])
+
+			pendingRaw, hasPending := updated.Annotations[pendingRemovalAnnotationKey]
+			if len(tc.expectedPending) == 0 {
+				assert.False(t, hasPending)
+				return
+			}
+			require.True(t, hasPending)
+			pending, err := decodePendingRemovals(pendingRaw)
+			require.NoError(t, err)
+			for _, key := range tc.expectedPending {
+				require.Contains(t, pending, key)
+				if since, wasPending := tc.pendingSince[key]; wasPending {
+					// The grace period is not restarted on each sync.
+					assert.Equal(t, since.UTC().Truncate(time.Second), pending[key])
+				}
+			}
+		})
+	}
+}
+
+// Test that a sync is scheduled for when the grace period of a pending removal is over, instead of waiting for the next
+// refresh interval.
+func TestApplyRemovalGracePeriodSchedulesSync(t *testing.T) {
+	t.Parallel()
+
+	queue := newSyncQueue(time.Millisecond, 10*time.Millisecond)
+	defer queue.ShutDown()
+	existing := mergeTestConfigMaps(t, []RoleMapping{adminRoleMapping, deployRoleMapping})
+	desired := mergeTestConfigMaps(t, []RoleMapping{adminRoleMapping})
+	authMerger := AwsAuthMerger{removalGracePeriod: 500 * time.Millisecond, syncQueue: queue, logger: logrus.New()}
+
+	_, err := authMerger.applyRemovalGracePeriod(&existing, desired)
+	require.NoError(t, err)
+	assert.Equal(t, 0, queue.Len())
+	assert.Eventually(t, func() bool { return queue.Len() == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+// Test that the pending removal annotation round trips through encoding, and that invalid timestamps are rejected.
+func TestDecodePendingRemovals(t *testing.T) {
+	t.Parallel()
+
+	since := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	pendingSince := map[mappingKey]time.Time{
+		{roleMappingType, "arn:aws:iam::123456789012:role/deploy"}: since,
+		{userMappingType, "arn:aws:iam::123456789012:user/ops"}:    since,
+		{accountMappingType, "123456789012"}:                       since,
+	}
+	pendingJson, err := encodePendingRemovals(pendingSince)
+	require.NoError(t, err)
+	decoded, err := decodePendingRemovals(pendingJson)
+	require.NoError(t, err)
+	assert.Equal(t, pendingSince, decoded)
+
+	_, err = decodePendingRemovals(`{"mapRoles": {"arn:aws:iam::123456789012:role/deploy": "yesterday"}}`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// The kinds of objects that the source aws-auth mappings are read from. Secrets are converted to ConfigMaps with the
+	// Kind set to Secret, so that all kinds of sources go through the same merge logic.
+	configMapSourceKind = "ConfigMap"
+	secretSourceKind    = "Secret"
+)
+
+// configMapFromSecret converts the given source Secret to a ConfigMap with the same metadata, so that it can be merged
+// along with the source ConfigMaps. Only the aws-auth data keys are copied over, so that the other contents of the
+// Secret are not carried around by the merger. The Kind of the returned ConfigMap is set to Secret, which is how the
+// rest of the merger tells the two kinds of sources apart.
+func configMapFromSecret(secret corev1.Secret) corev1.ConfigMap {
+	data := map[string]string{}
+	for _, key := range []string{mapRolesKey, mapUsersKey, mapAccountsKey} {
+		if value, hasKey := secret.Data[key]; hasKey {
+			data[key] = string(value)
+		}
+	}
+	return corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{Kind: secretSourceKind, APIVersion: "v1"},
+		ObjectMeta: *secret.ObjectMeta.DeepCopy(),
+		Data:       data,
+	}
+}
+
+// isSecretSource returns true if the given source ConfigMap was converted from a Secret.
+func isSecretSource(configmap corev1.ConfigMap) bool {
+	return configmap.Kind == secretSourceKind
+}
+
+// sourceKind returns the kind of object that the given source ConfigMap was read from. Sources that were converted
+// from other kinds of objects have the Kind set to the kind of the original object.
+func sourceKind(configmap corev1.ConfigMap) string {
+	if configmap.Kind == "" {
+		return configMapSourceKind
+	}
+	return configmap.Kind
+}
+
+// validateWatchSecrets returns an error if Secrets are merged along with a source Namespace selector. The Secrets are
+// only read from the Namespaces that are configured by name, so that the merger does not need access to the Secrets
+// across the cluster.
+func validateWatchSecrets(watchSecrets bool, namespaceSelector string) error {
+	if watchSecrets && namespaceSelector != "" {
+		return errors.WithStackTrace(WatchSecretsWithNamespaceSelectorErr{})
+	}
+	return nil
+}
+
+// listAwsAuthSecretsFromAPI will list the AWS Auth Secrets that should be merged together from the Kubernetes API,
+// converted to ConfigMaps. The Secrets are listed in each of the Namespaces that are configured by name, and sorted by
+// Namespace and name. Returns nothing if Secrets are not watched.
+func (authMerger *AwsAuthMerger) listAwsAuthSecretsFromAPI() ([]corev1.ConfigMap, error) {
+	allSecrets := []corev1.ConfigMap{}
+	if !authMerger.watchSecrets {
+		return allSecrets, nil
+	}
+	namespaces := authMerger.explicitSourceNamespaces()
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		secrets, err := authMerger.listSecretsFromAPI(namespace)
+		if err != nil {
+			return nil, err
+		}
+		allSecrets = append(allSecrets, secrets...)
+	}
+	return allSecrets, nil
+}
+
+// listSecretsFromAPI will list the Secrets with the configured label selector in the given Namespace from the
+// Kubernetes API, paginating through the results, and convert them to ConfigMaps.
+func (authMerger *AwsAuthMerger) listSecretsFromAPI(namespace string) ([]corev1.ConfigMap, error) {
+	options := metav1.ListOptions{LabelSelector: authMerger.labelSelector}
+	allSecrets := []corev1.ConfigMap{}
+	for {
+		secretList, err := authMerger.clientset.CoreV1().Secrets(namespace).List(authMerger.ctx, options)
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		for _, secret := range secretList.Items {
+			allSecrets = append(allSecrets, configMapFromSecret(secret))
+		}
+		if secretList.Continue == "" {
+			return allSecrets, nil
+		}
+		options.Continue = secretList.Continue
+	}
+}
+
+// listAwsAuthSecretsFromCache will list the AWS Auth Secrets that should be merged together from the informer caches of
+// the watcher, converted to ConfigMaps. Like listAwsAuthSecretsFromAPI, the Secrets are read from each of the
+// Namespaces that are configured by name, and sorted by Namespace and name. Returns nothing if Secrets are not watched.
+func (authMerger *AwsAuthMerger) listAwsAuthSecretsFromCache() ([]corev1.ConfigMap, error) {
+	if !authMerger.watchSecrets || authMerger.secretLister == nil {
+		return []corev1.ConfigMap{}, nil
+	}
+	secrets := []corev1.ConfigMap{}
+	for _, namespace := range authMerger.explicitSourceNamespaces() {
+		cached, err := authMerger.secretLister.Secrets(namespace).List(labels.Everything())
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		// configMapFromSecret copies what it needs, so the shared objects in the cache are not modified.
+		for _, secret := range cached {
+			secrets = append(secrets, configMapFromSecret(*secret))
+		}
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		if secrets[i].Namespace != secrets[j].Namespace {
+			return secrets[i].Namespace < secrets[j].Namespace
+		}
+		return secrets[i].Name < secrets[j].Name
+	})
+	return secrets, nil
+}
+
+// namespacedSecretLister combines the listers of the Secret informers for each of the source Namespaces, keyed by
+// Namespace, into a single lister. The Secrets in Namespaces without an informer are never listed.
+type namespacedSecretLister map[string]corelisters.SecretLister
+
+// List lists the Secrets in all the source Namespaces that match the given selector.
+func (lister namespacedSecretLister) List(selector labels.Selector) ([]*corev1.Secret, error) {
+	allSecrets := []*corev1.Secret{}
+	for _, namespaceLister := range lister {
+		secrets, err := namespaceLister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		allSecrets = append(allSecrets, secrets...)
+	}
+	return allSecrets, nil
+}
+
+// Secrets returns a lister for the Secrets in the given Namespace, which is empty if the Namespace is not watched.
+func (lister namespacedSecretLister) Secrets(namespace string) corelisters.SecretNamespaceLister {
+	if namespaceLister, isWatched := lister[namespace]; isWatched {
+		return namespaceLister.Secrets(namespace)
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	return corelisters.NewSecretLister(indexer).Secrets(namespace)
+}
+
+// Custom errors
+
+type WatchSecretsWithNamespaceSelectorErr struct{}
+
+func (err WatchSecretsWithNamespaceSelectorErr) Error() string {
+	return "Secrets can not be merged from the Namespaces that are selected with --source-namespace-selector, as it requires access to the Secrets across the cluster. Pass the Namespaces with --source-namespace instead, or disable --watch-secrets."
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newAwsAuthSecret returns a source Secret with the same name and mappings as the given source ConfigMap.
+func newAwsAuthSecret(configmap corev1.ConfigMap) corev1.Secret {
+	data := map[string][]byte{}
+	for key, value := range configmap.Data {
+		data[key] = []byte(value)
+	}
+	return corev1.Secret{ObjectMeta: *configmap.ObjectMeta.DeepCopy(), Data: data}
+}
+
+func TestConfigMapFromSecret(t *testing.T) {
+	t.Parallel()
+
+	source := newAwsAuthConfigMap(t, "team", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	source.Namespace = "aws-auth-merger"
+	secret := newAwsAuthSecret(source)
+	secret.Data["token"] = []byte("not an aws-auth key")
+
+	converted := configMapFromSecret(secret)
+	assert.True(t, isSecretSource(converted))
+	assert.Equal(t, secretSourceKind, sourceKind(converted))
+	assert.Equal(t, source.Data, converted.Data)
+	assert.Equal(t, "secret/team", mergeOptions{}.sourceName(converted))
+	assert.Equal(t, "aws-auth-merger/secret/team", mergeOptions{qualifySourceNames: true}.sourceName(converted))
+
+	assert.False(t, isSecretSource(source))
+	assert.Equal(t, configMapSourceKind, sourceKind(source))
+	assert.Equal(t, "team", mergeOptions{}.sourceName(source))
+}
+
+// Test that the Secrets are only listed when watching Secrets, both from the Kubernetes API and from the informer
+// cache, and that they are listed after the ConfigMaps.
+func TestListAwsAuthConfigMapsWithSecrets(t *testing.T) {
+	t.Parallel()
+
+	configmap := newAwsAuthConfigMap(t, "team", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	configmap.Namespace = "aws-auth-merger"
+	secret := newAwsAuthSecret(newAwsAuthConfigMap(t, "team", "", []RoleMapping{deployRoleMapping}, []UserMapping{}))
+	secret.Namespace = "aws-auth-merger"
+
+	testCases := []struct {
+		name            string
+		watchSecrets    bool
+		fromCache       bool
+		expectedSources []string
+	}{
+		{"api", true, false, []string{"team", "secret/team"}},
+		{"cache", true, true, []string{"team", "secret/team"}},
+		{"apiWithoutSecrets", false, false, []string{"team"}},
+		{"cacheWithoutSecrets", false, true, []string{"team"}},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			authMerger := AwsAuthMerger{
+				namespace:    "aws-auth-merger",
+				watchSecrets: tc.watchSecrets,
+				clientset:    fake.NewSimpleClientset(configmap.DeepCopy(), secret.DeepCopy()),
+				ctx:          context.Background(),
+				logger:       logrus.New(),
+			}
+			if tc.fromCache {
+				authMerger.configMapLister = newTestConfigMapLister(t, configmap)
+				indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+				require.NoError(t, indexer.Add(secret.DeepCopy()))
+				authMerger.secretLister = corelisters.NewSecretLister(indexer)
+			}
+
+			listed, err := authMerger.listAwsAuthConfigMaps()
+			require.NoError(t, err)
+			sources := []string{}
+			for _, source := range listed {
+				sources = append(sources, authMerger.mergeOptions().sourceName(source))
+			}
+			assert.Equal(t, tc.expectedSources, sources)
+		})
+	}
+}
+
+// Test that the Secrets are only listed in the Namespaces that are configured by name, both from the Kubernetes API and
+// from the informer caches of the watcher, and never across the cluster.
+func TestListAwsAuthSecretsSourceNamespaces(t *testing.T) {
+	t.Parallel()
+
+	objects := []runtime.Object{}
+	for _, namespace := range []string{"aws-auth-merger", "team", "other"} {
+		secret := newAwsAuthSecret(newAwsAuthConfigMap(t, "mappings", "", []RoleMapping{adminRoleMapping}, []UserMapping{}))
+		secret.Namespace = namespace
+		objects = append(objects, &secret)
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+	authMerger := AwsAuthMerger{
+		namespace:        "aws-auth-merger",
+		sourceNamespaces: []string{"team"},
+		watchSecrets:     true,
+		clientset:        clientset,
+		ctx:              context.Background(),
+		logger:           logrus.New(),
+	}
+	expectedSources := []string{"aws-auth-merger/secret/mappings", "team/secret/mappings"}
+
+	listed, err := authMerger.listAwsAuthSecretsFromAPI()
+	require.NoError(t, err)
+	sources := []string{}
+	for _, source := range listed {
+		sources = append(sources, authMerger.mergeOptions().sourceName(source))
+	}
+	assert.Equal(t, expectedSources, sources)
+	for _, action := range clientset.Actions() {
+		assert.NotEqual(t, metav1.NamespaceAll, action.GetNamespace(), "unexpected cluster wide action %v", action)
+	}
+
+	queue := newSyncQueue(time.Millisecond, 10*time.Millisecond)
+	defer queue.ShutDown()
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	controller := NewConfigMapWatchController(logrus.New(), clientset, authMerger.explicitSourceNamespaces(), "", "", true, nil, queue)
+	require.NoError(t, controller.Run(stopChan))
+	authMerger.secretLister = controller.SecretLister()
+
+	cached, err := authMerger.listAwsAuthSecretsFromCache()
+	require.NoError(t, err)
+	sources = []string{}
+	for _, source := range cached {
+		sources = append(sources, authMerger.mergeOptions().sourceName(source))
+	}
+	assert.Equal(t, expectedSources, sources)
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource == "secrets" {
+			assert.NotEqual(t, metav1.NamespaceAll, action.GetNamespace(), "unexpected cluster wide action %v", action)
+		}
+	}
+}
+
+func TestValidateWatchSecrets(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateWatchSecrets(true, ""))
+	assert.NoError(t, validateWatchSecrets(false, testSourceNamespaceSelector))
+	assert.Error(t, validateWatchSecrets(true, testSourceNamespaceSelector))
+}
+
+// Test that syncing merges the Secrets along with the ConfigMaps, records the kind of each source in the provenance,
+// and records the merge status on the Secret.
+func TestSyncAwsAuthSecrets(t *testing.T) {
+	t.Parallel()
+
+	configmap := newAwsAuthConfigMap(t, "team", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	configmap.Namespace = "aws-auth-merger"
+	secret := newAwsAuthSecret(newAwsAuthConfigMap(t, "team", "", []RoleMapping{deployRoleMapping}, []UserMapping{}))
+	secret.Namespace = "aws-auth-merger"
+
+	clientset := fake.NewSimpleClientset(&configmap, &secret)
+	authMerger := AwsAuthMerger{
+		namespace:        "aws-auth-merger",
+		watchSecrets:     true,
+		conflictStrategy: conflictStrategyFail,
+		clientset:        clientset,
+		ctx:              context.Background(),
+		logger:           logrus.New(),
+	}
+	require.NoError(t, authMerger.syncAwsAuthConfigMaps())
+
+	main, err := clientset.CoreV1().ConfigMaps(mainAwsAuthConfigMapNamespace).Get(context.Background(), mainAwsAuthConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, `["secret/team","team"]`, main.Annotations[sourcesAnnotationKey])
+
+	provenanceConfigMap, err := clientset.CoreV1().ConfigMaps(mainAwsAuthConfigMapNamespace).Get(context.Background(), provenanceConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	provenance, err := decodeProvenanceConfigMap(*provenanceConfigMap)
+	require.NoError(t, err)
+	assert.Equal(t, []mappingSource{{Kind: configMapSourceKind, Namespace: "aws-auth-merger", Name: "team"}}, provenance[mappingKey{roleMappingType, adminRoleMapping.RoleArn}])
+	assert.Equal(t, []mappingSource{{Kind: secretSourceKind, Namespace: "aws-auth-merger", Name: "team"}}, provenance[mappingKey{roleMappingType, deployRoleMapping.RoleArn}])
+
+	updatedSecret, err := clientset.CoreV1().Secrets("aws-auth-merger").Get(context.Background(), "team", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "1", updatedSecret.Annotations[acceptedRoleMappingsAnnotationKey])
+	assert.Equal(t, secret.Data, updatedSecret.Data)
+}
+
+// Test that the watcher enqueues a sync when a source Secret changes, and only watches Secrets when configured to.
+func TestConfigMapWatchControllerSecrets(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		watchSecrets bool
+		expectedSync bool
+	}{
+		{"watchSecrets", true, true},
+		{"ignoreSecrets", false, false},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			clientset := fake.NewSimpleClientset()
+			queue := newSyncQueue(time.Millisecond, 10*time.Millisecond)
+			defer queue.ShutDown()
+			stopChan := make(chan struct{})
+			defer close(stopChan)
+			controller := NewConfigMapWatchController(logrus.New(), clientset, []string{"aws-auth-merger"}, "", "", tc.watchSecrets, nil, queue)
+			require.NoError(t, controller.Run(stopChan))
+			assert.Equal(t, tc.watchSecrets, controller.SecretLister() != nil)
+
+			secret := newAwsAuthSecret(newAwsAuthConfigMap(t, "team", "", []RoleMapping{adminRoleMapping}, []UserMapping{}))
+			_, err := clientset.CoreV1().Secrets("aws-auth-merger").Create(context.Background(), &secret, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			if tc.expectedSync {
+				assert.Eventually(t, func() bool { return queue.Len() == 1 }, 5*time.Second, 10*time.Millisecond)
+			} else {
+				time.Sleep(2 * syncDebounceInterval)
+				assert.Equal(t, 0, queue.Len())
+			}
+		})
+	}
+}
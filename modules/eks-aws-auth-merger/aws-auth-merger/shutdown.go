@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownSignals are the signals that trigger a graceful shutdown. Kubernetes sends SIGTERM to the container when the
+// Pod is deleted, for example during a rollout of the Deployment or when the node is drained.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// handleShutdownSignals returns a root context that is cancelled when the process receives a shutdown signal, which
+// stops the watchers and the sync queue. The context for the Kubernetes API calls is set on the AwsAuthMerger and is
+// only cancelled after the shutdown timeout, or on a second signal, so that an in-flight sync can complete instead of
+// being cut in the middle of a request. The returned function stops the signal handling and cancels both contexts.
+func (authMerger *AwsAuthMerger) handleShutdownSignals() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	apiCtx, cancelAPI := context.WithCancel(context.Background())
+	authMerger.ctx = apiCtx
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, shutdownSignals...)
+	go func() {
+		select {
+		case sig := <-signalChan:
+			authMerger.logger.Infof("Received signal %s. Finishing in-flight sync and shutting down within %s.", sig, authMerger.shutdownTimeout)
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+
+		timer := time.NewTimer(authMerger.shutdownTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			authMerger.logger.Warnf("Shutdown timeout of %s reached. Cancelling in-flight requests to the Kubernetes API.", authMerger.shutdownTimeout)
+		case sig := <-signalChan:
+			authMerger.logger.Warnf("Received signal %s again. Cancelling in-flight requests to the Kubernetes API.", sig)
+		case <-apiCtx.Done():
+		}
+		cancelAPI()
+	}()
+
+	stop := func() {
+		signal.Stop(signalChan)
+		cancel()
+		cancelAPI()
+	}
+	return ctx, stop
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that a shutdown signal cancels the root context right away, and the context for the API calls only after the
+// shutdown timeout. This test sends a signal to the test process, so it can not run in parallel with the other tests.
+func TestHandleShutdownSignals(t *testing.T) {
+	authMerger := AwsAuthMerger{
+		shutdownTimeout: 200 * time.Millisecond,
+		logger:          logrus.New(),
+	}
+	ctx, stop := authMerger.handleShutdownSignals()
+	defer stop()
+	require.NoError(t, ctx.Err())
+	require.NoError(t, authMerger.ctx.Err())
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the root context to be cancelled")
+	}
+	assert.NoError(t, authMerger.ctx.Err())
+
+	select {
+	case <-authMerger.ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the API context to be cancelled")
+	}
+}
+
+// Test that stopping the signal handling cancels both contexts.
+func TestHandleShutdownSignalsStop(t *testing.T) {
+	authMerger := AwsAuthMerger{
+		shutdownTimeout: time.Minute,
+		logger:          logrus.New(),
+	}
+	ctx, stop := authMerger.handleShutdownSignals()
+	stop()
+	assert.Error(t, ctx.Err())
+	assert.Error(t, authMerger.ctx.Err())
+}
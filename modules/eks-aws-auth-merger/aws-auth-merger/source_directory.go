@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// The kind of the sources that are read from files in the source directory. The files are converted to ConfigMaps
+	// with the Kind set to File, and the absolute path of the file as the name.
+	fileSourceKind = "File"
+
+	// Annotation on the ConfigMap of a source file that can not be parsed, with the reason it can not be parsed. This is
+	// only set on the ConfigMap in memory, so that the parse error is reported when the file is merged, and goes
+	// through the same quarantine logic as invalid source ConfigMaps.
+	sourceFileErrAnnotationKey = "gruntwork.io/aws-auth-merger-source-file-error"
+)
+
+// validateSourceDirectory returns an error if the given source directory is set, but is not a directory. The merger
+// would otherwise fail on every sync.
+func validateSourceDirectory(directory string) error {
+	if directory == "" {
+		return nil
+	}
+	info, err := os.Stat(directory)
+	if err != nil {
+		return errors.WithStackTrace(InvalidSourceDirectoryErr{directory, err.Error()})
+	}
+	if !info.IsDir() {
+		return errors.WithStackTrace(InvalidSourceDirectoryErr{directory, "not a directory"})
+	}
+	return nil
+}
+
+// sourceFileExtensions are the extensions of the files in the source directory that are merged. Other files are
+// ignored.
+var sourceFileExtensions = []string{".yaml", ".yml", ".json"}
+
+// isSourceFile returns true if the file with the given name in the source directory should be merged. Hidden files are
+// ignored, which includes the ..data directories and temporary files that Kubernetes uses to atomically update
+// ConfigMap and projected volumes.
+func isSourceFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	extension := filepath.Ext(name)
+	for _, sourceExtension := range sourceFileExtensions {
+		if extension == sourceExtension {
+			return true
+		}
+	}
+	return false
+}
+
+// listSourceDirectory reads the source files in the given directory, and converts them to ConfigMaps so that they can
+// be merged along with the source ConfigMaps. The files are returned in the order of their names. Subdirectories are not
+// read. Files that can not be parsed are returned as invalid sources, so that one bad file does not fail the listing.
+func listSourceDirectory(directory string) ([]corev1.ConfigMap, error) {
+	paths, err := listSourceFilePaths(directory)
+	if err != nil {
+		return nil, err
+	}
+	configmaps := []corev1.ConfigMap{}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		configmap, err := configMapFromSourceFile(path, content)
+		if err != nil {
+			configmap = invalidSourceFileConfigMap(path, content, err)
+		}
+		configmaps = append(configmaps, configmap)
+	}
+	return configmaps, nil
+}
+
+// listSourceFilePaths returns the absolute paths of the source files in the given directory, in the order of their
+// names. Subdirectories are not read.
+func listSourceFilePaths(directory string) ([]string, error) {
+	absDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	// ReadDir returns the entries sorted by name.
+	entries, err := ioutil.ReadDir(absDirectory)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		if !isSourceFile(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(absDirectory, entry.Name())
+		// The entries of ConfigMap volumes are symlinks, so we check the target of the entry, and skip symlinks that
+		// point to nothing while the volume is being updated.
+		info, err := os.Stat(path)
+		if err != nil && os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		if info.IsDir() {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// sourceFile is the format of the files in the source directory. It has the same keys as the aws-auth ConfigMap, but the
+// mappings are YAML (or JSON) lists instead of strings.
+type sourceFile struct {
+	MapRoles    []RoleMapping    `yaml:"mapRoles"`
+	MapUsers    []UserMapping    `yaml:"mapUsers"`
+	MapAccounts []AccountMapping `yaml:"mapAccounts"`
+}
+
+// configMapFromSourceFile parses the given content of the source file at the given path, and converts it to a
+// ConfigMap with the mappings in the same format as an aws-auth ConfigMap. The Kind of the returned ConfigMap is set to
+// File, which is how the rest of the merger tells it apart, and the content hash is used as the resource version.
+func configMapFromSourceFile(path string, content []byte) (corev1.ConfigMap, error) {
+	var parsed sourceFile
+	// Unknown keys are rejected, so that typos such as maproles are caught instead of silently dropping the mappings.
+	if err := yaml.UnmarshalStrict(content, &parsed); err != nil {
+		return corev1.ConfigMap{}, errors.WithStackTrace(InvalidSourceFileErr{path, err})
+	}
+
+	data := map[string]string{}
+	if parsed.MapRoles != nil {
+		mapRoles, err := yaml.Marshal(parsed.MapRoles)
+		if err != nil {
+			return corev1.ConfigMap{}, errors.WithStackTrace(err)
+		}
+		data[mapRolesKey] = string(mapRoles)
+	}
+	if parsed.MapUsers != nil {
+		mapUsers, err := yaml.Marshal(parsed.MapUsers)
+		if err != nil {
+			return corev1.ConfigMap{}, errors.WithStackTrace(err)
+		}
+		data[mapUsersKey] = string(mapUsers)
+	}
+	if parsed.MapAccounts != nil {
+		mapAccounts, err := yaml.Marshal(parsed.MapAccounts)
+		if err != nil {
+			return corev1.ConfigMap{}, errors.WithStackTrace(err)
+		}
+		data[mapAccountsKey] = string(mapAccounts)
+	}
+
+	configmap := corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{Kind: fileSourceKind},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            path,
+			ResourceVersion: hashConfigMapData(data),
+		},
+		Data: data,
+	}
+	return configmap, nil
+}
+
+// invalidSourceFileConfigMap returns the ConfigMap of the source file at the given path, which can not be parsed due to
+// the given error. The error is reported by parseAwsAuthConfigMap when the file is merged. The content hash is used as
+// the resource version, so that the file is seen as changed once it is fixed.
+func invalidSourceFileConfigMap(path string, content []byte, parseErr error) corev1.ConfigMap {
+	reason := parseErr.Error()
+	if invalidErr, isInvalidErr := errors.Unwrap(parseErr).(InvalidSourceFileErr); isInvalidErr {
+		reason = invalidErr.underlyingErr.Error()
+	}
+	return corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{Kind: fileSourceKind},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            path,
+			ResourceVersion: hashConfigMapData(map[string]string{"content": string(content)}),
+			Annotations:     map[string]string{sourceFileErrAnnotationKey: reason},
+		},
+	}
+}
+
+// getSourceFileErr returns the error for the given source ConfigMap if it was read from a source file that can not be
+// parsed, or nil otherwise.
+func getSourceFileErr(configmap corev1.ConfigMap) error {
+	if !isFileSource(configmap) {
+		return nil
+	}
+	reason, isInvalid := configmap.Annotations[sourceFileErrAnnotationKey]
+	if !isInvalid {
+		return nil
+	}
+	return errors.WithStackTrace(InvalidSourceFileErr{configmap.Name, fmt.Errorf("%s", reason)})
+}
+
+// isFileSource returns true if the given source ConfigMap was read from a file in the source directory.
+func isFileSource(configmap corev1.ConfigMap) bool {
+	return configmap.Kind == fileSourceKind
+}
+
+// SourceDirectoryWatcher will enqueue a sync on the given workqueue when the files in the source directory change.
+//
+// To support directories that are swapped out atomically by replacing a symlink, as done by git-sync, the directory is
+// watched again when the watched directory is removed, which resolves the symlink to the new directory. ConfigMap and
+// projected volumes update the files by swapping out a symlink within the directory, which shows up as changes to the
+// files in the directory.
+type SourceDirectoryWatcher struct {
+	directory string
+	watcher   *fsnotify.Watcher
+	queue     workqueue.RateLimitingInterface
+	logger    *logrus.Logger
+}
+
+// NewSourceDirectoryWatcher starts watching the given directory for changes. Call Run to start enqueueing syncs.
+func NewSourceDirectoryWatcher(logger *logrus.Logger, directory string, queue workqueue.RateLimitingInterface) (*SourceDirectoryWatcher, error) {
+	absDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	if err := watcher.Add(absDirectory); err != nil {
+		watcher.Close()
+		return nil, errors.WithStackTrace(err)
+	}
+	sourceWatcher := &SourceDirectoryWatcher{
+		directory: absDirectory,
+		watcher:   watcher,
+		queue:     queue,
+		logger:    logger,
+	}
+	return sourceWatcher, nil
+}
+
+// Run enqueues a sync on every change to the source directory until the given channel is closed. This blocks, so it
+// should be run in a goroutine.
+func (sourceWatcher *SourceDirectoryWatcher) Run(stopChan <-chan struct{}) {
+	defer sourceWatcher.watcher.Close()
+	for {
+		select {
+		case event, isOpen := <-sourceWatcher.watcher.Events:
+			if !isOpen {
+				return
+			}
+			sourceWatcher.handleEvent(event)
+		case err, isOpen := <-sourceWatcher.watcher.Errors:
+			if !isOpen {
+				return
+			}
+			sourceWatcher.logger.Warnf("Error while watching source directory %s: %s", sourceWatcher.directory, err)
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+func (sourceWatcher *SourceDirectoryWatcher) handleEvent(event fsnotify.Event) {
+	// Changes to permissions don't change the content of the files.
+	if event.Op == fsnotify.Chmod {
+		return
+	}
+	sourceWatcher.logger.Debugf("Detected change to source directory: %s", event)
+
+	if event.Name == sourceWatcher.directory && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		// The watch is removed along with the directory, so we watch the directory again in case it was replaced.
+		if err := sourceWatcher.watcher.Add(sourceWatcher.directory); err != nil {
+			sourceWatcher.logger.Warnf("Source directory %s was removed, and can not be watched again: %s. Changes are only picked up every refresh interval.", sourceWatcher.directory, err)
+		}
+	}
+	sourceWatcher.queue.AddAfter(syncQueueKey, syncDebounceInterval)
+}
+
+// Custom errors
+
+type InvalidSourceDirectoryErr struct {
+	directory string
+	reason    string
+}
+
+func (err InvalidSourceDirectoryErr) Error() string {
+	return fmt.Sprintf("Invalid source directory %s: %s", err.directory, err.reason)
+}
+
+type InvalidSourceFileErr struct {
+	path          string
+	underlyingErr error
+}
+
+func (err InvalidSourceFileErr) Error() string {
+	return fmt.Sprintf("Error parsing source file %s: %s", err.path, err.underlyingErr)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const teamSourceFile = `mapRoles:
+- rolearn: arn:aws:iam::123456789012:role/deploy
+  username: deploy
+  groups:
+  - deployers
+`
+
+func writeSourceFile(t *testing.T, directory string, name string, content string) string {
+	path := filepath.Join(directory, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+// Test that only the YAML and JSON files in the source directory are read, and that they are read in order of their
+// names.
+func TestListSourceDirectory(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	writeSourceFile(t, directory, "b-team.yaml", teamSourceFile)
+	writeSourceFile(t, directory, "a-team.json", `{"mapUsers": [{"userarn": "arn:aws:iam::123456789012:user/admin", "username": "admin", "groups": ["system:masters"]}]}`)
+	writeSourceFile(t, directory, "README.md", "not a source file")
+	writeSourceFile(t, directory, ".hidden.yaml", "not: [valid")
+	require.NoError(t, os.Mkdir(filepath.Join(directory, "nested.yaml"), 0755))
+	require.NoError(t, os.Symlink(filepath.Join(directory, "missing.yaml"), filepath.Join(directory, "dangling.yaml")))
+
+	configmaps, err := listSourceDirectory(directory)
+	require.NoError(t, err)
+	require.Len(t, configmaps, 2)
+	assert.Equal(t, filepath.Join(directory, "a-team.json"), configmaps[0].Name)
+	assert.Equal(t, filepath.Join(directory, "b-team.yaml"), configmaps[1].Name)
+
+	users, err := parseAwsAuthConfigMap(configmaps[0], conflictStrategyFail)
+	require.NoError(t, err)
+	assert.Len(t, users.mapUsers, 1)
+	roles, err := parseAwsAuthConfigMap(configmaps[1], conflictStrategyFail)
+	require.NoError(t, err)
+	assert.Equal(t, []RoleMapping{deployRoleMapping}, roles.mapRoles)
+	assert.Equal(t, fileSourceKind, roles.source.Kind)
+}
+
+func TestConfigMapFromSourceFile(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		content     string
+		expectError bool
+	}{
+		{"valid", teamSourceFile, false},
+		{"empty", "", false},
+		{"unknownKey", "maproles: []", true},
+		{"invalidYaml", "mapRoles: [", true},
+		{"invalidMapping", "mapRoles: [{rolearn: arn, unknown: true}]", true},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			configmap, err := configMapFromSourceFile("/sources/team.yaml", []byte(tc.content))
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, isFileSource(configmap))
+			assert.Equal(t, "/sources/team.yaml", mergeOptions{qualifySourceNames: true}.sourceName(configmap))
+			assert.NotEmpty(t, configmap.ResourceVersion)
+		})
+	}
+}
+
+// Test that syncing merges the files in the source directory along with the ConfigMaps, and records the file path as
+// the source of the mappings in the provenance.
+func TestSyncSourceDirectory(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	path := writeSourceFile(t, directory, "team.yaml", teamSourceFile)
+	configmap := newAwsAuthConfigMap(t, "team", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	configmap.Namespace = "aws-auth-merger"
+
+	clientset := fake.NewSimpleClientset(&configmap)
+	authMerger := AwsAuthMerger{
+		namespace:        "aws-auth-merger",
+		sourceDirectory:  directory,
+		conflictStrategy: conflictStrategyFail,
+		clientset:        clientset,
+		ctx:              context.Background(),
+		logger:           logrus.New(),
+	}
+	require.NoError(t, authMerger.syncAwsAuthConfigMaps())
+
+	main, err := clientset.CoreV1().ConfigMaps(mainAwsAuthConfigMapNamespace).Get(context.Background(), mainAwsAuthConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, `["`+path+`","team"]`, main.Annotations[sourcesAnnotationKey])
+
+	provenanceConfigMap, err := clientset.CoreV1().ConfigMaps(mainAwsAuthConfigMapNamespace).Get(context.Background(), provenanceConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	provenance, err := decodeProvenanceConfigMap(*provenanceConfigMap)
+	require.NoError(t, err)
+	sources := provenance[mappingKey{roleMappingType, deployRoleMapping.RoleArn}]
+	require.Len(t, sources, 1)
+	assert.Equal(t, fileSourceKind, sources[0].Kind)
+	assert.Equal(t, path, sources[0].Name)
+	assert.Empty(t, sources[0].Namespace)
+}
+
+// Test that a source file that can not be parsed is quarantined like an invalid source ConfigMap, instead of failing
+// the sync, and that it fails the sync without retries when invalid sources are not quarantined.
+func TestSyncSourceDirectoryInvalidFile(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		quarantine bool
+	}{
+		{"quarantine", true},
+		{"fail", false},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			directory := t.TempDir()
+			writeSourceFile(t, directory, "invalid.yaml", "maproles: []")
+			configmap := newAwsAuthConfigMap(t, "team", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+			configmap.Namespace = "aws-auth-merger"
+
+			clientset := fake.NewSimpleClientset(&configmap)
+			authMerger := AwsAuthMerger{
+				namespace:                "aws-auth-merger",
+				sourceDirectory:          directory,
+				conflictStrategy:         conflictStrategyFail,
+				quarantineInvalidSources: tc.quarantine,
+				clientset:                clientset,
+				ctx:                      context.Background(),
+				logger:                   logrus.New(),
+			}
+			err := authMerger.syncAwsAuthConfigMaps()
+			if !tc.quarantine {
+				require.Error(t, err)
+				assert.IsType(t, InvalidSourceFileErr{}, errors.Unwrap(err))
+				assert.False(t, isRetriableSyncErr(err))
+				return
+			}
+			require.NoError(t, err)
+
+			main, err := clientset.CoreV1().ConfigMaps(mainAwsAuthConfigMapNamespace).Get(context.Background(), mainAwsAuthConfigMapName, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, `["team"]`, main.Annotations[sourcesAnnotationKey])
+		})
+	}
+}
+
+// Test that the watcher enqueues a sync when a file in the source directory changes.
+func TestSourceDirectoryWatcher(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	queue := newSyncQueue(time.Millisecond, 10*time.Millisecond)
+	defer queue.ShutDown()
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	sourceWatcher, err := NewSourceDirectoryWatcher(logrus.New(), directory, queue)
+	require.NoError(t, err)
+	go sourceWatcher.Run(stopChan)
+
+	writeSourceFile(t, directory, "team.yaml", teamSourceFile)
+	assert.Eventually(t, func() bool { return queue.Len() == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestValidateSourceDirectory(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	path := writeSourceFile(t, directory, "team.yaml", teamSourceFile)
+	assert.NoError(t, validateSourceDirectory(""))
+	assert.NoError(t, validateSourceDirectory(directory))
+	assert.Error(t, validateSourceDirectory(path))
+	assert.Error(t, validateSourceDirectory(filepath.Join(directory, "missing")))
+}
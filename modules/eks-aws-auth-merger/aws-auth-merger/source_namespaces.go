@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// validateSourceNamespaceSelector returns an error if the given label selector for the source Namespaces can not be
+// parsed. An empty selector is valid, and selects no Namespaces.
+func validateSourceNamespaceSelector(selector string) error {
+	if _, err := labels.Parse(selector); err != nil {
+		return errors.WithStackTrace(InvalidSourceNamespaceSelectorErr{selector, err})
+	}
+	return nil
+}
+
+// watchesMultipleNamespaces returns true if the source ConfigMaps are merged from Namespaces other than the watch
+// Namespace. In that case, the ConfigMaps are watched across the cluster and filtered by Namespace, and the names of
+// the source ConfigMaps are qualified with their Namespace, since names are only unique within a Namespace.
+func (authMerger *AwsAuthMerger) watchesMultipleNamespaces() bool {
+	return len(authMerger.sourceNamespaces) > 0 || authMerger.sourceNamespaceSelector != ""
+}
+
+// explicitSourceNamespaces returns the Namespaces that are configured by name to be watched for source ConfigMaps,
+// which always includes the watch Namespace.
+func (authMerger *AwsAuthMerger) explicitSourceNamespaces() []string {
+	namespaces := []string{authMerger.namespace}
+	for _, namespace := range authMerger.sourceNamespaces {
+		if namespace != authMerger.namespace {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// listSourceNamespaces returns the set of Namespaces to merge the source ConfigMaps from: the explicitly configured
+// Namespaces, and those that match the source Namespace selector. Once the watcher is set up, the Namespaces that
+// match the selector are read from the informer cache instead of the Kubernetes API.
+func (authMerger *AwsAuthMerger) listSourceNamespaces() (map[string]bool, error) {
+	namespaces := map[string]bool{}
+	for _, namespace := range authMerger.explicitSourceNamespaces() {
+		namespaces[namespace] = true
+	}
+	if authMerger.sourceNamespaceSelector == "" {
+		return namespaces, nil
+	}
+
+	if authMerger.namespaceLister != nil {
+		// The informer is already filtered by the selector, so everything in the cache is selected.
+		selected, err := authMerger.namespaceLister.List(labels.Everything())
+		if err != nil {
+			return nil, errors.WithStackTrace(err)
+		}
+		for _, namespace := range selected {
+			namespaces[namespace.Name] = true
+		}
+		return namespaces, nil
+	}
+
+	selected, err := authMerger.clientset.CoreV1().Namespaces().List(authMerger.ctx, metav1.ListOptions{LabelSelector: authMerger.sourceNamespaceSelector})
+	if err != nil {
+		return nil, errors.WithStackTrace(err)
+	}
+	for _, namespace := range selected.Items {
+		namespaces[namespace.Name] = true
+	}
+	return namespaces, nil
+}
+
+// filterConfigMapsByNamespace returns the ConfigMaps that are in one of the given Namespaces.
+func filterConfigMapsByNamespace(configmaps []corev1.ConfigMap, namespaces map[string]bool) []corev1.ConfigMap {
+	filtered := []corev1.ConfigMap{}
+	for _, configmap := range configmaps {
+		if namespaces[configmap.Namespace] {
+			filtered = append(filtered, configmap)
+		}
+	}
+	return filtered
+}
+
+// describeSourceNamespaces returns a human readable description of the Namespaces that the source ConfigMaps are
+// merged from, for use in logs and Events.
+func (authMerger *AwsAuthMerger) describeSourceNamespaces() string {
+	if !authMerger.watchesMultipleNamespaces() {
+		return fmt.Sprintf("Namespace %s", authMerger.namespace)
+	}
+	description := fmt.Sprintf("Namespaces %s", strings.Join(authMerger.explicitSourceNamespaces(), ", "))
+	if authMerger.sourceNamespaceSelector != "" {
+		description = fmt.Sprintf("%s and Namespaces with label selector %s", description, authMerger.sourceNamespaceSelector)
+	}
+	return description
+}
+
+// Custom errors
+
+type InvalidSourceNamespaceSelectorErr struct {
+	selector      string
+	underlyingErr error
+}
+
+func (err InvalidSourceNamespaceSelectorErr) Error() string {
+	return fmt.Sprintf("Invalid source Namespace selector %s: %s", err.selector, err.underlyingErr)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const testSourceNamespaceSelector = "aws-auth-merger.gruntwork.io/source=true"
+
+// newSourceNamespaceTestObjects returns a set of Namespaces and ConfigMaps to test merging from multiple Namespaces:
+// the watch Namespace, an explicitly configured Namespace, a Namespace that is selected by label, and one that is not
+// watched. Each Namespace has a ConfigMap named team.
+func newSourceNamespaceTestObjects(t *testing.T) ([]corev1.Namespace, []corev1.ConfigMap) {
+	namespaces := []corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "aws-auth-merger"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "explicit"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "selected", Labels: map[string]string{"aws-auth-merger.gruntwork.io/source": "true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "unwatched"}},
+	}
+	configmaps := []corev1.ConfigMap{}
+	for _, namespace := range namespaces {
+		configmap := newAwsAuthConfigMap(t, "team", "", []RoleMapping{{RoleArn: "arn:aws:iam::123456789012:role/" + namespace.Name, Username: namespace.Name}}, []UserMapping{})
+		configmap.Namespace = namespace.Name
+		configmaps = append(configmaps, configmap)
+	}
+	return namespaces, configmaps
+}
+
+// Test that the ConfigMaps are listed from the watch Namespace, the explicitly configured Namespaces, and the
+// Namespaces that match the selector, both from the Kubernetes API and from the informer cache.
+func TestListAwsAuthConfigMapsFromSourceNamespaces(t *testing.T) {
+	t.Parallel()
+
+	namespaces, configmaps := newSourceNamespaceTestObjects(t)
+	objects := []runtime.Object{}
+	namespaceIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i := range namespaces {
+		objects = append(objects, &namespaces[i])
+		if namespaces[i].Name == "selected" {
+			require.NoError(t, namespaceIndexer.Add(&namespaces[i]))
+		}
+	}
+	for i := range configmaps {
+		objects = append(objects, &configmaps[i])
+	}
+
+	testCases := []struct {
+		name      string
+		fromCache bool
+	}{
+		{"api", false},
+		{"cache", true},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			authMerger := AwsAuthMerger{
+				namespace:               "aws-auth-merger",
+				sourceNamespaces:        []string{"explicit"},
+				sourceNamespaceSelector: testSourceNamespaceSelector,
+				clientset:               fake.NewSimpleClientset(objects...),
+				ctx:                     context.Background(),
+				logger:                  logrus.New(),
+			}
+			if tc.fromCache {
+				authMerger.configMapLister = newTestConfigMapLister(t, configmaps...)
+				authMerger.namespaceLister = corelisters.NewNamespaceLister(namespaceIndexer)
+			}
+
+			listed, err := authMerger.listAwsAuthConfigMaps()
+			require.NoError(t, err)
+			listedNamespaces := []string{}
+			for _, configmap := range listed {
+				listedNamespaces = append(listedNamespaces, configmap.Namespace)
+			}
+			assert.ElementsMatch(t, []string{"aws-auth-merger", "explicit", "selected"}, listedNamespaces)
+		})
+	}
+}
+
+// Test that ConfigMaps with the same name in different Namespaces are identified by their Namespace and name when
+// merging from multiple Namespaces.
+func TestMergeAwsAuthConfigMapsQualifiedSourceNames(t *testing.T) {
+	t.Parallel()
+
+	_, configmaps := newSourceNamespaceTestObjects(t)
+	configmaps[1].Data[mapRolesKey] = "- rolearn: [not, a, string"
+
+	result, err := mergeAwsAuthConfigMaps(configmaps, mergeOptions{conflictStrategy: conflictStrategyFail, quarantineInvalid: true, qualifySourceNames: true})
+	require.NoError(t, err)
+	assert.Equal(t, `["aws-auth-merger/team","selected/team","unwatched/team"]`, result.merged.Annotations[sourcesAnnotationKey])
+	require.Contains(t, result.rejected, "explicit/team")
+	assert.Contains(t, result.rejected["explicit/team"].Error(), "explicit/team")
+	assert.Contains(t, result.accepted, "selected/team")
+
+	_, err = mergeAwsAuthConfigMaps(configmaps, mergeOptions{conflictStrategy: conflictStrategyFail, qualifySourceNames: true})
+	invalidErr, isInvalid := errors.Unwrap(err).(InvalidMappingListErr)
+	require.True(t, isInvalid)
+	assert.Equal(t, "explicit/team", invalidErr.configMapName)
+}
+
+// Test that the watcher only enqueues syncs for ConfigMaps in the source Namespaces, and when a Namespace starts
+// matching the selector.
+func TestConfigMapWatchControllerSourceNamespaces(t *testing.T) {
+	t.Parallel()
+
+	namespaces, configmaps := newSourceNamespaceTestObjects(t)
+	objects := []runtime.Object{}
+	for i := range namespaces {
+		objects = append(objects, &namespaces[i])
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+
+	queue := newSyncQueue(time.Millisecond, 10*time.Millisecond)
+	defer queue.ShutDown()
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	controller := NewConfigMapWatchController(logrus.New(), clientset, []string{"aws-auth-merger", "explicit"}, "", testSourceNamespaceSelector, false, nil, queue)
+	require.NoError(t, controller.Run(stopChan))
+
+	for _, configmap := range configmaps {
+		expected := configmap.Namespace != "unwatched"
+		assert.Equal(t, expected, controller.isInSourceNamespace(&configmap), configmap.Namespace)
+	}
+	selected, err := controller.NamespaceLister().List(labels.Everything())
+	require.NoError(t, err)
+	require.Len(t, selected, 1)
+	assert.Equal(t, "selected", selected[0].Name)
+
+	// Wait for the initial events to be processed, so that the queue is empty.
+	assert.Eventually(t, func() bool { return queue.Len() == 1 }, 5*time.Second, 10*time.Millisecond)
+	key, _ := queue.Get()
+	queue.Forget(key)
+	queue.Done(key)
+
+	unwatched := configmaps[3]
+	_, err = clientset.CoreV1().ConfigMaps(unwatched.Namespace).Create(context.Background(), &unwatched, metav1.CreateOptions{})
+	require.NoError(t, err)
+	time.Sleep(2 * syncDebounceInterval)
+	assert.Equal(t, 0, queue.Len())
+
+	labeled := namespaces[3].DeepCopy()
+	labeled.Labels = map[string]string{"aws-auth-merger.gruntwork.io/source": "true"}
+	_, err = clientset.CoreV1().Namespaces().Update(context.Background(), labeled, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return queue.Len() == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, controller.isInSourceNamespace(&unwatched))
+}
+
+func TestValidateSourceNamespaceSelector(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateSourceNamespaceSelector(""))
+	assert.NoError(t, validateSourceNamespaceSelector(testSourceNamespaceSelector))
+	err := validateSourceNamespaceSelector("team in (a")
+	_, isInvalid := errors.Unwrap(err).(InvalidSourceNamespaceSelectorErr)
+	assert.True(t, isInvalid)
+}
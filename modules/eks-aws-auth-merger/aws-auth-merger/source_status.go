@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/hashicorp/go-multierror"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// These annotations are set by the merger on the source ConfigMaps that were merged into the main aws-auth
+	// ConfigMap, to record when they were last merged, the content hash of the data that was merged, and how many of
+	// their role, user, and account mappings were accepted. They are removed when the ConfigMap is quarantined, in which
+	// case the rejected annotation is set instead.
+	sourceMergedTimestampAnnotationKey   = "gruntwork.io/aws-auth-merger-merged-timestamp"
+	sourceMergedHashAnnotationKey        = "gruntwork.io/aws-auth-merger-merged-hash"
+	acceptedRoleMappingsAnnotationKey    = "gruntwork.io/aws-auth-merger-accepted-role-mappings"
+	acceptedUserMappingsAnnotationKey    = "gruntwork.io/aws-auth-merger-accepted-user-mappings"
+	acceptedAccountMappingsAnnotationKey = "gruntwork.io/aws-auth-merger-accepted-account-mappings"
+)
+
+// updateSourceStatusAnnotations records the outcome of the merge on each of the source ConfigMaps: the status
+// annotations are set on the ConfigMaps that were merged, and the rejected annotation is set on the ConfigMaps that
+// were quarantined, with the reason they were excluded from the merge. Sources that were read from Secrets are patched
+// on the Secret, and IAMIdentityMappings report the outcome in their status subresource instead of annotations. Files
+// in the source directory are left untouched, as the merger only reads them. This should only be called once the
+// merged ConfigMap was written to the main aws-auth ConfigMap.
+//
+// The ConfigMaps are only patched when the status changes, so that we don't trigger a new sync from the watcher every
+// time we sync. For the same reason, the merged timestamp is only updated when the merged content changes. A source
+// that fails to update does not stop the others from being updated: the errors are collected and returned together.
+func (authMerger *AwsAuthMerger) updateSourceStatusAnnotations(configmaps []corev1.ConfigMap, result mergeResult) error {
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	var allErrs *multierror.Error
+	for _, configmap := range configmaps {
+		if isFileSource(configmap) {
+			continue
+		}
+		name := authMerger.mergeOptions().sourceName(configmap)
+		var err error
+		if isIAMIdentityMappingSource(configmap) {
+			err = authMerger.updateIAMIdentityMappingStatus(configmap, name, result)
+		} else {
+			err = authMerger.patchSourceStatusAnnotations(configmap, name, result, now)
+		}
+		if err != nil {
+			allErrs = multierror.Append(allErrs, fmt.Errorf("%s: %s", name, err))
+		}
+	}
+	return allErrs.ErrorOrNil()
+}
+
+// patchSourceStatusAnnotations patches the status annotations on the given source ConfigMap, or the Secret it was read
+// from, if they changed. The merger is not allowed to patch the ConfigMaps in Namespaces selected by label unless it
+// was granted patch access across the cluster, so a patch that is forbidden is skipped instead of returning an error.
+func (authMerger *AwsAuthMerger) patchSourceStatusAnnotations(configmap corev1.ConfigMap, name string, result mergeResult, now string) error {
+	changes := sourceStatusChanges(configmap, name, result, now)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": changes,
+		},
+	})
+	if err != nil {
+		return errors.WithStackTrace(err)
+	}
+	if isSecretSource(configmap) {
+		_, err = authMerger.clientset.CoreV1().Secrets(configmap.Namespace).Patch(authMerger.ctx, configmap.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	} else {
+		_, err = authMerger.clientset.CoreV1().ConfigMaps(configmap.Namespace).Patch(authMerger.ctx, configmap.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	}
+	if k8serrors.IsForbidden(err) {
+		authMerger.logger.Debugf("Not allowed to record the merge status on %s: %s", name, err)
+		return nil
+	}
+	return errors.WithStackTrace(err)
+}
+
+// sourceStatusChanges returns the status annotations that need to change on the given source ConfigMap, identified by
+// the given source name in the merge result, to reflect the merge result. The given timestamp is used as the merged
+// timestamp if the merged content changed. Annotations to remove are set to nil, which removes them in a merge patch.
+// Returns an empty map if the status is up to date.
+func sourceStatusChanges(configmap corev1.ConfigMap, name string, result mergeResult, now string) map[string]*string {
+	desired := map[string]*string{
+		rejectedAnnotationKey:                nil,
+		sourceMergedTimestampAnnotationKey:   nil,
+		sourceMergedHashAnnotationKey:        nil,
+		acceptedRoleMappingsAnnotationKey:    nil,
+		acceptedUserMappingsAnnotationKey:    nil,
+		acceptedAccountMappingsAnnotationKey: nil,
+	}
+	if rejectErr, isRejected := result.rejected[name]; isRejected {
+		reason := rejectErr.Error()
+		desired[rejectedAnnotationKey] = &reason
+	} else if accepted, isAccepted := result.accepted[name]; isAccepted {
+		hash := hashConfigMapData(configmap.Data)
+		roles := strconv.Itoa(accepted.roles)
+		users := strconv.Itoa(accepted.users)
+		accounts := strconv.Itoa(accepted.accounts)
+		desired[sourceMergedHashAnnotationKey] = &hash
+		desired[acceptedRoleMappingsAnnotationKey] = &roles
+		desired[acceptedUserMappingsAnnotationKey] = &users
+		desired[acceptedAccountMappingsAnnotationKey] = &accounts
+
+		// Keep the existing timestamp if the merged content did not change since it was recorded.
+		timestamp := now
+		if currentTimestamp, hasTimestamp := configmap.Annotations[sourceMergedTimestampAnnotationKey]; hasTimestamp && configmap.Annotations[sourceMergedHashAnnotationKey] == hash {
+			timestamp = currentTimestamp
+		}
+		desired[sourceMergedTimestampAnnotationKey] = &timestamp
+	}
+
+	changes := map[string]*string{}
+	for key, value := range desired {
+		current, hasCurrent := configmap.Annotations[key]
+		switch {
+		case value == nil && hasCurrent:
+			changes[key] = nil
+		case value != nil && (!hasCurrent || current != *value):
+			changes[key] = value
+		}
+	}
+	return changes
+}
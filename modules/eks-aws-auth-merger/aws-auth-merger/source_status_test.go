@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Test that the accepted mapping counts only include the mappings that made it into the merged ConfigMap.
+func TestMergeAwsAuthConfigMapsAcceptedCounts(t *testing.T) {
+	t.Parallel()
+
+	sameUsername := adminRoleMapping
+	sameUsername.Groups = []string{"viewers"}
+	userMapping := UserMapping{UserArn: "arn:aws:iam::123456789012:user/alice", Username: "alice", Groups: []string{"viewers"}}
+
+	testCases := []struct {
+		name             string
+		strategy         conflictStrategy
+		expectedAccepted map[string]acceptedMappingCounts
+	}{
+		{
+			"skipLater",
+			conflictStrategySkipLater,
+			map[string]acceptedMappingCounts{"team-a": {roles: 1, users: 0, accounts: 1}, "team-b": {roles: 1, users: 1, accounts: 1}},
+		},
+		{
+			"unionGroups",
+			conflictStrategyUnionGroups,
+			map[string]acceptedMappingCounts{"team-a": {roles: 1, users: 0, accounts: 1}, "team-b": {roles: 2, users: 1, accounts: 2}},
+		},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			teamA := newAwsAuthConfigMap(t, "team-a", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+			teamA.Data[mapAccountsKey] = "- \"111111111111\"\n"
+			teamB := newAwsAuthConfigMap(t, "team-b", "", []RoleMapping{sameUsername, deployRoleMapping}, []UserMapping{userMapping})
+			teamB.Data[mapAccountsKey] = "- \"111111111111\"\n- \"222222222222\"\n"
+			result, err := mergeAwsAuthConfigMaps([]corev1.ConfigMap{teamA, teamB}, mergeOptions{conflictStrategy: tc.strategy})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedAccepted, result.accepted)
+		})
+	}
+}
+
+// Test that syncing records the merge status on the source ConfigMaps, and only patches them when the status changes.
+func TestSyncSourceStatusAnnotations(t *testing.T) {
+	t.Parallel()
+
+	valid := newAwsAuthConfigMap(t, "valid", "", []RoleMapping{adminRoleMapping, deployRoleMapping}, []UserMapping{})
+	valid.Namespace = "aws-auth-merger"
+	// The valid ConfigMap was quarantined before, so the rejected annotation should be cleared.
+	valid.Annotations = map[string]string{rejectedAnnotationKey: "was invalid"}
+	invalid := newAwsAuthConfigMap(t, "invalid", "", []RoleMapping{}, []UserMapping{})
+	invalid.Namespace = "aws-auth-merger"
+	invalid.Data[mapRolesKey] = "- rolearn: [not, a, string"
+	// The invalid ConfigMap was merged before, so the status annotations should be cleared.
+	invalid.Annotations = map[string]string{
+		sourceMergedTimestampAnnotationKey:   "2021-01-01T00:00:00Z",
+		sourceMergedHashAnnotationKey:        "1234",
+		acceptedRoleMappingsAnnotationKey:    "1",
+		acceptedUserMappingsAnnotationKey:    "0",
+		acceptedAccountMappingsAnnotationKey: "0",
+	}
+
+	clientset := fake.NewSimpleClientset(&valid, &invalid)
+	authMerger := AwsAuthMerger{
+		namespace:                "aws-auth-merger",
+		conflictStrategy:         conflictStrategyFail,
+		quarantineInvalidSources: true,
+		clientset:                clientset,
+		ctx:                      context.Background(),
+		logger:                   logrus.New(),
+	}
+	require.NoError(t, authMerger.syncAwsAuthConfigMaps())
+
+	updatedValid, err := clientset.CoreV1().ConfigMaps("aws-auth-merger").Get(context.Background(), "valid", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, updatedValid.Annotations, rejectedAnnotationKey)
+	assert.Equal(t, hashConfigMapData(valid.Data), updatedValid.Annotations[sourceMergedHashAnnotationKey])
+	assert.Equal(t, "2", updatedValid.Annotations[acceptedRoleMappingsAnnotationKey])
+	assert.Equal(t, "0", updatedValid.Annotations[acceptedUserMappingsAnnotationKey])
+	assert.Equal(t, "0", updatedValid.Annotations[acceptedAccountMappingsAnnotationKey])
+	mergedTimestamp := updatedValid.Annotations[sourceMergedTimestampAnnotationKey]
+	assert.NotEmpty(t, mergedTimestamp)
+
+	updatedInvalid, err := clientset.CoreV1().ConfigMaps("aws-auth-merger").Get(context.Background(), "invalid", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, updatedInvalid.Annotations, rejectedAnnotationKey)
+	assert.NotContains(t, updatedInvalid.Annotations, sourceMergedTimestampAnnotationKey)
+	assert.NotContains(t, updatedInvalid.Annotations, sourceMergedHashAnnotationKey)
+	assert.NotContains(t, updatedInvalid.Annotations, acceptedRoleMappingsAnnotationKey)
+	assert.NotContains(t, updatedInvalid.Annotations, acceptedUserMappingsAnnotationKey)
+	assert.NotContains(t, updatedInvalid.Annotations, acceptedAccountMappingsAnnotationKey)
+
+	// Syncing again without changes does not patch the source ConfigMaps.
+	clientset.ClearActions()
+	require.NoError(t, authMerger.syncAwsAuthConfigMaps())
+	for _, action := range clientset.Actions() {
+		assert.False(t, action.Matches("patch", "configmaps"), "unexpected patch of ConfigMap %v", action)
+	}
+}
+
+// Test that failing to patch one source ConfigMap does not stop the others from being patched, and does not fail the
+// sync, since the main aws-auth ConfigMap was already written. Patches that are forbidden by RBAC are skipped.
+func TestSyncSourceStatusAnnotationsPatchError(t *testing.T) {
+	t.Parallel()
+
+	admin := newAwsAuthConfigMap(t, "admin", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	admin.Namespace = "aws-auth-merger"
+	deploy := newAwsAuthConfigMap(t, "deploy", "", []RoleMapping{deployRoleMapping}, []UserMapping{})
+	deploy.Namespace = "aws-auth-merger"
+	forbidden := newAwsAuthConfigMap(t, "forbidden", "", []RoleMapping{opsRoleMapping}, []UserMapping{})
+	forbidden.Namespace = "aws-auth-merger"
+
+	clientset := fake.NewSimpleClientset(&admin, &deploy, &forbidden)
+	clientset.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		switch action.(k8stesting.PatchAction).GetName() {
+		case "admin":
+			return true, nil, fmt.Errorf("injected patch error")
+		case "forbidden":
+			return true, nil, k8serrors.NewForbidden(corev1.Resource("configmaps"), "forbidden", fmt.Errorf("injected forbidden error"))
+		}
+		return false, nil, nil
+	})
+	authMerger := AwsAuthMerger{
+		namespace:        "aws-auth-merger",
+		conflictStrategy: conflictStrategyFail,
+		clientset:        clientset,
+		ctx:              context.Background(),
+		logger:           logrus.New(),
+	}
+	configmaps, err := authMerger.listAwsAuthConfigMaps()
+	require.NoError(t, err)
+	result, err := mergeAwsAuthConfigMaps(configmaps, authMerger.mergeOptions())
+	require.NoError(t, err)
+
+	err = authMerger.updateSourceStatusAnnotations(configmaps, result)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "admin")
+	assert.NotContains(t, err.Error(), "forbidden")
+	updatedDeploy, err := clientset.CoreV1().ConfigMaps("aws-auth-merger").Get(context.Background(), "deploy", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "1", updatedDeploy.Annotations[acceptedRoleMappingsAnnotationKey])
+
+	require.NoError(t, authMerger.syncAwsAuthConfigMaps())
+}
+
+// Test that the merged timestamp is only updated when the merged content of the source ConfigMap changes.
+func TestSourceStatusChangesTimestamp(t *testing.T) {
+	t.Parallel()
+
+	source := newAwsAuthConfigMap(t, "team-a", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+	result := mergeResult{
+		rejected: map[string]error{},
+		accepted: map[string]acceptedMappingCounts{"team-a": {roles: 1, users: 0}},
+	}
+	source.Annotations = map[string]string{
+		sourceMergedTimestampAnnotationKey:   "2021-01-01T00:00:00Z",
+		sourceMergedHashAnnotationKey:        hashConfigMapData(source.Data),
+		acceptedRoleMappingsAnnotationKey:    "1",
+		acceptedUserMappingsAnnotationKey:    "0",
+		acceptedAccountMappingsAnnotationKey: "0",
+	}
+	assert.Empty(t, sourceStatusChanges(source, "team-a", result, "2021-02-01T00:00:00Z"))
+
+	source.Data[mapRolesKey] = ""
+	changes := sourceStatusChanges(source, "team-a", result, "2021-02-01T00:00:00Z")
+	require.Contains(t, changes, sourceMergedTimestampAnnotationKey)
+	assert.Equal(t, "2021-02-01T00:00:00Z", *changes[sourceMergedTimestampAnnotationKey])
+	require.Contains(t, changes, sourceMergedHashAnnotationKey)
+	assert.Equal(t, hashConfigMapData(source.Data), *changes[sourceMergedHashAnnotationKey])
+}
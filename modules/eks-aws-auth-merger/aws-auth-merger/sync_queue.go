@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// newSyncQueue returns a workqueue for scheduling syncs, which retries failed syncs with a delay that doubles from the
+// base delay up to the max delay.
+func newSyncQueue(baseDelay time.Duration, maxDelay time.Duration) workqueue.RateLimitingInterface {
+	return workqueue.NewNamedRateLimitingQueue(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		commandName,
+	)
+}
+
+// validateSyncRetry returns an error if the given retry settings for failed syncs are invalid: both delays must be
+// positive, the base delay can not be longer than the max delay, and the max number of retries can not be negative.
+// Setting the max number of retries to 0 disables the retries.
+func validateSyncRetry(baseDelay time.Duration, maxDelay time.Duration, maxRetries int) error {
+	if baseDelay <= 0 || maxDelay <= 0 || baseDelay > maxDelay {
+		return errors.WithStackTrace(InvalidSyncRetryDelayErr{baseDelay: baseDelay, maxDelay: maxDelay})
+	}
+	if maxRetries < 0 {
+		return errors.WithStackTrace(InvalidSyncMaxRetriesErr(maxRetries))
+	}
+	return nil
+}
+
+// processNextSync waits for the next sync to be scheduled on the queue and runs it. Failed syncs are rescheduled with
+// backoff, unless the error can not be fixed by retrying or the max number of retries is reached. In that case, the
+// merger waits for the next change to the ConfigMaps or the next refresh interval. Returns false when the queue is
+// shut down, or when the given context is done. The queue still hands out the syncs that were enqueued before it was
+// shut down, so the context is checked before starting each sync to avoid starting new syncs while shutting down.
+func (authMerger *AwsAuthMerger) processNextSync(ctx context.Context, queue workqueue.RateLimitingInterface) bool {
+	key, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+	defer queue.Done(key)
+	if ctx.Err() != nil {
+		return false
+	}
+
+	start := time.Now()
+	err := authMerger.syncAwsAuthConfigMaps()
+	authMerger.metrics.observeSync(err, time.Since(start))
+	authMerger.health.recordSync(err)
+	switch {
+	case err == nil:
+		queue.Forget(key)
+	case !isRetriableSyncErr(err):
+		authMerger.logger.Errorf("Error while syncing aws-auth ConfigMaps that will not be fixed by retrying. Waiting for the next change or refresh interval: %s", err)
+		queue.Forget(key)
+	case queue.NumRequeues(key) >= authMerger.syncMaxRetries:
+		authMerger.logger.Errorf("Error while syncing aws-auth ConfigMaps. Giving up after %d retries, and waiting for the next change or refresh interval: %s", authMerger.syncMaxRetries, err)
+		queue.Forget(key)
+	default:
+		authMerger.logger.Warnf("Error while syncing aws-auth ConfigMaps (retry %d of %d): %s", queue.NumRequeues(key)+1, authMerger.syncMaxRetries, err)
+		queue.AddRateLimited(key)
+	}
+	return true
+}
+
+// isRetriableSyncErr returns true if the given error from syncing may be transient, such as an error from the
+// Kubernetes API. Errors in the content of the ConfigMaps can only be fixed by changing the ConfigMaps, which triggers
+// a new sync, so there is no point in retrying them.
+func isRetriableSyncErr(err error) bool {
+	switch errors.Unwrap(err).(type) {
+	case MappingConflictErr, InvalidMappingListErr, InvalidPriorityErr, InvalidSourceFileErr, LockoutGuardErr:
+		return false
+	default:
+		return true
+	}
+}
+
+// Custom errors
+
+type InvalidSyncRetryDelayErr struct {
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+func (err InvalidSyncRetryDelayErr) Error() string {
+	return fmt.Sprintf("Invalid sync retry delays: base delay %s and max delay %s. Both must be positive, and the base delay can not be longer than the max delay.", err.baseDelay, err.maxDelay)
+}
+
+type InvalidSyncMaxRetriesErr int
+
+func (err InvalidSyncMaxRetriesErr) Error() string {
+	return fmt.Sprintf("Invalid sync max retries %d. Must be at least 0.", int(err))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/gruntwork-cli/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Test that processNextSync retries transient errors with backoff up to the max number of retries, and does not retry
+// errors in the content of the ConfigMaps.
+func TestProcessNextSync(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		injectListErr    bool
+		invalidSource    bool
+		expectedAttempts int
+	}{
+		{"success", false, false, 1},
+		{"transientError", true, false, 3},
+		{"invalidSource", false, true, 1},
+	}
+
+	for _, tc := range testCases {
+		// Capture range variable to bring it in scope within the for loop to avoid it changing
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			source := newAwsAuthConfigMap(t, "source", "", []RoleMapping{adminRoleMapping}, []UserMapping{})
+			source.Namespace = "aws-auth-merger"
+			if tc.invalidSource {
+				source.Data[mapRolesKey] = "- rolearn: [not, a, string"
+			}
+			clientset := fake.NewSimpleClientset(&source)
+			listAttempts := 0
+			clientset.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				listAttempts++
+				if tc.injectListErr {
+					return true, nil, fmt.Errorf("injected list error")
+				}
+				return false, nil, nil
+			})
+			authMerger := AwsAuthMerger{
+				namespace:      "aws-auth-merger",
+				syncMaxRetries: 2,
+				clientset:      clientset,
+				ctx:            context.Background(),
+				logger:         logrus.New(),
+			}
+
+			queue := newSyncQueue(time.Millisecond, 10*time.Millisecond)
+			defer queue.ShutDown()
+			queue.Add(syncQueueKey)
+			for i := 0; i < tc.expectedAttempts; i++ {
+				require.True(t, authMerger.processNextSync(context.Background(), queue))
+			}
+			assert.Equal(t, tc.expectedAttempts, listAttempts)
+
+			// Once the sync succeeds or the merger gives up, nothing is left in the queue, and the retries are reset.
+			time.Sleep(50 * time.Millisecond)
+			assert.Equal(t, 0, queue.Len())
+			assert.Equal(t, 0, queue.NumRequeues(syncQueueKey))
+		})
+	}
+}
+
+// Test that processNextSync does not start the syncs that are still in the queue once the context is done, since the
+// queue keeps handing them out after it is shut down.
+func TestProcessNextSyncStopsWhenContextDone(t *testing.T) {
+	t.Parallel()
+
+	clientset := fake.NewSimpleClientset()
+	listAttempts := 0
+	clientset.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		listAttempts++
+		return false, nil, nil
+	})
+	authMerger := AwsAuthMerger{
+		namespace: "aws-auth-merger",
+		clientset: clientset,
+		ctx:       context.Background(),
+		logger:    logrus.New(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	queue := newSyncQueue(time.Millisecond, 10*time.Millisecond)
+	queue.Add(syncQueueKey)
+	queue.ShutDown()
+	assert.False(t, authMerger.processNextSync(ctx, queue))
+	assert.Equal(t, 0, listAttempts)
+}
+
+func TestValidateSyncRetry(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateSyncRetry(time.Second, 5*time.Minute, 10))
+	assert.NoError(t, validateSyncRetry(time.Second, time.Second, 0))
+
+	for _, delays := range [][2]time.Duration{{0, time.Minute}, {time.Second, 0}, {-time.Second, time.Minute}, {time.Minute, time.Second}} {
+		err := validateSyncRetry(delays[0], delays[1], 10)
+		_, isInvalid := errors.Unwrap(err).(InvalidSyncRetryDelayErr)
+		assert.True(t, isInvalid, "expected invalid delays for base %s and max %s", delays[0], delays[1])
+	}
+
+	err := validateSyncRetry(time.Second, time.Minute, -1)
+	_, isInvalid := errors.Unwrap(err).(InvalidSyncMaxRetriesErr)
+	assert.True(t, isInvalid)
+}
+
+func TestIsRetriableSyncErr(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, isRetriableSyncErr(fmt.Errorf("connection refused")))
+	assert.False(t, isRetriableSyncErr(MappingConflictErr{mappingType: roleMappingType, arn: "asdf"}))
+	assert.False(t, isRetriableSyncErr(LockoutGuardErr{}))
+	assert.False(t, isRetriableSyncErr(InvalidSourceFileErr{"/sources/team.yaml", fmt.Errorf("invalid")}))
+
+	_, err := mergeAwsAuthConfigMaps(
+		[]corev1.ConfigMap{newAwsAuthConfigMap(t, "invalid", "high", []RoleMapping{}, []UserMapping{})},
+		mergeOptions{conflictStrategy: conflictStrategyPriority},
+	)
+	require.Error(t, err)
+	assert.False(t, isRetriableSyncErr(err))
+}
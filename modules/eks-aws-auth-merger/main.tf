@@ -36,6 +36,37 @@ locals {
     ? kubernetes_namespace.aws_auth_merger[0].metadata[0].name
     : var.namespace
   )
+
+  # The aws-auth-merger watches ConfigMaps across the cluster when merging from Namespaces other than its own, which
+  # needs cluster-scoped RBAC.
+  watches_multiple_namespaces = length(var.source_namespaces) > 0 || var.source_namespace_selector != ""
+
+  # The source Namespaces other than the merger Namespace, which each need a Role that grants patch access to the source
+  # ConfigMaps to record the merge status on them.
+  explicit_source_namespaces = [for namespace in var.source_namespaces : namespace if namespace != var.namespace]
+
+  # The Namespaces other than the merger Namespace that the aws-auth-merger merges Secrets from, which each need a Role
+  # that grants access to the Secrets.
+  secret_source_namespaces = (
+    var.watch_secrets
+    ? [for namespace in var.source_namespaces : namespace if namespace != var.namespace]
+    : []
+  )
+
+  # Only one replica may sync the aws-auth ConfigMap at a time, so leader election is always enabled when running more
+  # than one replica.
+  enable_leader_election = var.enable_leader_election || var.deployment_replicas > 1
+
+  # Annotations that tell Prometheus to scrape the metrics endpoint of the aws-auth-merger Pods.
+  prometheus_scrape_annotations = (
+    var.metrics_port != 0 && var.enable_prometheus_scrape_annotations
+    ? {
+      "prometheus.io/scrape" = "true"
+      "prometheus.io/port"   = tostring(var.metrics_port)
+      "prometheus.io/path"   = "/metrics"
+    }
+    : {}
+  )
 }
 
 resource "kubernetes_namespace" "aws_auth_merger" {
@@ -82,7 +113,7 @@ resource "kubernetes_deployment" "aws_auth_merger" {
   }
 
   spec {
-    replicas = 1
+    replicas = var.deployment_replicas
 
     selector {
       match_labels = {
@@ -98,12 +129,13 @@ resource "kubernetes_deployment" "aws_auth_merger" {
           },
           var.pod_labels,
         )
-        annotations = var.pod_annotations
+        annotations = merge(local.prometheus_scrape_annotations, var.pod_annotations)
       }
 
       spec {
-        service_account_name            = kubernetes_service_account.aws_auth_merger[0].metadata[0].name
-        automount_service_account_token = true
+        service_account_name             = kubernetes_service_account.aws_auth_merger[0].metadata[0].name
+        automount_service_account_token  = true
+        termination_grace_period_seconds = var.termination_grace_period_seconds
         container {
           name  = "aws-auth-merger"
           image = "${var.aws_auth_merger_image.repo}:${var.aws_auth_merger_image.tag}"
@@ -113,12 +145,87 @@ resource "kubernetes_deployment" "aws_auth_merger" {
               "--watch-namespace", local.namespace_name,
               "--watch-label-selector", var.configmap_label_selector,
               "--refresh-interval", var.refresh_interval,
+              "--sync-retry-base-delay", var.sync_retry_base_delay,
+              "--sync-retry-max-delay", var.sync_retry_max_delay,
+              "--sync-max-retries", tostring(var.sync_max_retries),
+              "--conflict-strategy", var.conflict_strategy,
+              "--drift-policy", var.drift_policy,
+              "--removal-grace-period", var.removal_grace_period,
+              "--min-node-role-mappings", tostring(var.min_node_role_mappings),
+              "--max-removal-fraction", tostring(var.max_removal_fraction),
+              "--shutdown-timeout", var.shutdown_timeout,
+              "--metrics-port", tostring(var.metrics_port),
+              "--health-port", tostring(var.health_port),
+              "--liveness-interval-multiplier", tostring(var.liveness_interval_multiplier),
             ],
             flatten([
               for key, val in var.autocreate_labels :
               ["--autocreate-labels", "${key}=${val}"]
             ]),
+            flatten([
+              for arn in var.must_keep_arns :
+              ["--must-keep-arns", arn]
+            ]),
+            flatten([
+              for namespace in var.source_namespaces :
+              ["--source-namespace", namespace]
+            ]),
+            var.source_namespace_selector != "" ? ["--source-namespace-selector", var.source_namespace_selector] : [],
+            var.watch_secrets ? ["--watch-secrets"] : [],
+            var.watch_iam_identity_mappings ? ["--watch-iam-identity-mappings"] : [],
+            var.quarantine_invalid_sources ? ["--quarantine-invalid-sources"] : [],
+            var.verify_informer_cache ? ["--verify-informer-cache"] : [],
+            var.adopt_eks_node_mappings ? [] : ["--adopt-eks-node-mappings=false"],
+            local.enable_leader_election ? [
+              "--leader-elect",
+              "--leader-election-lease-name", var.leader_election_lease_name,
+              "--leader-election-namespace", local.namespace_name,
+              "--leader-election-lease-duration", var.leader_election_lease_duration,
+              "--leader-election-renew-deadline", var.leader_election_renew_deadline,
+              "--leader-election-retry-period", var.leader_election_retry_period,
+            ] : [],
           )
+
+          dynamic "port" {
+            for_each = var.metrics_port != 0 ? [var.metrics_port] : []
+            content {
+              name           = "metrics"
+              container_port = port.value
+            }
+          }
+
+          dynamic "port" {
+            for_each = var.health_port != 0 ? [var.health_port] : []
+            content {
+              name           = "health"
+              container_port = port.value
+            }
+          }
+
+          # The liveness probe fails when no sync has succeeded for liveness_interval_multiplier refresh intervals, so
+          # the probe itself can be infrequent.
+          dynamic "liveness_probe" {
+            for_each = var.health_port != 0 ? [var.health_port] : []
+            content {
+              http_get {
+                path = "/healthz"
+                port = liveness_probe.value
+              }
+              period_seconds    = 30
+              failure_threshold = 3
+            }
+          }
+
+          dynamic "readiness_probe" {
+            for_each = var.health_port != 0 ? [var.health_port] : []
+            content {
+              http_get {
+                path = "/readyz"
+                port = readiness_probe.value
+              }
+              period_seconds = 5
+            }
+          }
         }
       }
     }
@@ -130,8 +237,16 @@ resource "kubernetes_deployment" "aws_auth_merger" {
 # Create a ServiceAccount in the specified Namespace and bind the required permissions needed by the aws-auth-merger
 # app.
 # The permissions are:
-# - get, list, watch, create ConfigMaps in the aws-auth-merger namespace
-# - get, create, update in the kube-system namespace for the aws-auth ConfigMap
+# - get, list, watch, create, update, patch ConfigMaps in the aws-auth-merger namespace
+# - get, create, update Leases in the aws-auth-merger namespace for leader election
+# - get, list, watch, create, update in the kube-system namespace for the aws-auth and aws-auth-provenance ConfigMaps
+# - create, patch Events in the aws-auth-merger and kube-system namespaces to report the outcome of the merge
+# - get, list, watch ConfigMaps and create, patch Events in all namespaces, and list, watch Namespaces, when merging
+#   ConfigMaps from namespaces other than the aws-auth-merger namespace
+# - patch ConfigMaps in each of the source namespaces, and in all namespaces if allow_cluster_wide_configmap_patch is
+#   set along with source_namespace_selector, to record the merge status on the source ConfigMaps
+# - get, list, watch, patch Secrets in the aws-auth-merger namespace and each of the source namespaces, when merging
+#   Secrets
 # ---------------------------------------------------------------------------------------------------------------------
 
 resource "kubernetes_service_account" "aws_auth_merger" {
@@ -157,7 +272,51 @@ resource "kubernetes_role" "aws_auth_merger_namespace" {
   rule {
     api_groups = [""]
     resources  = ["configmaps"]
-    verbs      = ["get", "list", "watch", "create"]
+    verbs      = ["get", "list", "watch", "create", "update", "patch"]
+  }
+
+  rule {
+    api_groups = [""]
+    resources  = ["events"]
+    verbs      = ["create", "patch"]
+  }
+
+  # Patch is needed to record the merge status on the source Secrets.
+  dynamic "rule" {
+    for_each = var.watch_secrets ? ["once"] : []
+    content {
+      api_groups = [""]
+      resources  = ["secrets"]
+      verbs      = ["get", "list", "watch", "patch"]
+    }
+  }
+
+  dynamic "rule" {
+    for_each = var.watch_iam_identity_mappings ? ["once"] : []
+    content {
+      api_groups = ["aws-auth-merger.gruntwork.io"]
+      resources  = ["iamidentitymappings"]
+      verbs      = ["get", "list", "watch"]
+    }
+  }
+
+  # The merger reports whether each IAMIdentityMapping is live in the status subresource.
+  dynamic "rule" {
+    for_each = var.watch_iam_identity_mappings ? ["once"] : []
+    content {
+      api_groups = ["aws-auth-merger.gruntwork.io"]
+      resources  = ["iamidentitymappings/status"]
+      verbs      = ["patch"]
+    }
+  }
+
+  dynamic "rule" {
+    for_each = local.enable_leader_election ? ["once"] : []
+    content {
+      api_groups = ["coordination.k8s.io"]
+      resources  = ["leases"]
+      verbs      = ["get", "create", "update"]
+    }
   }
 }
 
@@ -173,8 +332,8 @@ resource "kubernetes_role" "kube_system_namespace" {
   rule {
     api_groups     = [""]
     resources      = ["configmaps"]
-    verbs          = ["get", "update"]
-    resource_names = ["aws-auth"]
+    verbs          = ["get", "list", "watch", "update"]
+    resource_names = ["aws-auth", "aws-auth-provenance"]
   }
 
   rule {
@@ -183,6 +342,11 @@ resource "kubernetes_role" "kube_system_namespace" {
     verbs      = ["create"]
   }
 
+  rule {
+    api_groups = [""]
+    resources  = ["events"]
+    verbs      = ["create", "patch"]
+  }
 }
 
 resource "kubernetes_role_binding" "aws_auth_merger_namespace" {
@@ -224,3 +388,163 @@ resource "kubernetes_role_binding" "kube_system_namespace" {
     namespace = local.namespace_name
   }
 }
+
+resource "kubernetes_cluster_role" "source_namespaces" {
+  count = var.create_resources && local.watches_multiple_namespaces ? 1 : 0
+  metadata {
+    # ClusterRoles are not namespaced, so we include the Namespace in the name to support multiple deployments.
+    name        = "${var.service_account_role_name}-${local.namespace_name}"
+    labels      = var.service_account_role_labels
+    annotations = var.service_account_role_annotations
+  }
+
+  # The source ConfigMaps are watched across the cluster, since an informer can only watch a single Namespace or all of
+  # them. This is read only, as patch across the cluster would also allow patching the aws-auth ConfigMap, bypassing
+  # the resource_names limit of the kube-system Role. Patch is granted with a Role in each source Namespace instead.
+  rule {
+    api_groups = [""]
+    resources  = ["configmaps"]
+    verbs      = ["get", "list", "watch"]
+  }
+
+  # The Namespaces selected with source_namespace_selector are not known up front, so recording the merge status on the
+  # ConfigMaps in them requires patch across the cluster, which has to be opted into.
+  dynamic "rule" {
+    for_each = var.source_namespace_selector != "" && var.allow_cluster_wide_configmap_patch ? ["once"] : []
+    content {
+      api_groups = [""]
+      resources  = ["configmaps"]
+      verbs      = ["patch"]
+    }
+  }
+
+  rule {
+    api_groups = [""]
+    resources  = ["events"]
+    verbs      = ["create", "patch"]
+  }
+
+  dynamic "rule" {
+    for_each = var.watch_iam_identity_mappings ? ["once"] : []
+    content {
+      api_groups = ["aws-auth-merger.gruntwork.io"]
+      resources  = ["iamidentitymappings"]
+      verbs      = ["get", "list", "watch"]
+    }
+  }
+
+  # The merger reports whether each IAMIdentityMapping is live in the status subresource.
+  dynamic "rule" {
+    for_each = var.watch_iam_identity_mappings ? ["once"] : []
+    content {
+      api_groups = ["aws-auth-merger.gruntwork.io"]
+      resources  = ["iamidentitymappings/status"]
+      verbs      = ["patch"]
+    }
+  }
+
+  dynamic "rule" {
+    for_each = var.source_namespace_selector != "" ? ["once"] : []
+    content {
+      api_groups = [""]
+      resources  = ["namespaces"]
+      verbs      = ["list", "watch"]
+    }
+  }
+}
+
+resource "kubernetes_cluster_role_binding" "source_namespaces" {
+  count = var.create_resources && local.watches_multiple_namespaces ? 1 : 0
+  metadata {
+    name        = "${var.service_account_role_binding_name}-${local.namespace_name}"
+    labels      = var.service_account_role_binding_labels
+    annotations = var.service_account_role_binding_annotations
+  }
+  role_ref {
+    api_group = "rbac.authorization.k8s.io"
+    kind      = "ClusterRole"
+    name      = kubernetes_cluster_role.source_namespaces[0].metadata[0].name
+  }
+  subject {
+    kind      = "ServiceAccount"
+    name      = kubernetes_service_account.aws_auth_merger[0].metadata[0].name
+    namespace = local.namespace_name
+  }
+}
+
+# Patch is needed to record the merge status on the source ConfigMaps. The ConfigMaps in the merger Namespace are covered
+# by the Role above.
+resource "kubernetes_role" "source_namespace_configmaps" {
+  for_each = var.create_resources ? toset(local.explicit_source_namespaces) : toset([])
+  metadata {
+    name        = "${var.service_account_role_name}-${local.namespace_name}-configmaps"
+    namespace   = each.key
+    labels      = var.service_account_role_labels
+    annotations = var.service_account_role_annotations
+  }
+
+  rule {
+    api_groups = [""]
+    resources  = ["configmaps"]
+    verbs      = ["patch"]
+  }
+}
+
+resource "kubernetes_role_binding" "source_namespace_configmaps" {
+  for_each = kubernetes_role.source_namespace_configmaps
+  metadata {
+    name        = "${var.service_account_role_binding_name}-${local.namespace_name}-configmaps"
+    namespace   = each.key
+    labels      = var.service_account_role_binding_labels
+    annotations = var.service_account_role_binding_annotations
+  }
+  role_ref {
+    api_group = "rbac.authorization.k8s.io"
+    kind      = "Role"
+    name      = each.value.metadata[0].name
+  }
+  subject {
+    kind      = "ServiceAccount"
+    name      = kubernetes_service_account.aws_auth_merger[0].metadata[0].name
+    namespace = local.namespace_name
+  }
+}
+
+# The source Secrets are watched in each source Namespace separately, so that the merger only gets access to the Secrets
+# in those Namespaces instead of across the cluster. The Secrets in the merger Namespace are covered by the Role above.
+# Patch is needed to record the merge status on the source Secrets.
+resource "kubernetes_role" "source_namespace_secrets" {
+  for_each = var.create_resources ? toset(local.secret_source_namespaces) : toset([])
+  metadata {
+    name        = "${var.service_account_role_name}-${local.namespace_name}-secrets"
+    namespace   = each.key
+    labels      = var.service_account_role_labels
+    annotations = var.service_account_role_annotations
+  }
+
+  rule {
+    api_groups = [""]
+    resources  = ["secrets"]
+    verbs      = ["get", "list", "watch", "patch"]
+  }
+}
+
+resource "kubernetes_role_binding" "source_namespace_secrets" {
+  for_each = kubernetes_role.source_namespace_secrets
+  metadata {
+    name        = "${var.service_account_role_binding_name}-${local.namespace_name}-secrets"
+    namespace   = each.key
+    labels      = var.service_account_role_binding_labels
+    annotations = var.service_account_role_binding_annotations
+  }
+  role_ref {
+    api_group = "rbac.authorization.k8s.io"
+    kind      = "Role"
+    name      = each.value.metadata[0].name
+  }
+  subject {
+    kind      = "ServiceAccount"
+    name      = kubernetes_service_account.aws_auth_merger[0].metadata[0].name
+    namespace = local.namespace_name
+  }
+}
@@ -32,6 +32,36 @@ variable "configmap_label_selector" {
   default     = ""
 }
 
+variable "source_namespaces" {
+  description = "Additional Namespaces to look for ConfigMaps that should be merged into the main aws-auth ConfigMap, along with those in the merger Namespace. This allows each team to keep their ConfigMap in a Namespace they own. When set, the aws-auth-merger is granted read access to ConfigMaps across the cluster, and patch access to the ConfigMaps in each of these Namespaces to record the merge status on them."
+  type        = list(string)
+  default     = []
+}
+
+variable "source_namespace_selector" {
+  description = "A Kubernetes Label Selector for additional Namespaces to look for ConfigMaps that should be merged into the main aws-auth ConfigMap, along with those in the merger Namespace (e.g. aws-auth-merger.gruntwork.io/source=true). When set, the aws-auth-merger is granted read access to ConfigMaps and Namespaces across the cluster."
+  type        = string
+  default     = ""
+}
+
+variable "allow_cluster_wide_configmap_patch" {
+  description = "When true and source_namespace_selector is set, the aws-auth-merger is granted patch access to ConfigMaps across the cluster, so that it can record the merge status annotations on the ConfigMaps in the selected Namespaces. Note that this also allows it to patch any other ConfigMap, including the aws-auth ConfigMap. When false, the merge status annotations are only recorded on the ConfigMaps in the merger Namespace and in source_namespaces."
+  type        = bool
+  default     = false
+}
+
+variable "watch_secrets" {
+  description = "When true, Kubernetes Secrets that match configmap_label_selector in the source Namespaces are merged into the main aws-auth ConfigMap along with the ConfigMaps. The mapRoles, mapUsers, and mapAccounts keys are read from the Secret data. When set, the aws-auth-merger is granted read and patch access to all Secrets in the merger Namespace and in each of the source_namespaces, with a Role in each Namespace. Can not be combined with source_namespace_selector, as that would require access to Secrets across the cluster."
+  type        = bool
+  default     = false
+}
+
+variable "watch_iam_identity_mappings" {
+  description = "When true, IAMIdentityMapping custom resources that match configmap_label_selector in the source Namespaces are merged into the main aws-auth ConfigMap along with the ConfigMaps. The CustomResourceDefinition in the crds folder of this module must be installed in the cluster before enabling this."
+  type        = bool
+  default     = false
+}
+
 variable "autocreate_labels" {
   description = "Labels to apply to ConfigMaps that are created automatically by the aws-auth-merger when snapshotting the existing main ConfigMap. This must match the label selector provided in configmap_label_selector."
   type        = map(string)
@@ -44,6 +74,98 @@ variable "refresh_interval" {
   default     = "5m"
 }
 
+variable "sync_retry_base_delay" {
+  description = "How long the aws-auth-merger waits before retrying a failed sync, as a duration string (e.g. 1s for 1 second). The delay doubles with each retry, up to sync_retry_max_delay."
+  type        = string
+  default     = "1s"
+}
+
+variable "sync_retry_max_delay" {
+  description = "The maximum delay between retries of a failed sync, as a duration string (e.g. 5m for 5 minutes)."
+  type        = string
+  default     = "5m"
+}
+
+variable "sync_max_retries" {
+  description = "How many times the aws-auth-merger retries a failed sync before giving up until the next change or refresh interval. Set to 0 to disable the retries."
+  type        = number
+  default     = 10
+
+  validation {
+    condition     = var.sync_max_retries >= 0
+    error_message = "The sync_max_retries must be at least 0."
+  }
+}
+
+variable "conflict_strategy" {
+  description = "How the aws-auth-merger handles the same IAM role or user ARN showing up in multiple ConfigMaps. Must be one of: fail (abort the merge), skip-later (keep the first mapping that was merged), priority (keep the mapping from the ConfigMap with the highest gruntwork.io/aws-auth-merger-priority annotation), union-groups (combine the groups if the usernames match)."
+  type        = string
+  default     = "fail"
+
+  validation {
+    condition     = contains(["fail", "skip-later", "priority", "union-groups"], var.conflict_strategy)
+    error_message = "The conflict_strategy must be one of: fail, skip-later, priority, union-groups."
+  }
+}
+
+variable "verify_informer_cache" {
+  description = "When true, the aws-auth-merger verifies the ConfigMaps it reads from its informer cache against a direct List call to the Kubernetes API on every sync. This adds load on the API server, so only enable it to troubleshoot syncs that miss changes."
+  type        = bool
+  default     = false
+}
+
+variable "quarantine_invalid_sources" {
+  description = "When true, ConfigMaps that can not be parsed are excluded from the merge instead of stopping all updates to the main aws-auth ConfigMap. The reason a ConfigMap was excluded is recorded in the gruntwork.io/aws-auth-merger-rejected annotation on that ConfigMap."
+  type        = bool
+  default     = false
+}
+
+variable "drift_policy" {
+  description = "What the aws-auth-merger does with entries in the central aws-auth ConfigMap that did not come from any of the ConfigMaps in the merger namespace (e.g., a Managed Node Group role added by EKS). Must be one of: revert (remove the entries), adopt (copy the entries into the adopted-aws-auth ConfigMap in the merger namespace so that they are merged in from then on), alert-only (log the entries and keep them)."
+  type        = string
+  default     = "revert"
+
+  validation {
+    condition     = contains(["revert", "adopt", "alert-only"], var.drift_policy)
+    error_message = "The drift_policy must be one of: revert, adopt, alert-only."
+  }
+}
+
+variable "adopt_eks_node_mappings" {
+  description = "When true, the worker node role mappings that EKS adds to the central aws-auth ConfigMap when creating Managed Node Groups and Fargate Profiles are copied into the eks-node-aws-auth ConfigMap in the merger namespace, regardless of the drift_policy. This prevents the workers from being locked out of the cluster."
+  type        = bool
+  default     = true
+}
+
+variable "removal_grace_period" {
+  description = "How long the aws-auth-merger keeps mappings that were removed from all the ConfigMaps in the merger namespace in the central aws-auth ConfigMap, as a duration string (e.g. 10m for 10 minutes). This protects against the window where a ConfigMap is deleted and recreated, such as when Terraform replaces it. Set to 0s to remove mappings immediately."
+  type        = string
+  default     = "0s"
+}
+
+variable "min_node_role_mappings" {
+  description = "The aws-auth-merger refuses to write a central aws-auth ConfigMap with fewer than this many worker node role mappings (role mappings with the system:nodes group). Set to 0 to disable this guard."
+  type        = number
+  default     = 0
+}
+
+variable "must_keep_arns" {
+  description = "List of IAM role ARNs, IAM user ARNs, or AWS account IDs that must be in the central aws-auth ConfigMap. The aws-auth-merger refuses to write a central aws-auth ConfigMap that does not include all of them."
+  type        = list(string)
+  default     = []
+}
+
+variable "max_removal_fraction" {
+  description = "The aws-auth-merger refuses to write a central aws-auth ConfigMap that removes more than this fraction (between 0 and 1) of the mappings it manages in a single sync. Set to 1 to disable this guard."
+  type        = number
+  default     = 1
+
+  validation {
+    condition     = var.max_removal_fraction >= 0 && var.max_removal_fraction <= 1
+    error_message = "The max_removal_fraction must be between 0 and 1."
+  }
+}
+
 # Deployment Configuration
 
 variable "deployment_name" {
@@ -52,6 +174,53 @@ variable "deployment_name" {
   default     = "aws-auth-merger"
 }
 
+variable "deployment_replicas" {
+  description = "Number of replicas of the aws-auth-merger app to run. Only the replica that holds the leader election Lease syncs the aws-auth ConfigMap, while the others stand by to take over. Leader election is enabled automatically when running more than one replica."
+  type        = number
+  default     = 1
+}
+
+variable "shutdown_timeout" {
+  description = "How long the aws-auth-merger waits for an in-flight sync to complete when the Pod is stopped, before cancelling the requests to the Kubernetes API, as a duration string. Should be less than termination_grace_period_seconds."
+  type        = string
+  default     = "20s"
+}
+
+variable "metrics_port" {
+  description = "Port that the aws-auth-merger serves Prometheus metrics on, at the /metrics path. Set to 0 to disable the metrics endpoint."
+  type        = number
+  default     = 8080
+}
+
+variable "enable_prometheus_scrape_annotations" {
+  description = "When true, the prometheus.io/scrape, prometheus.io/port, and prometheus.io/path annotations are set on the aws-auth-merger Pods so that Prometheus scrapes the metrics endpoint. Annotations in pod_annotations take precedence. Ignored if metrics_port is 0."
+  type        = bool
+  default     = true
+}
+
+variable "health_port" {
+  description = "Port that the aws-auth-merger serves the liveness (/healthz) and readiness (/readyz) probes on. The probes are configured on the container when this is not 0. Set to 0 to disable the probes."
+  type        = number
+  default     = 8081
+}
+
+variable "liveness_interval_multiplier" {
+  description = "The liveness probe of the aws-auth-merger fails, and the container is restarted, when no sync has succeeded for this many refresh intervals (refresh_interval). Must be at least 1."
+  type        = number
+  default     = 3
+
+  validation {
+    condition     = var.liveness_interval_multiplier >= 1
+    error_message = "The liveness_interval_multiplier must be at least 1."
+  }
+}
+
+variable "termination_grace_period_seconds" {
+  description = "How long Kubernetes waits for the aws-auth-merger to shut down after sending SIGTERM, before killing it."
+  type        = number
+  default     = 30
+}
+
 variable "deployment_labels" {
   description = "Key value pairs of strings to apply as labels on the Deployment."
   type        = map(string)
@@ -76,6 +245,37 @@ variable "pod_annotations" {
   default     = {}
 }
 
+# Leader election configuration
+
+variable "enable_leader_election" {
+  description = "When true, the aws-auth-merger replicas use a Lease in the merger namespace to elect the replica that syncs the aws-auth ConfigMap. Leader election is always enabled when deployment_replicas is more than 1."
+  type        = bool
+  default     = false
+}
+
+variable "leader_election_lease_name" {
+  description = "Name of the Lease in the merger namespace to use for leader election."
+  type        = string
+  default     = "aws-auth-merger"
+}
+
+variable "leader_election_lease_duration" {
+  description = "How long standby replicas wait before taking over a Lease that has not been renewed by the leader, as a duration string (e.g. 15s for 15 seconds)."
+  type        = string
+  default     = "15s"
+}
+
+variable "leader_election_renew_deadline" {
+  description = "How long the leader keeps trying to renew the Lease before giving up leadership, as a duration string. Must be less than leader_election_lease_duration."
+  type        = string
+  default     = "10s"
+}
+
+variable "leader_election_retry_period" {
+  description = "How long to wait between attempts to acquire or renew the Lease, as a duration string."
+  type        = string
+  default     = "2s"
+}
 
 # ServiceAccount configuration
 